@@ -8,14 +8,24 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // snapshot cut-offs are in entity-local time zones
 
 	"go.uber.org/zap"
 
+	"github.com/example/trades-aggregator/internal/cache"
 	"github.com/example/trades-aggregator/internal/config"
 	"github.com/example/trades-aggregator/internal/db"
-	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/deadletter"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/entities"
 	httpserver "github.com/example/trades-aggregator/internal/http"
+	"github.com/example/trades-aggregator/internal/instruments"
 	kafkaconsumer "github.com/example/trades-aggregator/internal/kafka"
+	"github.com/example/trades-aggregator/internal/lots"
+	"github.com/example/trades-aggregator/internal/prices"
+	"github.com/example/trades-aggregator/internal/redis"
+	"github.com/example/trades-aggregator/internal/snapshots"
+	"github.com/example/trades-aggregator/internal/stream"
 )
 
 func main() {
@@ -40,20 +50,133 @@ func main() {
 	}
 	defer dbpool.Close()
 
+	// Entity registry; trades, queries and cut-offs are checked against it
+	entSvc := entities.New(dbpool)
+	if err := entSvc.Load(ctx); err != nil {
+		logger.Fatal("entities_load_failed", zap.Error(err))
+	}
+	domain.SetEntityRegistry(entSvc)
+	go entSvc.Run(ctx, cfg.EntitiesRefresh, logger)
+
+	// Instrument master; contract sizes scale costs, P&L and valuations
+	instSvc := instruments.New(dbpool)
+	if err := instSvc.Load(ctx); err != nil {
+		logger.Fatal("instruments_load_failed", zap.Error(err))
+	}
+	domain.SetInstrumentRegistry(instSvc)
+	go instSvc.Run(ctx, cfg.InstrumentsRefresh, logger)
+
 	// Domain services
-	svc := holdings.New(dbpool)
+	svc, lotsSvc := lots.NewHoldings(dbpool)
+	dlqSvc := deadletter.New(dbpool, svc)
+	snapSvc := snapshots.New(dbpool, svc)
+	snapSvc.MaxCatchUp = cfg.SnapshotCatchUp
+	priceSvc := prices.New(dbpool, cfg.PriceStaleAfter)
+	if err := priceSvc.Load(ctx); err != nil {
+		logger.Fatal("prices_load_failed", zap.Error(err))
+	}
+
+	schedule, err := snapshots.ParseSchedule(cfg.SnapshotClose, cfg.SnapshotCutoffs)
+	if err != nil {
+		logger.Fatal("config_invalid", zap.Error(err))
+	}
+
+	cacheOpts := httpserver.CacheOptions{TTL: cfg.CacheTTL, MaxEntries: cfg.CacheMaxEntries, RedisPrefix: cfg.CacheRedisPrefix}
+	var rc *redis.Client
+	if cfg.RedisURL != "" {
+		rc, err = redis.New(cfg.RedisURL)
+		if err != nil {
+			logger.Fatal("config_invalid", zap.Error(err))
+		}
+		defer rc.Close()
+		cacheOpts.Invalidations = cache.NewBroadcaster(rc, cfg.CacheRedisPrefix+":invalidate", logger)
+		if cfg.CacheBackend == "redis" {
+			cacheOpts.Redis = rc
+		}
+	}
+	switch {
+	case cfg.CacheBackend == "redis" && cacheOpts.Redis == nil:
+		logger.Fatal("config_invalid", zap.String("error", "CACHE_BACKEND=redis requires REDIS_URL"))
+	case cfg.CacheBackend != "redis" && cfg.CacheBackend != "memory":
+		logger.Fatal("config_invalid", zap.String("error", "CACHE_BACKEND must be 'memory' or 'redis'"))
+	}
+
+	// Live stream of applied trades and alerts, shared between replicas through Redis
+	hub := stream.NewHub(cfg.StreamHistory, cfg.StreamClientBuffer, logger)
+	if rc != nil {
+		hub.Relay = stream.NewRelay(rc, cfg.CacheRedisPrefix+":stream", logger)
+		go hub.Relay.Run(ctx, hub)
+	}
+	svc.Observers = append(svc.Observers, hub)
+	dlqSvc.Observers = append(dlqSvc.Observers, hub)
+
+	// HTTP server (the HTTP package constructs its own typed caches). It is
+	// built before the consumer starts so no commit misses its invalidation.
+	router := httpserver.NewServer(httpserver.Services{
+		Holdings:    svc,
+		DeadLetters: dlqSvc,
+		Entities:    entSvc,
+		Instruments: instSvc,
+		Lots:        lotsSvc,
+		Prices:      priceSvc,
+		Snapshots:   snapSvc,
+		Stream:      hub,
+	}, logger, httpserver.Access{CORSOrigin: cfg.CORSOrigin, APIToken: cfg.APIToken}, cacheOpts)
+	svc.Observers = append(svc.Observers, router)
+	if cacheOpts.Invalidations != nil {
+		go cacheOpts.Invalidations.Run(ctx, router.InvalidateEntities, router.ClearCaches)
+	}
 
 	// Kafka consumer (lifecycle tied to ctx)
-	consumer := kafkaconsumer.NewConsumer(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroupID, svc, logger)
+	onExhausted, err := kafkaconsumer.ParseExhaustedAction(cfg.KafkaOnRetriesExhausted)
+	if err != nil {
+		logger.Fatal("config_invalid", zap.Error(err))
+	}
+	onUnknown, err := kafkaconsumer.ParseUnknownInstrumentAction(cfg.KafkaOnUnknownInstrument)
+	if err != nil {
+		logger.Fatal("config_invalid", zap.Error(err))
+	}
+	retry := kafkaconsumer.RetryPolicy{
+		MaxRetries:  cfg.KafkaMaxRetries,
+		Backoff:     cfg.KafkaRetryBackoff,
+		MaxBackoff:  cfg.KafkaRetryMaxBackoff,
+		OnExhausted: onExhausted,
+	}
+	dlq := kafkaconsumer.NewDeadLetterQueue(cfg.KafkaBrokers, cfg.KafkaDLQTopic, dlqSvc)
+	batch := kafkaconsumer.BatchPolicy{Size: cfg.KafkaBatchSize, Wait: cfg.KafkaBatchWait}
+	consumer := kafkaconsumer.NewConsumer(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroupID, retry, batch, dlq, svc, logger)
+	consumer.OnUnknownInstrument = onUnknown
+	consumerErr := make(chan error, 2)
 	go func() {
-		if err := consumer.Run(ctx); err != nil {
+		if err := consumer.Run(ctx); err != nil && ctx.Err() == nil {
 			logger.Error("kafka_consumer_error", zap.Error(err))
-			cancel() // propagate failure
+			consumerErr <- err
 		}
 	}()
 
-	// HTTP server (the HTTP package now constructs its own typed caches)
-	router := httpserver.NewServer(svc, logger, cfg.CORSOrigin)
+	// Dead letters are committed to the DLQ topic; this records them in the
+	// database, waiting out outages, for the admin API and re-drives.
+	if cfg.KafkaDLQTopic != "" {
+		recorder := kafkaconsumer.NewDeadLetterRecorder(cfg.KafkaBrokers, cfg.KafkaDLQTopic, cfg.KafkaGroupID+"-dlq", retry, dlqSvc, logger)
+		go func() {
+			if err := recorder.Run(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("kafka_dlq_recorder_error", zap.Error(err))
+				consumerErr <- err
+			}
+		}()
+	}
+
+	// Market prices are best-effort: a failing price feed only leaves
+	// valuations stale until it recovers, so it does not take the process
+	// down but is restarted.
+	if cfg.KafkaPricesTopic != "" {
+		go kafkaconsumer.RunPriceConsumer(ctx, cfg.KafkaBrokers, cfg.KafkaPricesTopic, cfg.KafkaGroupID+"-prices", batch, retry, priceSvc, logger)
+	}
+
+	if cfg.HoldingsCheckpointInterval > 0 {
+		go svc.RunCheckpoints(ctx, cfg.HoldingsCheckpointInterval, logger)
+	}
+	go snapSvc.Run(ctx, schedule, entSvc.All, cfg.EntitiesRefresh, logger)
 
 	srv := &http.Server{
 		Addr:              ":" + cfg.Port,
@@ -72,14 +195,22 @@ func main() {
 		}
 	}()
 
-	// Graceful shutdown on SIGINT/SIGTERM
+	// Graceful shutdown on SIGINT/SIGTERM, or when the consumer gives up so
+	// the orchestrator restarts us and the uncommitted message is redelivered
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-sigCh
-	logger.Info("shutdown_signal", zap.String("signal", sig.String()))
+	exitCode := 0
+	select {
+	case sig := <-sigCh:
+		logger.Info("shutdown_signal", zap.String("signal", sig.String()))
+	case <-consumerErr:
+		logger.Info("shutdown_consumer_stopped")
+		exitCode = 1
+	}
 
-	// Cancel background work (consumer) and shutdown HTTP
+	// Cancel background work (consumer), end live streams and shutdown HTTP
 	cancel()
+	hub.Close()
 
 	ctxShut, cancelShut := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelShut()
@@ -88,4 +219,8 @@ func main() {
 	}
 
 	logger.Info("shutdown_complete")
+	if exitCode != 0 {
+		_ = logger.Sync()
+		os.Exit(exitCode)
+	}
 }
@@ -0,0 +1,158 @@
+// Command tradesctl runs maintenance tasks against the trades database.
+//
+//	tradesctl rebuild-holdings           recompute holdings from the trades ledger
+//	tradesctl rebuild-lots [-entity E]   recompute tax lots from the trades ledger
+//	tradesctl import-csv [flags] FILE    load trades from a broker CSV export
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/example/trades-aggregator/internal/csvimport"
+	"github.com/example/trades-aggregator/internal/db"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/entities"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/instruments"
+	"github.com/example/trades-aggregator/internal/lots"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: tradesctl [-database-url URL] <command>
+
+commands:
+  rebuild-holdings          recompute holdings (quantity, cost, P&L) from trades
+  rebuild-lots [-entity E]  recompute tax lots and realizations from trades
+  import-csv [flags] FILE   load trades from CSV (-h for flags); -dry-run
+                            previews the holdings changes without applying
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbURL := flag.String("database-url", os.Getenv("DATABASE_URL"), "Postgres connection URL (default $DATABASE_URL)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 || *dbURL == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := db.Connect(ctx, *dbURL)
+	if err != nil {
+		log.Fatalf("db: %v", err)
+	}
+	defer pool.Close()
+	loadReferenceData(ctx, pool)
+
+	switch cmd := flag.Arg(0); cmd {
+	case "rebuild-holdings":
+		n, err := holdings.New(pool).Rebuild(ctx)
+		if err != nil {
+			log.Fatalf("rebuild-holdings: %v", err)
+		}
+		log.Printf("rebuild-holdings: %d holdings written", n)
+	case "rebuild-lots":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		entity := fs.String("entity", "", "only rebuild this entity (default: all)")
+		_ = fs.Parse(flag.Args()[1:])
+		n, err := lots.New(pool).Rebuild(ctx, *entity)
+		if err != nil {
+			log.Fatalf("rebuild-lots: %v", err)
+		}
+		log.Printf("rebuild-lots: %d trades replayed", n)
+	case "import-csv":
+		importCSV(ctx, pool, flag.Args()[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// loadReferenceData loads the entity registry and the instrument master,
+// which validation and the holdings arithmetic consult, as the server does.
+func loadReferenceData(ctx context.Context, pool *pgxpool.Pool) {
+	ents := entities.New(pool)
+	if err := ents.Load(ctx); err != nil {
+		log.Fatalf("load entities: %v", err)
+	}
+	domain.SetEntityRegistry(ents)
+	insts := instruments.New(pool)
+	if err := insts.Load(ctx); err != nil {
+		log.Fatalf("load instruments: %v", err)
+	}
+	domain.SetInstrumentRegistry(insts)
+}
+
+// importCSV loads trades from a CSV file through the same idempotent path
+// as the HTTP import. The running server's caches pick the trades up within
+// CACHE_TTL.
+func importCSV(ctx context.Context, pool *pgxpool.Pool, args []string) {
+	fs := flag.NewFlagSet("import-csv", flag.ExitOnError)
+	mapping := fs.String("map", "", "column mapping, field=Header,... (fields: trade_id, entity, instrument_type, symbol, quantity, price, ts, side)")
+	delimiter := fs.String("delimiter", ",", "field delimiter")
+	timeFormat := fs.String("time-format", "", "Go layout of the ts column (default: RFC 3339 or 2006-01-02[ 15:04:05], UTC)")
+	key := fs.String("key", "", "namespaces the trade_ids derived from row content, keeping identical trades of other files apart")
+	dryRun := fs.Bool("dry-run", false, "validate and preview holdings changes; apply nothing")
+	report := fs.String("report", "-", "write the per-row report as CSV to this file (- for stdout)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("import-csv: want exactly one FILE")
+	}
+
+	m, err := csvimport.ParseMapping(*mapping)
+	if err != nil {
+		log.Fatalf("import-csv: %v", err)
+	}
+	comma, size := utf8.DecodeRuneInString(*delimiter)
+	if size == 0 || size != len(*delimiter) {
+		log.Fatalf("import-csv: -delimiter must be a single character")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("import-csv: %v", err)
+	}
+	rows, err := csvimport.Parse(data, csvimport.Options{Mapping: m, Comma: comma, TimeFormat: *timeFormat, Key: *key})
+	if err != nil {
+		log.Fatalf("import-csv: %v", err)
+	}
+	svc, _ := lots.NewHoldings(pool)
+	rep, err := csvimport.Import(ctx, svc, rows, models.SourceCSV, *dryRun)
+	if err != nil {
+		log.Fatalf("import-csv: %v", err)
+	}
+
+	out := os.Stdout
+	if *report != "-" {
+		if out, err = os.Create(*report); err != nil {
+			log.Fatalf("import-csv: %v", err)
+		}
+		defer out.Close()
+	}
+	if err := rep.WriteCSV(out); err != nil {
+		log.Fatalf("import-csv: write report: %v", err)
+	}
+	for _, h := range rep.Holdings {
+		log.Printf("import-csv: %s/%s/%s: quantity %s -> %s, realized P&L %s -> %s (%d trades)",
+			h.After.Entity, h.After.InstrumentType, h.After.Symbol, h.Before.Quantity, h.After.Quantity,
+			h.Before.RealizedPnL, h.After.RealizedPnL, h.Trades)
+	}
+	verb := "applied"
+	if *dryRun {
+		verb = "would apply"
+	}
+	log.Printf("import-csv: %d rows: %s %d, %d duplicates, %d rejected", rep.Total, verb, rep.Accepted, rep.Duplicates, rep.Rejected)
+}
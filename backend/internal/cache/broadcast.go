@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/redis"
+	"go.uber.org/zap"
+)
+
+// Broadcaster fans cache invalidations out to every replica over Redis
+// pub/sub, so a trade applied on one replica clears the cached views of all
+// of them. Messages a replica published itself are ignored on receipt.
+type Broadcaster struct {
+	Client  *redis.Client
+	Channel string
+	Logger  *zap.Logger
+	origin  string
+}
+
+type invalidation struct {
+	Origin   string   `json:"origin"`
+	Entities []string `json:"entities"`
+}
+
+func NewBroadcaster(client *redis.Client, channel string, logger *zap.Logger) *Broadcaster {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return &Broadcaster{Client: client, Channel: channel, Logger: logger, origin: hex.EncodeToString(id[:])}
+}
+
+// Publish announces that the cached views of entities are stale.
+func (b *Broadcaster) Publish(ctx context.Context, entities []string) {
+	msg, _ := json.Marshal(invalidation{Origin: b.origin, Entities: entities})
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if err := b.Client.Publish(ctx, b.Channel, msg); err != nil {
+		b.Logger.Warn("cache_invalidation_publish_failed", zap.Error(err))
+	}
+}
+
+// Run calls apply for every invalidation published by another replica until
+// ctx is cancelled, resubscribing after connection failures. Everything is
+// invalidated after a reconnect since messages may have been missed.
+func (b *Broadcaster) Run(ctx context.Context, apply func(entities []string), clear func()) {
+	backoff := time.Second
+	for {
+		err := b.Client.Subscribe(ctx, b.Channel, func(msg []byte) {
+			backoff = time.Second
+			var inv invalidation
+			if err := json.Unmarshal(msg, &inv); err != nil {
+				b.Logger.Warn("cache_invalidation_bad_message", zap.Error(err))
+				return
+			}
+			if inv.Origin != b.origin {
+				apply(inv.Entities)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		b.Logger.Warn("cache_invalidation_subscribe_failed", zap.Duration("retry_in", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		clear()
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/redis"
+	"github.com/example/trades-aggregator/internal/redis/redistest"
+	"go.uber.org/zap"
+)
+
+func TestBroadcaster(t *testing.T) {
+	srv := redistest.NewServer(t)
+	client, err := redis.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := NewBroadcaster(client, "inv", zap.NewNop())
+	b := NewBroadcaster(client, "inv", zap.NewNop())
+	applied := make(chan []string, 10)
+	cleared := make(chan struct{}, 10)
+	go a.Run(ctx, func(e []string) { applied <- append([]string{"a"}, e...) }, func() { cleared <- struct{}{} })
+	go b.Run(ctx, func(e []string) { applied <- append([]string{"b"}, e...) }, func() { cleared <- struct{}{} })
+	waitFor(t, func() bool { return srv.Subscribers("inv") == 2 })
+
+	// A replica ignores its own invalidations.
+	a.Publish(ctx, []string{"zurich", "tokyo"})
+	select {
+	case got := <-applied:
+		if want := []string{"b", "zurich", "tokyo"}; !slices.Equal(got, want) {
+			t.Errorf("applied %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("invalidation not received")
+	}
+	select {
+	case got := <-applied:
+		t.Errorf("also applied %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Messages published while a subscriber reconnects are lost, so it
+	// clears everything once back.
+	srv.DropConnections()
+	for range 2 {
+		select {
+		case <-cleared:
+		case <-time.After(5 * time.Second):
+			t.Fatal("caches not cleared after a reconnect")
+		}
+	}
+	waitFor(t, func() bool { return srv.Subscribers("inv") == 2 })
+}
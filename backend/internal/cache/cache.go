@@ -0,0 +1,28 @@
+package cache
+
+import "context"
+
+// Cache is a read-through response cache. Implementations must make sure a
+// value loaded before an invalidation is not served after it (for Redis,
+// once the Generation is bumped).
+type Cache[K comparable, V any] interface {
+	// GetOrLoad returns the cached value for k or calls load to produce it.
+	GetOrLoad(ctx context.Context, k K, load func(context.Context) (V, error)) (V, error)
+	Delete(k K)
+	// DeleteFunc invalidates every key for which match returns true. A
+	// backend may invalidate more than that, never less.
+	DeleteFunc(match func(K) bool)
+	Clear()
+	Stats() Stats
+}
+
+// Key is a cache key that can be stored in a shared backend.
+type Key interface {
+	comparable
+	String() string
+}
+
+var (
+	_ Cache[HoldingsKey, int] = (*LRU[HoldingsKey, int])(nil)
+	_ Cache[HoldingsKey, int] = (*Redis[HoldingsKey, int])(nil)
+)
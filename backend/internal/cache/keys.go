@@ -1,6 +1,10 @@
 package cache
 
-import "github.com/example/trades-aggregator/internal/domain"
+import (
+	"strconv"
+
+	"github.com/example/trades-aggregator/internal/domain"
+)
 
 // HoldingsKey: “all” means aggregate over all entities.
 type HoldingsKey struct {
@@ -14,18 +18,25 @@ func HoldingsByEntity(e domain.Entity) HoldingsKey {
 	return HoldingsKey{Entity: e}
 }
 
-// TradesKey: identify a trades query. Entity=all means no filter.
+// TradesKey: identify a trades query. Entity=all means no filter; Query is
+// the canonical form of the other filters and the cursor ("" for none).
 type TradesKey struct {
 	Entity domain.Entity
 	Limit  uint16
+	Query  string
 }
 
-func Trades(e domain.Entity, limit int) TradesKey {
+func Trades(e domain.Entity, limit int, query string) TradesKey {
 	if limit < 0 {
 		limit = 0
 	}
 	if limit > 65535 {
 		limit = 65535
 	}
-	return TradesKey{Entity: e, Limit: uint16(limit)}
+	return TradesKey{Entity: e, Limit: uint16(limit), Query: query}
+}
+
+func (k HoldingsKey) String() string { return "holdings:" + k.Entity.String() }
+func (k TradesKey) String() string {
+	return "trades:" + k.Entity.String() + ":" + strconv.Itoa(int(k.Limit)) + ":" + k.Query
 }
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the counters of an LRU cache, for monitoring.
+type Stats struct {
+	Backend   string `json:"backend"`
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Expired   uint64 `json:"expired"`
+	// Coalesced counts misses that waited for another caller's load of the
+	// same key instead of loading it themselves.
+	Coalesced uint64 `json:"coalesced"`
+	// Errors counts backend failures; the cache is bypassed when they occur.
+	Errors uint64 `json:"errors"`
+}
+
+// LRU is a size-bounded cache that evicts the least recently used entry.
+// Entries also expire ttl after being set (never when ttl <= 0).
+//
+// GetOrLoad coalesces concurrent misses: one caller loads, the others wait
+// for its result. Every invalidation bumps a generation, and a load that
+// started before an invalidation is neither stored nor joined by callers
+// arriving after it.
+type LRU[K comparable, V any] struct {
+	capacity int
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	gen      uint64
+	items    map[K]*list.Element // of *lruEntry[K, V]
+	order    *list.List          // front: most recently used
+	inflight map[K]*call[V]
+
+	hits, misses, evictions, expired, coalesced atomic.Uint64
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	v       V
+	expires time.Time // zero: never
+}
+
+type call[V any] struct {
+	gen  uint64
+	done chan struct{}
+	v    V
+	err  error
+}
+
+// NewLRU returns a cache holding at most capacity entries (at least one).
+func NewLRU[K comparable, V any](capacity int, ttl time.Duration) *LRU[K, V] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRU[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[K]*list.Element),
+		order:    list.New(),
+		inflight: make(map[K]*call[V]),
+	}
+}
+
+// get looks k up and marks it used; c.mu must be held.
+func (c *LRU[K, V]) get(k K) (V, bool) {
+	var z V
+	el, ok := c.items[k]
+	if !ok {
+		return z, false
+	}
+	e := el.Value.(*lruEntry[K, V])
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(el)
+		c.expired.Add(1)
+		return z, false
+	}
+	c.order.MoveToFront(el)
+	return e.v, true
+}
+
+// set stores v and evicts down to capacity; c.mu must be held.
+func (c *LRU[K, V]) set(k K, v V) {
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+	if el, ok := c.items[k]; ok {
+		e := el.Value.(*lruEntry[K, V])
+		e.v, e.expires = v, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.order.PushFront(&lruEntry[K, V]{key: k, v: v, expires: expires})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *LRU[K, V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*lruEntry[K, V]).key)
+}
+
+// GetOrLoad returns the cached value for k or loads it, sharing one load
+// among concurrent callers. load runs detached from the caller's
+// cancellation so that waiters are not failed by the first caller going
+// away; each caller still stops waiting when its own ctx is done.
+func (c *LRU[K, V]) GetOrLoad(ctx context.Context, k K, load func(context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	if v, ok := c.get(k); ok {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return v, nil
+	}
+	c.misses.Add(1)
+	cl, ok := c.inflight[k]
+	if ok && cl.gen == c.gen {
+		c.mu.Unlock()
+		c.coalesced.Add(1)
+		return wait(ctx, cl)
+	}
+	cl = &call[V]{gen: c.gen, done: make(chan struct{})}
+	c.inflight[k] = cl
+	c.mu.Unlock()
+
+	go func() {
+		defer close(cl.done)
+		cl.v, cl.err = load(context.WithoutCancel(ctx))
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.inflight[k] == cl {
+			delete(c.inflight, k)
+		}
+		if cl.err == nil && c.gen == cl.gen {
+			c.set(k, cl.v)
+		}
+	}()
+	return wait(ctx, cl)
+}
+
+func wait[V any](ctx context.Context, cl *call[V]) (V, error) {
+	select {
+	case <-cl.done:
+		return cl.v, cl.err
+	case <-ctx.Done():
+		var z V
+		return z, ctx.Err()
+	}
+}
+
+func (c *LRU[K, V]) Delete(k K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.items[k]; ok {
+		c.remove(el)
+	}
+}
+
+// DeleteFunc removes every key for which match returns true.
+func (c *LRU[K, V]) DeleteFunc(match func(K) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for k, el := range c.items {
+		if match(k) {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *LRU[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.items = make(map[K]*list.Element)
+	c.order.Init()
+}
+
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU[K, V]) Stats() Stats {
+	return Stats{
+		Backend:   "memory",
+		Entries:   c.Len(),
+		Capacity:  c.capacity,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Expired:   c.expired.Load(),
+		Coalesced: c.coalesced.Load(),
+	}
+}
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/redis"
+	"go.uber.org/zap"
+)
+
+// redisTimeout bounds every cache round trip; a slow cache is bypassed.
+const redisTimeout = 500 * time.Millisecond
+
+// Redis is a Cache shared by every replica through a Redis-protocol server.
+// Values are stored as JSON under <prefix>:<key> with the TTL as expiry.
+//
+// Values are stored tagged with the Generation they were loaded under; a
+// value whose tag is not the current generation is a miss. Bumping the
+// generation thus invalidates every key of every cache sharing it (deleting
+// by predicate would need a scan of the keyspace) and closes the race
+// between a slow load and a concurrent invalidation on another replica.
+// Since the entries are shared, the replica committing trades bumps it once;
+// Delete, DeleteFunc and Clear only drop this replica's pending loads.
+//
+// Concurrent misses on the same replica are coalesced as in LRU. Backend
+// errors are logged and counted, and the request falls through to load.
+type Redis[K Key, V any] struct {
+	client *redis.Client
+	gen    *Generation
+	prefix string
+	ttl    time.Duration
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	inflight map[K]*call[V]
+
+	hits, misses, coalesced, errors atomic.Uint64
+}
+
+type redisEnvelope[V any] struct {
+	Gen   string `json:"g"`
+	Value V      `json:"v"`
+}
+
+// NewRedis returns a cache whose entries live for ttl (one hour when ttl <= 0,
+// so that superseded entries do not accumulate) and are invalidated by gen.
+func NewRedis[K Key, V any](client *redis.Client, gen *Generation, prefix string, ttl time.Duration, logger *zap.Logger) *Redis[K, V] {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &Redis[K, V]{client: client, gen: gen, prefix: prefix, ttl: ttl, logger: logger, inflight: make(map[K]*call[V])}
+}
+
+func (c *Redis[K, V]) valueKey(k K) string { return c.prefix + ":" + k.String() }
+
+func (c *Redis[K, V]) GetOrLoad(ctx context.Context, k K, load func(context.Context) (V, error)) (V, error) {
+	gen, v, ok := c.lookup(ctx, k)
+	if ok {
+		c.hits.Add(1)
+		return v, nil
+	}
+	c.misses.Add(1)
+
+	c.mu.Lock()
+	if cl, ok := c.inflight[k]; ok && cl.gen == gen {
+		c.mu.Unlock()
+		c.coalesced.Add(1)
+		return wait(ctx, cl)
+	}
+	cl := &call[V]{gen: gen, done: make(chan struct{})}
+	c.inflight[k] = cl
+	c.mu.Unlock()
+
+	go func() {
+		defer close(cl.done)
+		lctx := context.WithoutCancel(ctx)
+		cl.v, cl.err = load(lctx)
+		c.mu.Lock()
+		if c.inflight[k] == cl {
+			delete(c.inflight, k)
+		}
+		c.mu.Unlock()
+		if cl.err == nil {
+			c.store(lctx, k, cl.gen, cl.v)
+		}
+	}()
+	return wait(ctx, cl)
+}
+
+// lookup fetches the generation and the value for k in one round trip. gen
+// is what a value loaded now must be tagged with.
+func (c *Redis[K, V]) lookup(ctx context.Context, k K) (gen uint64, v V, ok bool) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	reply, err := c.client.Do(ctx, "MGET", c.gen.key, c.valueKey(k))
+	if err != nil {
+		c.fail("cache_get_failed", err)
+		return 0, v, false
+	}
+	vals, _ := reply.([]any)
+	if len(vals) != 2 {
+		c.fail("cache_get_failed", redis.Error("unexpected MGET reply"))
+		return 0, v, false
+	}
+	if b, err := redis.Bytes(vals[0]); err == nil {
+		gen, _ = strconv.ParseUint(string(b), 10, 64)
+	}
+	b, err := redis.Bytes(vals[1])
+	if err != nil {
+		return gen, v, false
+	}
+	var env redisEnvelope[V]
+	if err := json.Unmarshal(b, &env); err != nil || env.Gen != strconv.FormatUint(gen, 10) {
+		return gen, v, false
+	}
+	return gen, env.Value, true
+}
+
+func (c *Redis[K, V]) store(ctx context.Context, k K, gen uint64, v V) {
+	b, err := json.Marshal(redisEnvelope[V]{Gen: strconv.FormatUint(gen, 10), Value: v})
+	if err != nil {
+		c.fail("cache_set_failed", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	ms := strconv.FormatInt(c.ttl.Milliseconds(), 10)
+	if _, err := c.client.Do(ctx, "SET", c.valueKey(k), string(b), "PX", ms); err != nil {
+		c.fail("cache_set_failed", err)
+	}
+}
+
+// Delete, DeleteFunc and Clear stop the replica's loads in flight for the
+// keys from being shared with later misses; the stored values are retired by
+// Generation.Bump.
+func (c *Redis[K, V]) Delete(k K) {
+	c.DeleteFunc(func(key K) bool { return key == k })
+}
+
+func (c *Redis[K, V]) DeleteFunc(match func(K) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.inflight {
+		if match(k) {
+			delete(c.inflight, k)
+		}
+	}
+}
+
+func (c *Redis[K, V]) Clear() { c.DeleteFunc(func(K) bool { return true }) }
+
+func (c *Redis[K, V]) fail(event string, err error) {
+	c.errors.Add(1)
+	c.logger.Warn(event, zap.String("prefix", c.prefix), zap.Error(err))
+}
+
+func (c *Redis[K, V]) Stats() Stats {
+	return Stats{
+		Backend:   "redis",
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Coalesced: c.coalesced.Load(),
+		Errors:    c.errors.Load(),
+	}
+}
+
+// Generation is the counter tagging the values of the Redis caches that
+// share it, stored at its key.
+type Generation struct {
+	client  *redis.Client
+	key     string
+	logger  *zap.Logger
+	pending atomic.Bool
+}
+
+func NewGeneration(client *redis.Client, key string, logger *zap.Logger) *Generation {
+	return &Generation{client: client, key: key, logger: logger}
+}
+
+// Bump retires every value stored under the generation. It does not wait
+// for the server: the counter is incremented in the background, and bumps
+// made before that starts are folded into the one increment.
+func (g *Generation) Bump() {
+	if g.pending.Swap(true) {
+		return
+	}
+	go func() {
+		g.pending.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		if _, err := g.client.Do(ctx, "INCR", g.key); err != nil {
+			g.logger.Warn("cache_invalidate_failed", zap.String("key", g.key), zap.Error(err))
+		}
+	}()
+}
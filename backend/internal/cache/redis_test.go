@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/redis"
+	"github.com/example/trades-aggregator/internal/redis/redistest"
+	"go.uber.org/zap"
+)
+
+func newRedis(t *testing.T, srv *redistest.Server) (*Redis[HoldingsKey, []string], *Generation) {
+	t.Helper()
+	client, err := redis.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	gen := NewGeneration(client, "test:gen", zap.NewNop())
+	return NewRedis[HoldingsKey, []string](client, gen, "test:holdings", time.Minute, zap.NewNop()), gen
+}
+
+// loader counts its calls and returns the values it is given in turn.
+type loader struct {
+	calls atomic.Int32
+	vals  [][]string
+}
+
+func (l *loader) load(context.Context) ([]string, error) {
+	n := l.calls.Add(1)
+	return l.vals[min(int(n), len(l.vals))-1], nil
+}
+
+func TestRedisGetOrLoad(t *testing.T) {
+	srv := redistest.NewServer(t)
+	c, _ := newRedis(t, srv)
+	ctx := context.Background()
+	k := HoldingsByEntity(domain.Entity("zurich"))
+	l := &loader{vals: [][]string{{"AAPL"}}}
+
+	for i := 0; i < 3; i++ {
+		v, err := c.GetOrLoad(ctx, k, l.load)
+		if err != nil || len(v) != 1 || v[0] != "AAPL" {
+			t.Fatalf("GetOrLoad = %v, %v", v, err)
+		}
+		if i == 0 {
+			// The value is stored in the background once loaded.
+			waitFor(t, func() bool { _, ok := srv.Get("test:holdings:" + k.String()); return ok })
+		}
+	}
+	if n := l.calls.Load(); n != 1 {
+		t.Errorf("loaded %d times, want once", n)
+	}
+	if ttl := srv.TTL("test:holdings:" + k.String()); ttl <= 59*time.Second || ttl > time.Minute {
+		t.Errorf("stored with TTL %s, want 1m", ttl)
+	}
+	if st := c.Stats(); st.Hits != 2 || st.Misses != 1 || st.Errors != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestRedisGenerationBump(t *testing.T) {
+	srv := redistest.NewServer(t)
+	c, gen := newRedis(t, srv)
+	ctx := context.Background()
+	k := HoldingsByEntity(domain.Entity("zurich"))
+	l := &loader{vals: [][]string{{"old"}, {"new"}}}
+
+	if _, err := c.GetOrLoad(ctx, k, l.load); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { _, ok := srv.Get("test:holdings:" + k.String()); return ok })
+
+	// Deletes only drop local state: the shared entries stay until the
+	// generation is bumped, once however many deletes the batch made.
+	c.Delete(k)
+	c.DeleteFunc(func(HoldingsKey) bool { return true })
+	c.Clear()
+	if v, _ := c.GetOrLoad(ctx, k, l.load); v[0] != "old" {
+		t.Errorf("after Delete = %v, want the stored value", v)
+	}
+	if n := srv.Calls("INCR"); n != 0 {
+		t.Errorf("Delete sent INCR %d times", n)
+	}
+
+	gen.Bump()
+	waitFor(t, func() bool { v, _ := srv.Get("test:gen"); return v == "1" })
+	if v, _ := c.GetOrLoad(ctx, k, l.load); v[0] != "new" {
+		t.Errorf("after Bump = %v, want a fresh load", v)
+	}
+	if n := srv.Calls("INCR"); n != 1 {
+		t.Errorf("INCR sent %d times, want once", n)
+	}
+}
+
+func TestRedisStoredUnderOldGeneration(t *testing.T) {
+	srv := redistest.NewServer(t)
+	c, _ := newRedis(t, srv)
+	k := HoldingsByEntity(domain.Entity("zurich"))
+
+	// Stored by a load that raced with another replica's invalidation.
+	srv.Set("test:gen", "5")
+	srv.Set("test:holdings:"+k.String(), `{"g":"4","v":["stale"]}`)
+	l := &loader{vals: [][]string{{"fresh"}}}
+	if v, _ := c.GetOrLoad(context.Background(), k, l.load); v[0] != "fresh" {
+		t.Errorf("GetOrLoad = %v, want a fresh load", v)
+	}
+	waitFor(t, func() bool {
+		v, _ := srv.Get("test:holdings:" + k.String())
+		return v == `{"g":"5","v":["fresh"]}`
+	})
+}
+
+func TestRedisBackendDown(t *testing.T) {
+	srv := redistest.NewServer(t)
+	c, gen := newRedis(t, srv)
+	srv.Close()
+
+	l := &loader{vals: [][]string{{"AAPL"}}}
+	v, err := c.GetOrLoad(context.Background(), HoldingsAll(), l.load)
+	if err != nil || v[0] != "AAPL" {
+		t.Fatalf("GetOrLoad with the backend down = %v, %v", v, err)
+	}
+	gen.Bump() // logged, not fatal
+	// The failed lookup and store are counted.
+	waitFor(t, func() bool { return c.Stats().Errors >= 2 })
+}
+
+func TestRedisLoadError(t *testing.T) {
+	srv := redistest.NewServer(t)
+	c, _ := newRedis(t, srv)
+	k := HoldingsAll()
+	boom := errors.New("boom")
+
+	_, err := c.GetOrLoad(context.Background(), k, func(context.Context) ([]string, error) { return nil, boom })
+	if !errors.Is(err, boom) {
+		t.Fatalf("GetOrLoad = %v, want the load error", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := srv.Get("test:holdings:" + k.String()); ok {
+		t.Error("failed load was stored")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
@@ -14,6 +14,74 @@ type Config struct {
 	Port         string        `env:"PORT" envDefault:"8080"`
 	CORSOrigin   string        `env:"CORS_ORIGIN" envDefault:"*"`
 	CacheTTL     time.Duration `env:"CACHE_TTL" envDefault:"60s"`
+
+	// Bearer token required by the write and admin routes; empty disables
+	// them. Browsers may only write from a CORS_ORIGIN other than "*".
+	APIToken string `env:"API_TOKEN"`
+
+	// Entries kept per response cache before the least recently used go.
+	CacheMaxEntries int `env:"CACHE_MAX_ENTRIES" envDefault:"1024"`
+
+	// Response cache backend: "memory" (per replica) or "redis" (shared,
+	// needs REDIS_URL). With REDIS_URL set, invalidations are also broadcast
+	// to every replica over pub/sub whichever backend is used.
+	CacheBackend     string `env:"CACHE_BACKEND" envDefault:"memory"`
+	RedisURL         string `env:"REDIS_URL"`
+	CacheRedisPrefix string `env:"CACHE_REDIS_PREFIX" envDefault:"trades-aggregator"`
+
+	// Retry of transient DB failures while applying consumed trades.
+	KafkaMaxRetries         int           `env:"KAFKA_MAX_RETRIES" envDefault:"5"`
+	KafkaRetryBackoff       time.Duration `env:"KAFKA_RETRY_BACKOFF" envDefault:"200ms"`
+	KafkaRetryMaxBackoff    time.Duration `env:"KAFKA_RETRY_MAX_BACKOFF" envDefault:"10s"`
+	KafkaOnRetriesExhausted string        `env:"KAFKA_ON_RETRIES_EXHAUSTED" envDefault:"stop"`
+
+	// Messages applied per transaction, and how long to wait filling a batch.
+	KafkaBatchSize int           `env:"KAFKA_BATCH_SIZE" envDefault:"500"`
+	KafkaBatchWait time.Duration `env:"KAFKA_BATCH_WAIT" envDefault:"250ms"`
+
+	// Dead-letter topic for messages that cannot be applied. Writing to it
+	// is what lets the consumer move on; the dead_letters table is filled
+	// from it asynchronously. Empty writes to the table directly.
+	KafkaDLQTopic string `env:"KAFKA_DLQ_TOPIC" envDefault:"trades.dlq"`
+
+	// Market prices topic; empty disables the price consumer (prices can
+	// still be uploaded over HTTP). Prices older than PriceStaleAfter are
+	// flagged as stale in valuations.
+	KafkaPricesTopic string        `env:"KAFKA_PRICES_TOPIC" envDefault:"prices"`
+	PriceStaleAfter  time.Duration `env:"PRICE_STALE_AFTER" envDefault:"5m"`
+
+	// How often holdings are checkpointed for point-in-time (?as_of=)
+	// queries; 0 disables checkpoints, so such queries replay the full ledger.
+	HoldingsCheckpointInterval time.Duration `env:"HOLDINGS_CHECKPOINT_INTERVAL" envDefault:"1h"`
+
+	// End-of-day snapshots: every active entity of the registry closes its
+	// books at SnapshotClose (HH:MM in its registered time zone; empty for
+	// none) unless SnapshotCutoffs overrides it, as a comma-separated list
+	// of entity@HH:MM or entity=Zone@HH:MM.
+	SnapshotClose   string `env:"SNAPSHOT_CLOSE" envDefault:"17:00"`
+	SnapshotCutoffs string `env:"SNAPSHOT_CUTOFFS" envDefault:"zurich@17:30,new_york@16:00"`
+
+	// How many missed closes per entity are recovered from the ledger at
+	// startup, most recent first; older ones are skipped with a warning.
+	SnapshotCatchUp int `env:"SNAPSHOT_CATCH_UP" envDefault:"20"`
+
+	// How often the entity registry is reloaded from the database, picking
+	// up entities added or deactivated through another replica.
+	EntitiesRefresh time.Duration `env:"ENTITIES_REFRESH" envDefault:"30s"`
+
+	// How often the instrument master is reloaded from the database.
+	InstrumentsRefresh time.Duration `env:"INSTRUMENTS_REFRESH" envDefault:"30s"`
+
+	// What the consumer does with trades for instruments the master does not
+	// list as tradable: "quarantine" (held in the DLQ and re-driven once the
+	// instrument is registered) or "reject" (dead-lettered as invalid).
+	// Uploads over HTTP and CSV imports always reject them.
+	KafkaOnUnknownInstrument string `env:"KAFKA_ON_UNKNOWN_INSTRUMENT" envDefault:"quarantine"`
+
+	// Live stream: events kept for Last-Event-ID resumption, and how many
+	// undelivered events a client may have before it is dropped as too slow.
+	StreamHistory      int `env:"STREAM_HISTORY" envDefault:"10000"`
+	StreamClientBuffer int `env:"STREAM_CLIENT_BUFFER" envDefault:"256"`
 }
 
 func Load() (Config, error) {
@@ -0,0 +1,382 @@
+// Package csvimport loads trades from broker CSV exports. Columns are
+// mapped onto trade fields by header name, every row is validated like a
+// Kafka trade, and valid rows are applied through ingest.Apply, or only
+// previewed in a dry run.
+package csvimport
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/ingest"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/validation"
+)
+
+// Trade fields a column can be mapped to. Side is optional: when mapped,
+// quantities are taken as absolute and signed by it (buy/b, sell/s).
+const (
+	FieldTradeID        = "trade_id"
+	FieldEntity         = "entity"
+	FieldInstrumentType = "instrument_type"
+	FieldSymbol         = "symbol"
+	FieldQuantity       = "quantity"
+	FieldPrice          = "price"
+	FieldTS             = "ts"
+	FieldSide           = "side"
+)
+
+var fields = []string{FieldTradeID, FieldEntity, FieldInstrumentType, FieldSymbol, FieldQuantity, FieldPrice, FieldTS, FieldSide}
+
+// required fields must have a column; trade_id is derived when missing.
+var required = []string{FieldEntity, FieldInstrumentType, FieldSymbol, FieldQuantity, FieldTS}
+
+// Mapping maps trade fields to CSV header names (matched case-insensitively).
+// Fields that are not mapped use a column named like the field, if any.
+type Mapping map[string]string
+
+// ParseMapping parses "field=Header,field=Header", e.g.
+// "trade_id=Ref,quantity=Qty,ts=Trade Date".
+func ParseMapping(s string) (Mapping, error) {
+	m := Mapping{}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		field, column, ok := strings.Cut(part, "=")
+		field, column = strings.ToLower(strings.TrimSpace(field)), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("mapping %q: want field=Header", part)
+		}
+		if !slices.Contains(fields, field) {
+			return nil, fmt.Errorf("mapping %q: unknown field %q (use %s)", part, field, strings.Join(fields, ", "))
+		}
+		m[field] = column
+	}
+	return m, nil
+}
+
+// Options control how a file is read.
+type Options struct {
+	Mapping Mapping
+	// Comma is the field delimiter; ',' when zero.
+	Comma rune
+	// TimeFormat is the Go layout of the ts column. When empty RFC 3339,
+	// "2006-01-02 15:04:05" and "2006-01-02" are accepted, in UTC unless
+	// the value has an offset.
+	TimeFormat string
+	// Key namespaces the trade_ids derived for rows without one. They are
+	// derived from the row's content (entity, instrument, quantity, price,
+	// ts) and how many identical rows came before it, so importing the same
+	// trades again, even from a re-export that adds or reorders rows, is a
+	// no-op. Identical trades of files imported under different keys are
+	// kept apart.
+	Key string
+}
+
+// Row is one data row of the file. Line is its 1-based line in the file;
+// rows that failed parsing or validation carry Errors or Error.
+type Row struct {
+	Line   int
+	Trade  models.Trade
+	Errors []validation.FieldError
+	Error  string
+}
+
+func (r Row) valid() bool { return len(r.Errors) == 0 && r.Error == "" }
+
+var timeFormats = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// Parse reads and validates every row of data. It only fails when the file
+// as a whole is unusable (not CSV, missing required columns); problems with
+// single rows are reported on the rows.
+func Parse(data []byte, opts Options) ([]Row, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	if opts.Comma != 0 {
+		r.Comma = opts.Comma
+	}
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv: file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("csv: header: %w", err)
+	}
+	cols, err := resolve(header, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]int)
+	deriveID := func(t models.Trade) string {
+		content := contentKey(opts.Key, t)
+		seen[content]++
+		return ingest.DeriveTradeID(content, seen[content])
+	}
+
+	var rows []Row
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if !errors.As(err, &pe) {
+				return nil, err
+			}
+			rows = append(rows, Row{Line: pe.Line, Error: pe.Err.Error()})
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" {
+			continue // blank line
+		}
+		rows = append(rows, parseRow(line, rec, cols, deriveID, opts.TimeFormat))
+	}
+}
+
+// resolve returns the column index of every mapped field (-1 when absent).
+func resolve(header []string, m Mapping) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if _, dup := index[h]; !dup {
+			index[h] = i
+		}
+	}
+	cols := make(map[string]int, len(fields))
+	for _, f := range fields {
+		name, mapped := m[f]
+		if !mapped {
+			name = f
+		}
+		i, ok := index[strings.ToLower(name)]
+		if !ok {
+			if mapped {
+				return nil, fmt.Errorf("csv: column %q (mapped to %s) not found in header", name, f)
+			}
+			i = -1
+		}
+		cols[f] = i
+	}
+	var missing []string
+	for _, f := range required {
+		if cols[f] < 0 {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("csv: no column for %s (map them with field=Header)", strings.Join(missing, ", "))
+	}
+	return cols, nil
+}
+
+// contentKey identifies what a row without trade_id books.
+func contentKey(key string, t models.Trade) string {
+	price := ""
+	if t.Price != nil {
+		price = t.Price.String()
+	}
+	return strings.Join([]string{
+		"csv", key, t.Entity, t.InstrumentType, t.Symbol, t.Quantity.String(), price, t.TS.UTC().Format(time.RFC3339Nano),
+	}, "\x1f")
+}
+
+func parseRow(line int, rec []string, cols map[string]int, deriveID func(models.Trade) string, timeFormat string) Row {
+	row := Row{Line: line}
+	get := func(f string) string {
+		if i := cols[f]; i >= 0 && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	fail := func(field, code, msg string) {
+		row.Errors = append(row.Errors, validation.FieldError{Field: field, Code: code, Message: msg})
+	}
+
+	t := models.Trade{
+		TradeID:        get(FieldTradeID),
+		Entity:         get(FieldEntity),
+		InstrumentType: get(FieldInstrumentType),
+		Symbol:         get(FieldSymbol),
+	}
+
+	if raw := get(FieldQuantity); raw != "" {
+		q, err := decimal.Parse(raw)
+		if err != nil {
+			fail(FieldQuantity, validation.CodeInvalid, fmt.Sprintf("quantity %q is not a number", raw))
+		}
+		t.Quantity = q
+	}
+	if cols[FieldSide] >= 0 {
+		switch side := strings.ToLower(get(FieldSide)); side {
+		case "buy", "b":
+			t.Quantity = t.Quantity.Abs()
+		case "sell", "s":
+			t.Quantity = t.Quantity.Abs().Neg()
+		case "":
+			fail(FieldSide, validation.CodeRequired, "side is required")
+		default:
+			fail(FieldSide, validation.CodeInvalid, fmt.Sprintf("unknown side %q (use buy or sell)", side))
+		}
+	}
+	if raw := get(FieldPrice); raw != "" {
+		p, err := decimal.Parse(raw)
+		if err != nil {
+			fail(FieldPrice, validation.CodeInvalid, fmt.Sprintf("price %q is not a number", raw))
+		}
+		t.Price = &p
+	}
+	// Historical trades must say when they happened; a missing ts would
+	// otherwise be stamped with the import time.
+	if raw := get(FieldTS); raw == "" {
+		fail(FieldTS, validation.CodeRequired, "ts is required")
+	} else if ts, err := parseTime(raw, timeFormat); err != nil {
+		fail(FieldTS, validation.CodeInvalid, fmt.Sprintf("ts %q is not a valid time", raw))
+	} else {
+		t.TS = ts
+	}
+
+	t = validation.Normalize(t)
+	if t.TradeID == "" {
+		t.TradeID = deriveID(t)
+	}
+
+	// Report every problem of the row, but only the first one per field.
+	if ve, ok := validation.AsError(validation.Trade(t)); ok {
+		for _, fe := range ve.Fields {
+			if !slices.ContainsFunc(row.Errors, func(e validation.FieldError) bool { return e.Field == fe.Field }) {
+				row.Errors = append(row.Errors, fe)
+			}
+		}
+	}
+	row.Trade = t
+	return row
+}
+
+func parseTime(s, layout string) (time.Time, error) {
+	if layout != "" {
+		return time.ParseInLocation(layout, s, time.UTC)
+	}
+	var err error
+	for _, l := range timeFormats {
+		var t time.Time
+		if t, err = time.ParseInLocation(l, s, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Report is the outcome of an import. Rows lists every row that was not
+// accepted, with why; in a dry run Accepted counts the rows that would be,
+// and Holdings shows how the holdings would change.
+type Report struct {
+	DryRun     bool                     `json:"dry_run"`
+	Total      int                      `json:"total"`
+	Accepted   int                      `json:"accepted"`
+	Duplicates int                      `json:"duplicates"`
+	Rejected   int                      `json:"rejected"`
+	Rows       []RowResult              `json:"rows"`
+	Holdings   []holdings.HoldingChange `json:"holdings,omitempty"`
+}
+
+// RowResult is the outcome of one row.
+type RowResult struct {
+	Line    int                     `json:"line"`
+	TradeID string                  `json:"trade_id,omitempty"`
+	Status  string                  `json:"status"`
+	Errors  []validation.FieldError `json:"errors,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+// Import applies the valid rows with source as their origin, or with dryRun
+// only previews them. Rows with errors are never applied.
+func Import(ctx context.Context, svc *holdings.Service, rows []Row, source string, dryRun bool) (Report, error) {
+	rep := Report{DryRun: dryRun, Total: len(rows), Rows: make([]RowResult, 0)}
+	var trades []models.Trade
+	var lines []int // index into rows of trades[j]
+	received := time.Now().UTC()
+	for i, r := range rows {
+		if !r.valid() {
+			continue
+		}
+		t := r.Trade
+		t.Origin = &models.Origin{Source: source, ReceivedAt: received}
+		trades = append(trades, t)
+		lines = append(lines, i)
+	}
+
+	outcomes := make([]ingest.Outcome, len(trades))
+	if dryRun {
+		p, err := svc.Preview(ctx, trades)
+		if err != nil {
+			return Report{}, err
+		}
+		for j := range trades {
+			outcomes[j].Status = ingest.Accepted
+			if p.Duplicate[j] {
+				outcomes[j].Status = ingest.Duplicate
+			}
+		}
+		rep.Holdings = p.Holdings
+	} else {
+		var err error
+		if outcomes, err = ingest.Apply(ctx, svc, trades); err != nil {
+			return Report{}, err
+		}
+	}
+
+	results := make([]RowResult, len(rows))
+	for i, r := range rows {
+		results[i] = RowResult{Line: r.Line, TradeID: r.Trade.TradeID, Status: ingest.Rejected, Errors: r.Errors, Error: r.Error}
+	}
+	for j, o := range outcomes {
+		results[lines[j]].Status, results[lines[j]].Error = o.Status, o.Error
+	}
+	for _, res := range results {
+		switch res.Status {
+		case ingest.Accepted:
+			rep.Accepted++
+			continue
+		case ingest.Duplicate:
+			rep.Duplicates++
+		default:
+			rep.Rejected++
+		}
+		rep.Rows = append(rep.Rows, res)
+	}
+	return rep, nil
+}
+
+// WriteCSV writes the per-row report: one line per problem, so a row with
+// three invalid fields takes three lines.
+func (rep Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"line", "trade_id", "status", "field", "code", "message"})
+	for _, r := range rep.Rows {
+		line := fmt.Sprint(r.Line)
+		if len(r.Errors) == 0 {
+			_ = cw.Write([]string{line, r.TradeID, r.Status, "", "", r.Error})
+			continue
+		}
+		for _, e := range r.Errors {
+			_ = cw.Write([]string{line, r.TradeID, r.Status, e.Field, e.Code, e.Message})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,106 @@
+package csvimport
+
+import (
+	"testing"
+
+	"github.com/example/trades-aggregator/internal/validation"
+)
+
+const header = "entity,instrument_type,symbol,quantity,price,ts\n"
+
+func parse(t *testing.T, data string, opts Options) []Row {
+	t.Helper()
+	rows, err := Parse([]byte(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if !r.valid() {
+			t.Fatalf("line %d rejected: %v %s", r.Line, r.Errors, r.Error)
+		}
+	}
+	return rows
+}
+
+func ids(rows []Row) []string {
+	out := make([]string, len(rows))
+	for i, r := range rows {
+		out[i] = r.Trade.TradeID
+	}
+	return out
+}
+
+func TestDerivedTradeIDs(t *testing.T) {
+	first := parse(t, header+
+		"zurich,stock,AAPL,10,189.25,2024-03-01 09:30:00\n"+
+		"zurich,stock,AAPL,10,189.25,2024-03-01 09:30:00\n"+
+		"new_york,crypto,BTC,-0.5,,2024-03-01\n", Options{})
+	got := ids(first)
+	if got[0] == got[1] {
+		t.Error("identical rows share a trade_id")
+	}
+
+	// A later export of the same account: rows reordered, formatted
+	// differently, and a new trade added in front.
+	again := parse(t, header+
+		"zurich,stock,MSFT,5,400,2024-03-02\n"+
+		"New_York ,crypto,BTC,-0.50,,2024-03-01T00:00:00Z\n"+
+		"zurich,stock,AAPL,10.0,189.250,2024-03-01T09:30:00Z\n"+
+		"zurich,stock,AAPL,10,189.25,2024-03-01 09:30:00\n", Options{})
+	want := map[string]bool{got[0]: true, got[1]: true, got[2]: true}
+	for _, id := range ids(again)[1:] {
+		if !want[id] {
+			t.Errorf("re-exported trade got a new trade_id %s", id)
+		}
+		delete(want, id)
+	}
+	if want[ids(again)[0]] {
+		t.Error("new trade reuses an existing trade_id")
+	}
+
+	// Differences that change what is booked change the ID.
+	for _, row := range []string{
+		"zurich,stock,AAPL,11,189.25,2024-03-01 09:30:00\n",
+		"zurich,stock,AAPL,10,189.26,2024-03-01 09:30:00\n",
+		"zurich,stock,AAPL,10,,2024-03-01 09:30:00\n",
+		"zurich,stock,AAPL,10,189.25,2024-03-01 09:30:01\n",
+		"new_york,stock,AAPL,10,189.25,2024-03-01 09:30:00\n",
+	} {
+		if id := ids(parse(t, header+row, Options{}))[0]; id == got[0] {
+			t.Errorf("%q has the trade_id of the original row", row)
+		}
+	}
+
+	if keyed := ids(parse(t, header+"zurich,stock,AAPL,10,189.25,2024-03-01 09:30:00\n", Options{Key: "account-2"})); keyed[0] == got[0] {
+		t.Error("key does not namespace derived trade_ids")
+	}
+}
+
+func TestExplicitTradeID(t *testing.T) {
+	rows := parse(t, "Ref,entity,instrument_type,symbol,quantity,ts\n"+
+		"0B8E6A52-6F1E-4C1A-9A57-2F4F6F7F4A10,zurich,stock,AAPL,1,2024-03-01\n",
+		Options{Mapping: Mapping{FieldTradeID: "Ref"}})
+	if got := rows[0].Trade.TradeID; got != "0b8e6a52-6f1e-4c1a-9a57-2f4f6f7f4a10" {
+		t.Errorf("trade_id = %s", got)
+	}
+}
+
+func TestRowErrors(t *testing.T) {
+	rows, err := Parse([]byte(header+
+		"zurich,stock,AAPL,ten,1,2024-03-01\n"+
+		"zurich,stock,AAPL,1,1,\n"+
+		"zurich,stock,AAPL,1,1,yesterday\n"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ field, code string }{
+		{FieldQuantity, validation.CodeInvalid},
+		{FieldTS, validation.CodeRequired},
+		{FieldTS, validation.CodeInvalid},
+	}
+	for i, w := range want {
+		if len(rows[i].Errors) == 0 || rows[i].Errors[0].Field != w.field || rows[i].Errors[0].Code != w.code {
+			t.Errorf("line %d: errors %v, want %s/%s first", rows[i].Line, rows[i].Errors, w.field, w.code)
+		}
+	}
+}
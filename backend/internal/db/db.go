@@ -2,8 +2,13 @@ package db
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -18,3 +23,37 @@ func Connect(ctx context.Context, url string) (*pgxpool.Pool, error) {
 	cfg.HealthCheckPeriod = time.Minute
 	return pgxpool.NewWithConfig(ctx, cfg)
 }
+
+// IsTransient reports whether err is worth retrying: lost or refused
+// connections, timeouts, serialization failures, deadlocks and resource
+// exhaustion. Data and constraint errors are permanent; retrying them would
+// fail the same way.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"), // connection exception
+			strings.HasPrefix(pgErr.Code, "40"),  // serialization failure, deadlock
+			strings.HasPrefix(pgErr.Code, "53"),  // insufficient resources
+			strings.HasPrefix(pgErr.Code, "57P"), // admin/crash shutdown
+			strings.HasPrefix(pgErr.Code, "58"):  // system error
+			return true
+		default:
+			return false
+		}
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// Anything else that never reached Postgres (pool closed, dial failures)
+	// is treated as infrastructure trouble rather than a bad trade.
+	var connErr *pgconn.ConnectError
+	return errors.As(err, &connErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,243 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/validation"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reasons a message ends up in the dead-letter queue.
+const (
+	ReasonDecode           = "decode_error"
+	ReasonInvalid          = "validation_failed"
+	ReasonRejected         = "apply_rejected"
+	ReasonRetriesExhausted = "retries_exhausted"
+	// ReasonQuarantined holds a trade for an instrument the master does not
+	// list (or not as tradable) until it does; see RedriveQuarantined.
+	ReasonQuarantined = "instrument_quarantined"
+)
+
+// Header is a Kafka message header. Values are bytes, not necessarily text,
+// and like keys and payloads are base64 in JSON.
+type Header struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Entry is a dead-lettered message together with where it came from and why
+// it could not be applied. Key and Payload are kept as received; JSON
+// carries them in base64.
+type Entry struct {
+	ID         int64      `json:"id"`
+	Topic      string     `json:"topic"`
+	Partition  int        `json:"partition"`
+	Offset     int64      `json:"offset"`
+	Key        []byte     `json:"key,omitempty"`
+	Payload    []byte     `json:"payload"`
+	Headers    []Header   `json:"headers"`
+	Reason     string     `json:"reason"`
+	Error      string     `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RedrivenAt *time.Time `json:"redriven_at,omitempty"`
+}
+
+// Redrive outcomes.
+const (
+	RedriveApplied   = "applied"
+	RedriveDuplicate = "duplicate"
+	RedriveFailed    = "failed"
+	RedriveNotFound  = "not_found"
+	RedriveDone      = "already_redriven"
+)
+
+type RedriveResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Service struct {
+	DB        *pgxpool.Pool
+	Holdings  *holdings.Service
+	Observers []Observer
+}
+
+// Observer is told about every newly recorded entry, e.g. to alert
+// operators. It must not block.
+type Observer interface {
+	DeadLettered(e Entry)
+}
+
+func New(db *pgxpool.Pool, h *holdings.Service) *Service { return &Service{DB: db, Holdings: h} }
+
+// Record stores e. Recording the same source offset twice is a no-op, so a
+// redelivered poison message does not pile up duplicate entries.
+func (s *Service) Record(ctx context.Context, e Entry) error {
+	// Text columns take neither NULs nor invalid UTF-8, which an error
+	// quoting a garbled payload may well contain.
+	e.Error = text(e.Error)
+	e.Headers = append(make([]Header, 0, len(e.Headers)), e.Headers...)
+	for i := range e.Headers {
+		e.Headers[i].Key = text(e.Headers[i].Key)
+	}
+	headers, err := json.Marshal(e.Headers)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	if len(e.Key) > 0 {
+		key = e.Key
+	}
+	err = s.DB.QueryRow(ctx, `
+		INSERT INTO dead_letters (source_topic, source_partition, source_offset, msg_key, payload, headers, reason, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (source_topic, source_partition, source_offset) DO NOTHING
+		RETURNING id, created_at
+	`, e.Topic, e.Partition, e.Offset, key, e.Payload, headers, e.Reason, e.Error).Scan(&e.ID, &e.CreatedAt)
+	if holdings.IsNotFound(err) {
+		return nil // already recorded
+	}
+	if err != nil {
+		return err
+	}
+	for _, o := range s.Observers {
+		o.DeadLettered(e)
+	}
+	return nil
+}
+
+func text(s string) string {
+	return strings.ToValidUTF8(strings.ReplaceAll(s, "\x00", ""), "\uFFFD")
+}
+
+// List returns the newest entries first. Unless includeRedriven is set only
+// entries still awaiting a re-drive are returned.
+func (s *Service) List(ctx context.Context, limit int, includeRedriven bool) ([]Entry, error) {
+	rows, err := s.DB.Query(ctx, `
+		SELECT id, source_topic, source_partition, source_offset, COALESCE(msg_key, ''::bytea), payload, headers,
+		       reason, error, created_at, redriven_at
+		FROM dead_letters
+		WHERE $1 OR redriven_at IS NULL
+		ORDER BY id DESC
+		LIMIT $2
+	`, includeRedriven, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]Entry, 0)
+	for rows.Next() {
+		var e Entry
+		var headers []byte
+		if err := rows.Scan(&e.ID, &e.Topic, &e.Partition, &e.Offset, &e.Key, &e.Payload, &headers,
+			&e.Reason, &e.Error, &e.CreatedAt, &e.RedrivenAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(headers, &e.Headers); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// Redrive feeds the selected entries back through ApplyTrade, in the order
+// given. Entries that apply (or turn out to be duplicates) are marked as
+// re-driven; failures keep their entry pending with the new error recorded.
+func (s *Service) Redrive(ctx context.Context, ids []int64) ([]RedriveResult, error) {
+	out := make([]RedriveResult, 0, len(ids))
+	for _, id := range ids {
+		res, err := s.redriveOne(ctx, id)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+// RedriveQuarantined re-drives the pending quarantined entries whose trade
+// is for an instrument match accepts, oldest first; call it once the
+// instrument master lists them. Entries that still fail stay quarantined.
+func (s *Service) RedriveQuarantined(ctx context.Context, match func(instrumentType, symbol string) bool) ([]RedriveResult, error) {
+	rows, err := s.DB.Query(ctx, `
+		SELECT id, payload FROM dead_letters
+		WHERE reason = $1 AND redriven_at IS NULL
+		ORDER BY id
+	`, ReasonQuarantined)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var payload []byte
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var t models.Trade
+		if json.Unmarshal(payload, &t) != nil {
+			continue
+		}
+		t = validation.Normalize(t)
+		if match(t.InstrumentType, t.Symbol) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return s.Redrive(ctx, ids)
+}
+
+func (s *Service) redriveOne(ctx context.Context, id int64) (RedriveResult, error) {
+	var payload []byte
+	var redrivenAt *time.Time
+	origin := models.Origin{Source: models.SourceRedrive}
+	var partition int
+	var offset int64
+	err := s.DB.QueryRow(ctx, `
+		SELECT payload, redriven_at, source_topic, source_partition, source_offset FROM dead_letters WHERE id = $1
+	`, id).Scan(&payload, &redrivenAt, &origin.Topic, &partition, &offset)
+	if holdings.IsNotFound(err) {
+		return RedriveResult{ID: id, Status: RedriveNotFound}, nil
+	}
+	if err != nil {
+		return RedriveResult{}, err
+	}
+	if redrivenAt != nil {
+		return RedriveResult{ID: id, Status: RedriveDone}, nil
+	}
+
+	origin.Partition, origin.Offset = &partition, &offset
+	origin.ReceivedAt = time.Now().UTC()
+	applied, applyErr := s.apply(ctx, payload, &origin)
+	if applyErr != nil {
+		_, err := s.DB.Exec(ctx, `UPDATE dead_letters SET error = $2 WHERE id = $1`, id, applyErr.Error())
+		return RedriveResult{ID: id, Status: RedriveFailed, Error: applyErr.Error()}, err
+	}
+	if _, err := s.DB.Exec(ctx, `UPDATE dead_letters SET redriven_at = now() WHERE id = $1`, id); err != nil {
+		return RedriveResult{}, err
+	}
+	if !applied {
+		return RedriveResult{ID: id, Status: RedriveDuplicate}, nil
+	}
+	return RedriveResult{ID: id, Status: RedriveApplied}, nil
+}
+
+func (s *Service) apply(ctx context.Context, payload []byte, origin *models.Origin) (bool, error) {
+	t, err := holdings.DecodeTrade(payload)
+	if err != nil {
+		return false, err
+	}
+	t.Origin = origin
+	return s.Holdings.ApplyTrade(ctx, t)
+}
@@ -0,0 +1,349 @@
+// Package decimal provides an exact base-10 number type for quantities, prices
+// and amounts. It maps onto Postgres NUMERIC through pgx and onto JSON numbers
+// without ever passing through float64.
+package decimal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Decimal is coef × 10^exp. The zero value is 0 and ready to use.
+// Values are immutable; every operation returns a new Decimal.
+type Decimal struct {
+	coef *big.Int // nil means zero
+	exp  int32
+}
+
+var (
+	bigTen = big.NewInt(10)
+
+	// Zero is the additive identity.
+	Zero = Decimal{}
+)
+
+// New returns coef × 10^exp.
+func New(coef int64, exp int32) Decimal {
+	return Decimal{coef: big.NewInt(coef), exp: exp}
+}
+
+// NewFromInt returns the integer v.
+func NewFromInt(v int64) Decimal { return New(v, 0) }
+
+// Limits of what Parse accepts: far beyond any quantity or price, but small
+// enough that formatting and arithmetic stay cheap whatever a client sends
+// (1e50000000 would take minutes to print).
+const (
+	maxDigits   = 128
+	maxExponent = 64
+)
+
+// Parse reads a plain or exponent-notation decimal such as "-12.5", "0.0013"
+// or "1e-8". NaN and infinities are rejected, as are numbers of more than
+// 128 digits or with an exponent beyond ±64.
+func Parse(s string) (Decimal, error) {
+	raw := s
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Decimal{}, fmt.Errorf("decimal: empty string")
+	}
+
+	var exp int64
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("decimal: invalid exponent in %q", raw)
+		}
+		exp = e
+		s = s[:i]
+	}
+
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, fmt.Errorf("decimal: invalid number %q", raw)
+	}
+	digits := intPart + fracPart
+	if len(digits) > maxDigits {
+		return Decimal{}, fmt.Errorf("decimal: too many digits in %q", raw)
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return Decimal{}, fmt.Errorf("decimal: invalid number %q", raw)
+		}
+	}
+
+	coef, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("decimal: invalid number %q", raw)
+	}
+	if neg {
+		coef.Neg(coef)
+	}
+	exp -= int64(len(fracPart))
+	if exp < -maxExponent || exp > maxExponent {
+		return Decimal{}, fmt.Errorf("decimal: exponent out of range in %q", raw)
+	}
+	return Decimal{coef: coef, exp: int32(exp)}, nil
+}
+
+// MustParse is Parse for constants; it panics on malformed input.
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d Decimal) bigCoef() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// rescale returns d's coefficient expressed at exponent exp (exp <= d.exp).
+func (d Decimal) rescale(exp int32) *big.Int {
+	c := new(big.Int).Set(d.bigCoef())
+	if exp >= d.exp {
+		return c
+	}
+	return c.Mul(c, pow10(int64(d.exp-exp)))
+}
+
+func pow10(n int64) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(n), nil)
+}
+
+// Add returns d + o.
+func (d Decimal) Add(o Decimal) Decimal {
+	exp := min(d.exp, o.exp)
+	return Decimal{coef: new(big.Int).Add(d.rescale(exp), o.rescale(exp)), exp: exp}
+}
+
+// Sub returns d - o.
+func (d Decimal) Sub(o Decimal) Decimal { return d.Add(o.Neg()) }
+
+// Mul returns d × o exactly.
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.bigCoef(), o.bigCoef()), exp: d.exp + o.exp}
+}
+
+// Div returns d ÷ o rounded half away from zero to places decimal places.
+// It panics if o is zero, like integer division.
+func (d Decimal) Div(o Decimal, places int32) Decimal {
+	if o.IsZero() {
+		panic("decimal: division by zero")
+	}
+	num := new(big.Int).Set(d.bigCoef())
+	den := new(big.Int).Set(o.bigCoef())
+	if k := int64(d.exp) - int64(o.exp) + int64(places); k >= 0 {
+		num.Mul(num, pow10(k))
+	} else {
+		den.Mul(den, pow10(-k))
+	}
+	return Decimal{coef: quoRound(num, den), exp: -places}
+}
+
+// quoRound returns num/den rounded half away from zero.
+func quoRound(num, den *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+	if twice.Cmp(new(big.Int).Abs(den)) >= 0 {
+		if num.Sign()*den.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// Round returns d rounded half away from zero to places decimal places.
+func (d Decimal) Round(places int32) Decimal {
+	if d.exp >= -places {
+		return d
+	}
+	den := pow10(int64(-places - d.exp))
+	return Decimal{coef: quoRound(d.bigCoef(), den), exp: -places}
+}
+
+// Unscaled returns d rounded to places decimal places as an integer count of
+// 10^-places, e.g. 1.5 with 2 places is 150.
+func (d Decimal) Unscaled(places int32) *big.Int {
+	return d.Round(places).rescale(-places)
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.bigCoef()), exp: d.exp}
+}
+
+// Abs returns |d|.
+func (d Decimal) Abs() Decimal {
+	return Decimal{coef: new(big.Int).Abs(d.bigCoef()), exp: d.exp}
+}
+
+// Sign returns -1, 0 or +1.
+func (d Decimal) Sign() int { return d.bigCoef().Sign() }
+
+// IsZero reports whether d == 0.
+func (d Decimal) IsZero() bool { return d.Sign() == 0 }
+
+// Cmp compares d and o and returns -1, 0 or +1.
+func (d Decimal) Cmp(o Decimal) int {
+	exp := min(d.exp, o.exp)
+	return d.rescale(exp).Cmp(o.rescale(exp))
+}
+
+// Equal reports whether d and o are numerically equal (1.50 equals 1.5).
+func (d Decimal) Equal(o Decimal) bool { return d.Cmp(o) == 0 }
+
+// Scale returns the number of significant digits after the decimal point.
+func (d Decimal) Scale() int32 {
+	n := d.normalize()
+	if n.exp >= 0 {
+		return 0
+	}
+	return -n.exp
+}
+
+// IntDigits returns the number of digits before the decimal point.
+func (d Decimal) IntDigits() int {
+	n := d.normalize()
+	s := new(big.Int).Abs(n.bigCoef()).String()
+	if n.exp >= 0 {
+		if s == "0" {
+			return 0
+		}
+		return len(s) + int(n.exp)
+	}
+	return max(len(s)-int(-n.exp), 0)
+}
+
+// normalize strips trailing zeros from the coefficient.
+func (d Decimal) normalize() Decimal {
+	c := d.bigCoef()
+	if c.Sign() == 0 {
+		return Decimal{}
+	}
+	c = new(big.Int).Set(c)
+	exp := d.exp
+	r := new(big.Int)
+	for {
+		q, m := new(big.Int).QuoRem(c, bigTen, r)
+		if m.Sign() != 0 {
+			break
+		}
+		c = q
+		exp++
+	}
+	return Decimal{coef: c, exp: exp}
+}
+
+// String formats d in plain notation without trailing fractional zeros.
+func (d Decimal) String() string {
+	n := d.normalize()
+	if n.exp >= 0 {
+		return new(big.Int).Mul(n.bigCoef(), pow10(int64(n.exp))).String()
+	}
+	return formatFixed(n.bigCoef(), -n.exp)
+}
+
+// StringFixed formats d with exactly places fractional digits.
+func (d Decimal) StringFixed(places int32) string {
+	r := d.Round(places)
+	return formatFixed(r.rescale(-places), places)
+}
+
+func formatFixed(coef *big.Int, places int32) string {
+	digits := new(big.Int).Abs(coef).String()
+	if places > 0 {
+		if pad := int(places) + 1 - len(digits); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+		cut := len(digits) - int(places)
+		digits = digits[:cut] + "." + digits[cut:]
+	}
+	if coef.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// Float64 returns the nearest float64. Use it for display only.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// MarshalJSON encodes d as an exact JSON number.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding a number.
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+		b = b[1 : len(b)-1]
+	}
+	v, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Decimal) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Decimal) UnmarshalText(b []byte) error {
+	v, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// ScanNumeric implements pgtype.NumericScanner.
+func (d *Decimal) ScanNumeric(v pgtype.Numeric) error {
+	if !v.Valid {
+		return errors.New("decimal: cannot scan NULL into Decimal")
+	}
+	if v.NaN || v.InfinityModifier != pgtype.Finite {
+		return errors.New("decimal: cannot scan NaN or infinity")
+	}
+	*d = Decimal{coef: new(big.Int).Set(v.Int), exp: v.Exp}
+	return nil
+}
+
+// NumericValue implements pgtype.NumericValuer.
+func (d Decimal) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{Int: new(big.Int).Set(d.bigCoef()), Exp: d.exp, Valid: true}, nil
+}
@@ -0,0 +1,209 @@
+package decimal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"0", "0"},
+		{"-0", "0"},
+		{"12.5", "12.5"},
+		{"+12.50", "12.5"},
+		{"-12.5", "-12.5"},
+		{"0.0013", "0.0013"},
+		{".5", "0.5"},
+		{"5.", "5"},
+		{" 42 ", "42"},
+		{"1e-8", "0.00000001"},
+		{"1.5E3", "1500"},
+		{"-2.5e+2", "-250"},
+		{"123456789012.12345678", "123456789012.12345678"},
+		{"1e64", "1" + strings.Repeat("0", 64)},
+		{"1e-64", "0." + strings.Repeat("0", 63) + "1"},
+	}
+	for _, tt := range tests {
+		d, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got := d.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, in := range []string{
+		"", " ", "-", ".", "abc", "1.2.3", "1,5", "NaN", "Inf", "-Infinity", "0x10", "1e", "1e+", "1ee2", "--1",
+		"1e65", "1e-65", "1e50000000", "1e-2147483648", "1e99999999999",
+		strings.Repeat("9", maxDigits+1),
+		"0." + strings.Repeat("0", maxExponent) + "1",
+	} {
+		if d, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %s, want an error", in, d)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		a, b          string
+		add, sub, mul string
+		div           string // a ÷ b to 8 places
+		cmp           int
+	}{
+		{"0.1", "0.2", "0.3", "-0.1", "0.02", "0.5", -1},
+		{"1.5", "1.50", "3", "0", "2.25", "1", 0},
+		{"-3", "2", "-1", "-5", "-6", "-1.5", -1},
+		{"10", "3", "13", "7", "30", "3.33333333", 1},
+		{"2", "3", "5", "-1", "6", "0.66666667", -1},
+		{"-2", "3", "1", "-5", "-6", "-0.66666667", -1},
+		{"1e3", "0.001", "1000.001", "999.999", "1", "1000000", 1},
+		{"0", "7", "7", "-7", "0", "0", -1},
+		{"99999999999.99999999", "0.00000001", "100000000000", "99999999999.99999998", "999.9999999999999999", "9999999999999999999", 1},
+	}
+	for _, tt := range tests {
+		a, b := MustParse(tt.a), MustParse(tt.b)
+		for _, c := range []struct {
+			op        string
+			got, want string
+		}{
+			{"+", a.Add(b).String(), tt.add},
+			{"-", a.Sub(b).String(), tt.sub},
+			{"×", a.Mul(b).String(), tt.mul},
+			{"÷", a.Div(b, 8).String(), tt.div},
+		} {
+			if c.got != c.want {
+				t.Errorf("%s %s %s = %s, want %s", tt.a, c.op, tt.b, c.got, c.want)
+			}
+		}
+		if got := a.Cmp(b); got != tt.cmp {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.cmp)
+		}
+	}
+}
+
+func TestDivByZeroPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Div by zero did not panic")
+		}
+	}()
+	MustParse("1").Div(Zero, 8)
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int32
+		want   string
+		fixed  string
+	}{
+		{"1.005", 2, "1.01", "1.01"},
+		{"1.004", 2, "1", "1.00"},
+		{"-1.005", 2, "-1.01", "-1.01"},
+		{"-1.004", 2, "-1", "-1.00"},
+		{"2.5", 0, "3", "3"},
+		{"-2.5", 0, "-3", "-3"},
+		{"0.000000005", 8, "0.00000001", "0.00000001"},
+		{"0.000000004", 8, "0", "0.00000000"},
+		{"123.456", 8, "123.456", "123.45600000"},
+		{"1250", -2, "1300", "1300"},
+	}
+	for _, tt := range tests {
+		d := MustParse(tt.in)
+		if got := d.Round(tt.places).String(); got != tt.want {
+			t.Errorf("Round(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+		if tt.places >= 0 {
+			if got := d.StringFixed(tt.places); got != tt.fixed {
+				t.Errorf("StringFixed(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.fixed)
+			}
+		}
+	}
+}
+
+func TestScaleAndIntDigits(t *testing.T) {
+	tests := []struct {
+		in        string
+		scale     int32
+		intDigits int
+	}{
+		{"0", 0, 0},
+		{"1", 0, 1},
+		{"1.50", 1, 1},
+		{"0.00000001", 8, 0},
+		{"123456789012.5", 1, 12},
+		{"1e3", 0, 4},
+		{"-42.125", 3, 2},
+	}
+	for _, tt := range tests {
+		d := MustParse(tt.in)
+		if got := d.Scale(); got != tt.scale {
+			t.Errorf("Scale(%s) = %d, want %d", tt.in, got, tt.scale)
+		}
+		if got := d.IntDigits(); got != tt.intDigits {
+			t.Errorf("IntDigits(%s) = %d, want %d", tt.in, got, tt.intDigits)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		A Decimal `json:"a"`
+		B Decimal `json:"b"`
+		C Decimal `json:"c"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": 0.1, "b": "-2.50", "c": null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":0.1,"b":-2.5,"c":0}`; string(out) != want {
+		t.Errorf("round trip = %s, want %s", out, want)
+	}
+	if err := json.Unmarshal([]byte(`{"a": "1e50000000"}`), &v); err == nil {
+		t.Error("huge exponent accepted")
+	}
+}
+
+// TestPgxRoundTrip encodes and decodes through pgx's NUMERIC codec in both
+// wire formats, as a query parameter and a result column would be.
+func TestPgxRoundTrip(t *testing.T) {
+	m := pgtype.NewMap()
+	for _, in := range []string{"0", "1", "-1", "0.00000001", "-123456789012.12345678", "1e20", "3.14159265358979323846"} {
+		d := MustParse(in)
+		for _, format := range []int16{pgtype.TextFormatCode, pgtype.BinaryFormatCode} {
+			buf, err := m.Encode(pgtype.NumericOID, format, d, nil)
+			if err != nil {
+				t.Fatalf("encode %s (format %d): %v", in, format, err)
+			}
+			var got Decimal
+			if err := m.Scan(pgtype.NumericOID, format, buf, &got); err != nil {
+				t.Fatalf("scan %s (format %d): %v", in, format, err)
+			}
+			if !got.Equal(d) {
+				t.Errorf("round trip of %s (format %d) = %s", in, format, got)
+			}
+		}
+	}
+
+	var d Decimal
+	if err := m.Scan(pgtype.NumericOID, pgtype.TextFormatCode, []byte("NaN"), &d); err == nil {
+		t.Error("scanned NaN")
+	}
+	if err := m.Scan(pgtype.NumericOID, pgtype.TextFormatCode, nil, &d); err == nil {
+		t.Error("scanned NULL")
+	}
+}
@@ -0,0 +1,70 @@
+package decimal
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TestSumMatchesPostgres sums thousands of fractional trades in Go and with
+// SUM over NUMERIC(20,8) columns, and expects the same totals to the last
+// digit. It needs a database: set TEST_DATABASE_URL to run it.
+func TestSumMatchesPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TEMP TABLE decimal_sum_test (quantity NUMERIC(20,8) NOT NULL, price NUMERIC(20,8) NOT NULL)
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 5000
+	rng := rand.New(rand.NewSource(1))
+	var sumQty, sumNotional Decimal
+	rows := make([][]any, n)
+	for i := range rows {
+		// Quantities of up to 8 places, either side; prices with 2 to 8.
+		qty := New(rng.Int63n(2_000_000_000_000)-1_000_000_000_000, -8)
+		px := New(rng.Int63n(100_000_000_000)+1, -int32(2+rng.Intn(7)))
+		rows[i] = []any{qty, px}
+		sumQty = sumQty.Add(qty)
+		sumNotional = sumNotional.Add(qty.Mul(px))
+	}
+	if _, err := conn.CopyFrom(ctx, pgx.Identifier{"decimal_sum_test"}, []string{"quantity", "price"}, pgx.CopyFromRows(rows)); err != nil {
+		t.Fatal(err)
+	}
+
+	var dbQty, dbNotional Decimal
+	if err := conn.QueryRow(ctx, `
+		SELECT SUM(quantity), SUM(quantity * price) FROM decimal_sum_test
+	`).Scan(&dbQty, &dbNotional); err != nil {
+		t.Fatal(err)
+	}
+	if !dbQty.Equal(sumQty) {
+		t.Errorf("SUM(quantity) = %s, Go sum = %s", dbQty, sumQty)
+	}
+	if !dbNotional.Equal(sumNotional) {
+		t.Errorf("SUM(quantity * price) = %s, Go sum = %s", dbNotional, sumNotional)
+	}
+
+	// The same total through float64 is what the exact type replaced.
+	var f float64
+	for _, r := range rows {
+		f += r[0].(Decimal).Float64()
+	}
+	t.Logf("%d trades: quantity %s (float64 gives %v)", n, sumQty, f)
+}
@@ -1,37 +1,77 @@
 package domain
 
-import "strings"
+import (
+	"strings"
+	"time"
 
-// Entity is a closed set of locations. Include "all" for aggregate queries.
+	"github.com/example/trades-aggregator/internal/decimal"
+)
+
+// Entity is a trading entity (desk) code, or "all" for aggregate queries.
+// The set of entities is data: see EntityRegistry.
 type Entity string
 
-const (
-	EntityAll     Entity = "all"
-	EntityZurich  Entity = "zurich"
-	EntityNewYork Entity = "new_york"
-)
+const EntityAll Entity = "all"
+
+// EntityRegistry tells which entity codes exist and which of them accept
+// new trades.
+type EntityRegistry interface {
+	Lookup(code string) (active, ok bool)
+}
+
+var registry EntityRegistry
+
+// SetEntityRegistry makes ParseEntity check codes against r. Call it once at
+// startup, before entities are parsed; without a registry any well-formed
+// code is accepted and the database has the final word.
+func SetEntityRegistry(r EntityRegistry) { registry = r }
 
 func (e Entity) String() string { return string(e) }
-func (e Entity) Valid() bool {
-	switch e {
-	case EntityAll, EntityZurich, EntityNewYork:
-		return true
-	default:
+
+// Active reports whether e accepts new trades.
+func (e Entity) Active() bool {
+	if e == EntityAll || !ValidEntityCode(string(e)) {
 		return false
 	}
+	if registry == nil {
+		return true
+	}
+	active, _ := registry.Lookup(string(e))
+	return active
 }
 
+// ParseEntity accepts "all" (or nothing) and the codes of known entities,
+// active or not.
 func ParseEntity(s string) (Entity, bool) {
-	switch strings.ToLower(strings.TrimSpace(s)) {
-	case "", "all":
+	code := strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case code == "" || code == string(EntityAll):
 		return EntityAll, true
-	case "zurich":
-		return EntityZurich, true
-	case "new_york":
-		return EntityNewYork, true
-	default:
+	case !ValidEntityCode(code):
 		return "", false
+	case registry != nil:
+		_, ok := registry.Lookup(code)
+		return Entity(code), ok
+	default:
+		return Entity(code), true
+	}
+}
+
+// ValidEntityCode reports whether s is usable as an entity code: 2 to 32
+// lower-case letters, digits and underscores, starting with a letter.
+func ValidEntityCode(s string) bool {
+	if len(s) < 2 || len(s) > 32 || s == string(EntityAll) {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '_'):
+		default:
+			return false
+		}
 	}
+	return true
 }
 
 // InstrumentType, if you want to type trades/holdings too (optional but recommended).
@@ -40,8 +80,69 @@ type InstrumentType string
 const (
 	InstrumentStock  InstrumentType = "stock"
 	InstrumentCrypto InstrumentType = "crypto"
+	InstrumentFX     InstrumentType = "fx" // spot; the symbol is the pair, e.g. EURUSD
+	InstrumentFuture InstrumentType = "future"
+	InstrumentOption InstrumentType = "option"
+	InstrumentBond   InstrumentType = "bond"
 )
 
+// InstrumentTypes lists every instrument type.
+var InstrumentTypes = []InstrumentType{InstrumentStock, InstrumentCrypto, InstrumentFX, InstrumentFuture, InstrumentOption, InstrumentBond}
+
 func (t InstrumentType) Valid() bool {
-	return t == InstrumentStock || t == InstrumentCrypto
+	switch t {
+	case InstrumentStock, InstrumentCrypto, InstrumentFX, InstrumentFuture, InstrumentOption, InstrumentBond:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t InstrumentType) String() string { return string(t) }
+
+func ParseInstrumentType(s string) (InstrumentType, bool) {
+	t := InstrumentType(strings.ToLower(strings.TrimSpace(s)))
+	return t, t.Valid()
+}
+
+// InstrumentRegistry is the instrument master as the rest of the domain
+// needs it.
+type InstrumentRegistry interface {
+	// ContractSize returns the money value of one unit of quantity per unit
+	// of price of the instrument, and whether it is in the master.
+	ContractSize(instrumentType, symbol string) (decimal.Decimal, bool)
+	// TradableOn reports whether the instrument is in the master and, if
+	// so, whether it trades at t.
+	TradableOn(instrumentType, symbol string, t time.Time) (known, tradable bool)
+}
+
+var instruments InstrumentRegistry
+
+// SetInstrumentRegistry makes the domain use r for contract sizes. Call it
+// once at startup, like SetEntityRegistry.
+func SetInstrumentRegistry(r InstrumentRegistry) { instruments = r }
+
+var one = decimal.NewFromInt(1)
+
+// ContractSize is what one unit of quantity of an instrument is worth per
+// unit of price: the multiplier of a future or option, a bond's face value
+// per 100 (bonds are quoted in percent of par), and 1 for everything else,
+// including instruments the master does not know.
+func ContractSize(instrumentType, symbol string) decimal.Decimal {
+	if instruments == nil {
+		return one
+	}
+	if size, ok := instruments.ContractSize(instrumentType, symbol); ok {
+		return size
+	}
+	return one
+}
+
+// InstrumentTradable reports whether the instrument is in the master and
+// whether it trades at t. Without a registry every instrument does.
+func InstrumentTradable(instrumentType, symbol string, t time.Time) (known, tradable bool) {
+	if instruments == nil {
+		return true, true
+	}
+	return instruments.TradableOn(instrumentType, symbol, t)
 }
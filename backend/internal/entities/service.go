@@ -0,0 +1,170 @@
+// Package entities is the registry of trading entities (desks): which exist,
+// which accept trades, and their local time zone and base currency. It is
+// kept in Postgres and served from memory; every replica reloads it
+// periodically, so a new desk is picked up without a restart.
+package entities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Entity is one registered desk.
+type Entity struct {
+	Code         string    `json:"code"`
+	Name         string    `json:"name"`
+	Timezone     string    `json:"timezone"`
+	BaseCurrency string    `json:"base_currency"`
+	Active       bool      `json:"active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+const maxNameLen = 100
+
+// Check normalizes e and rejects entities that cannot be registered.
+func Check(e *Entity) error {
+	e.Code = strings.ToLower(strings.TrimSpace(e.Code))
+	e.Name = strings.TrimSpace(e.Name)
+	e.Timezone = strings.TrimSpace(e.Timezone)
+	e.BaseCurrency = strings.ToUpper(strings.TrimSpace(e.BaseCurrency))
+	if !domain.ValidEntityCode(e.Code) {
+		return fmt.Errorf("code %q: use 2 to 32 lower-case letters, digits and underscores, starting with a letter (and not 'all')", e.Code)
+	}
+	if e.Name == "" || utf8.RuneCountInString(e.Name) > maxNameLen {
+		return fmt.Errorf("name is required (at most %d characters)", maxNameLen)
+	}
+	if e.Timezone == "" {
+		return errors.New("timezone is required, e.g. Europe/Zurich")
+	}
+	if _, err := time.LoadLocation(e.Timezone); err != nil {
+		return fmt.Errorf("timezone %q: %w", e.Timezone, err)
+	}
+	if len(e.BaseCurrency) != 3 || strings.IndexFunc(e.BaseCurrency, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		return fmt.Errorf("base_currency %q: use an ISO 4217 code, e.g. CHF", e.BaseCurrency)
+	}
+	return nil
+}
+
+// Service persists the registry and answers lookups from memory. It
+// implements domain.EntityRegistry.
+type Service struct {
+	DB *pgxpool.Pool
+
+	mu     sync.RWMutex
+	byCode map[string]Entity
+}
+
+func New(db *pgxpool.Pool) *Service {
+	return &Service{DB: db, byCode: make(map[string]Entity)}
+}
+
+const columns = `code, name, timezone, base_currency, active, created_at, updated_at`
+
+// Load replaces the in-memory view with the database's.
+func (s *Service) Load(ctx context.Context) error {
+	rows, err := s.DB.Query(ctx, `SELECT `+columns+` FROM entities`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byCode := make(map[string]Entity)
+	for rows.Next() {
+		var e Entity
+		if err := rows.Scan(&e.Code, &e.Name, &e.Timezone, &e.BaseCurrency, &e.Active, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return err
+		}
+		byCode[e.Code] = e
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byCode = byCode
+	s.mu.Unlock()
+	return nil
+}
+
+// Run reloads the registry every interval until ctx is cancelled, picking
+// up changes made through other replicas. Failures are logged and the
+// previous view kept.
+func (s *Service) Run(ctx context.Context, every time.Duration, logger *zap.Logger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.Load(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn("entities_reload_failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Lookup implements domain.EntityRegistry.
+func (s *Service) Lookup(code string) (active, ok bool) {
+	e, ok := s.Get(code)
+	return e.Active, ok
+}
+
+// Get returns the entity with code.
+func (s *Service) Get(code string) (Entity, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.byCode[code]
+	return e, ok
+}
+
+// Timezone returns the registered time zone of code.
+func (s *Service) Timezone(code string) (string, bool) {
+	e, ok := s.Get(code)
+	return e.Timezone, ok
+}
+
+// All returns every entity, ordered by code.
+func (s *Service) All() []Entity {
+	s.mu.RLock()
+	out := make([]Entity, 0, len(s.byCode))
+	for _, e := range s.byCode {
+		out = append(out, e)
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Code < out[j].Code })
+	return out
+}
+
+// Put registers e (already checked) or replaces the name, time zone, base
+// currency and active flag of the entity with its code, and reports
+// whether it was created. Entities are never deleted, since trades refer to
+// them; deactivate them instead.
+func (s *Service) Put(ctx context.Context, e Entity) (Entity, bool, error) {
+	var created bool
+	err := s.DB.QueryRow(ctx, `
+		INSERT INTO entities (code, name, timezone, base_currency, active)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (code) DO UPDATE
+		SET name = EXCLUDED.name, timezone = EXCLUDED.timezone, base_currency = EXCLUDED.base_currency,
+		    active = EXCLUDED.active, updated_at = now()
+		RETURNING `+columns+`, xmax = 0
+	`, e.Code, e.Name, e.Timezone, e.BaseCurrency, e.Active).Scan(
+		&e.Code, &e.Name, &e.Timezone, &e.BaseCurrency, &e.Active, &e.CreatedAt, &e.UpdatedAt, &created)
+	if err != nil {
+		return Entity{}, false, err
+	}
+	s.mu.Lock()
+	s.byCode[e.Code] = e
+	s.mu.Unlock()
+	return e, created, nil
+}
@@ -0,0 +1,241 @@
+// Package export encodes trades and holdings as CSV, NDJSON or Parquet.
+// Rows are written as they are handed over, so an export of any size only
+// ever holds one row (one Parquet row group) in memory.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/parquet"
+)
+
+// Format is an export file format.
+type Format string
+
+const (
+	CSV     Format = "csv"
+	NDJSON  Format = "ndjson"
+	Parquet Format = "parquet"
+)
+
+// ParseFormat accepts a format name.
+func ParseFormat(s string) (Format, bool) {
+	switch f := Format(s); f {
+	case CSV, NDJSON, Parquet:
+		return f, true
+	}
+	return "", false
+}
+
+// ContentType is the media type of f.
+func (f Format) ContentType() string {
+	switch f {
+	case NDJSON:
+		return "application/x-ndjson"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// FormatOf maps a media type to its format. NDJSON is also known as JSON
+// Lines.
+func FormatOf(mediaType string) (Format, bool) {
+	switch mediaType {
+	case "text/csv":
+		return CSV, true
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return NDJSON, true
+	case "application/vnd.apache.parquet", "application/x-parquet":
+		return Parquet, true
+	}
+	return "", false
+}
+
+// Writer encodes rows of T. Close completes the output; it does not close
+// the underlying writer.
+type Writer[T any] interface {
+	Write(row T) error
+	Close() error
+}
+
+// column is one exported field of T.
+type column[T any] struct {
+	parquet.Column
+	value func(T) any // string, int, int64, bool, time.Time, decimal.Decimal, pointers to those, or nil
+}
+
+// Quantities and prices are NUMERIC(20,8) in the database, cost figures
+// NUMERIC(38,16).
+func qty(name string) parquet.Column {
+	return parquet.Column{Name: name, Kind: parquet.Decimal, Precision: 20, Scale: 8}
+}
+
+func amount(name string) parquet.Column {
+	return parquet.Column{Name: name, Kind: parquet.Decimal, Precision: 38, Scale: 16}
+}
+
+func str(name string) parquet.Column {
+	return parquet.Column{Name: name, Kind: parquet.String}
+}
+
+func optional(c parquet.Column) parquet.Column {
+	c.Optional = true
+	return c
+}
+
+var tradeColumns = []column[models.Trade]{
+	{str("trade_id"), func(t models.Trade) any { return t.TradeID }},
+	{str("entity"), func(t models.Trade) any { return t.Entity }},
+	{str("instrument_type"), func(t models.Trade) any { return t.InstrumentType }},
+	{str("symbol"), func(t models.Trade) any { return t.Symbol }},
+	{qty("quantity"), func(t models.Trade) any { return t.Quantity }},
+	{optional(qty("price")), func(t models.Trade) any { return t.Price }},
+	{parquet.Column{Name: "ts", Kind: parquet.Timestamp}, func(t models.Trade) any { return t.TS }},
+	{str("event_type"), func(t models.Trade) any { return t.Event }},
+	{parquet.Column{Name: "version", Kind: parquet.Int64}, func(t models.Trade) any { return t.Version }},
+}
+
+// Holdings carry their valuation when marked to market; the valuation
+// columns are empty otherwise.
+var holdingColumns = []column[models.Holding]{
+	{str("entity"), func(h models.Holding) any { return h.Entity }},
+	{str("instrument_type"), func(h models.Holding) any { return h.InstrumentType }},
+	{str("symbol"), func(h models.Holding) any { return h.Symbol }},
+	{qty("quantity"), func(h models.Holding) any { return h.Quantity }},
+	{amount("avg_cost"), func(h models.Holding) any { return h.AvgCost }},
+	{amount("cost_basis"), func(h models.Holding) any { return h.CostBasis }},
+	{amount("realized_pnl"), func(h models.Holding) any { return h.RealizedPnL }},
+	{optional(qty("price")), valuation(func(v *models.Valuation) any { return v.Price })},
+	{parquet.Column{Name: "price_ts", Kind: parquet.Timestamp, Optional: true}, valuation(func(v *models.Valuation) any { return v.PriceTS })},
+	{parquet.Column{Name: "price_stale", Kind: parquet.Bool, Optional: true}, valuation(func(v *models.Valuation) any { return v.Stale })},
+	{optional(amount("market_value")), valuation(func(v *models.Valuation) any { return v.MarketValue })},
+	{optional(amount("unrealized_pnl")), valuation(func(v *models.Valuation) any { return v.UnrealizedPnL })},
+}
+
+func valuation(f func(*models.Valuation) any) func(models.Holding) any {
+	return func(h models.Holding) any {
+		if h.Valuation == nil {
+			return nil
+		}
+		return f(h.Valuation)
+	}
+}
+
+// Trades returns a writer of trades in format f to w.
+func Trades(w io.Writer, f Format) Writer[models.Trade] {
+	return newWriter(w, f, tradeColumns)
+}
+
+// Holdings returns a writer of holdings in format f to w.
+func Holdings(w io.Writer, f Format) Writer[models.Holding] {
+	return newWriter(w, f, holdingColumns)
+}
+
+func newWriter[T any](w io.Writer, f Format, cols []column[T]) Writer[T] {
+	switch f {
+	case NDJSON:
+		return &ndjsonWriter[T]{enc: json.NewEncoder(w)}
+	case Parquet:
+		pcs := make([]parquet.Column, len(cols))
+		for i, c := range cols {
+			pcs[i] = c.Column
+		}
+		return &parquetWriter[T]{pw: parquet.NewWriter(w, pcs), cols: cols}
+	default:
+		return &csvWriter[T]{cw: csv.NewWriter(w), cols: cols}
+	}
+}
+
+// ndjsonWriter writes each row as its API JSON, one per line.
+type ndjsonWriter[T any] struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter[T]) Write(row T) error { return w.enc.Encode(row) }
+func (w *ndjsonWriter[T]) Close() error      { return nil }
+
+type csvWriter[T any] struct {
+	cw     *csv.Writer
+	cols   []column[T]
+	header bool
+	rec    []string
+}
+
+func (w *csvWriter[T]) Write(row T) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	for i, c := range w.cols {
+		w.rec[i] = csvValue(c.value(row))
+	}
+	return w.cw.Write(w.rec)
+}
+
+func (w *csvWriter[T]) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	w.rec = make([]string, len(w.cols))
+	for i, c := range w.cols {
+		w.rec[i] = c.Name
+	}
+	return w.cw.Write(w.rec)
+}
+
+// Close writes the header if no row was written, so an empty export is
+// still a valid CSV file.
+func (w *csvWriter[T]) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+// csvValue formats v as the JSON API does; nulls are empty.
+func csvValue(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	case decimal.Decimal:
+		return x.String()
+	case *decimal.Decimal:
+		if x != nil {
+			return x.String()
+		}
+	}
+	return ""
+}
+
+type parquetWriter[T any] struct {
+	pw   *parquet.Writer
+	cols []column[T]
+	vals []any
+}
+
+func (w *parquetWriter[T]) Write(row T) error {
+	if w.vals == nil {
+		w.vals = make([]any, len(w.cols))
+	}
+	for i, c := range w.cols {
+		w.vals[i] = c.value(row)
+	}
+	return w.pw.Write(w.vals...)
+}
+
+func (w *parquetWriter[T]) Close() error { return w.pw.Close() }
@@ -0,0 +1,284 @@
+package holdings
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// Key identifies one holding.
+type Key struct {
+	Entity         string
+	InstrumentType string
+	Symbol         string
+}
+
+func KeyOf(t models.Trade) Key {
+	return Key{Entity: t.Entity, InstrumentType: t.InstrumentType, Symbol: t.Symbol}
+}
+
+// size is the contract size of the holding's instrument.
+func (k Key) size() decimal.Decimal { return domain.ContractSize(k.InstrumentType, k.Symbol) }
+
+func (k Key) less(o Key) bool {
+	if k.Entity != o.Entity {
+		return k.Entity < o.Entity
+	}
+	if k.InstrumentType != o.InstrumentType {
+		return k.InstrumentType < o.InstrumentType
+	}
+	return k.Symbol < o.Symbol
+}
+
+// SortedKeys returns the keys of m in (entity, instrument_type, symbol)
+// order, the order in which holdings rows are locked.
+func SortedKeys[V any](m map[Key]V) []Key {
+	keys := make([]Key, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	return keys
+}
+
+// ApplyTrade records the trade and, only if its trade_id has not been seen
+// before, folds it into the matching holding (quantity, average cost and
+// realized P&L). It reports whether the
+// trade was new; a replayed or duplicated trade returns false and leaves
+// holdings untouched.
+func (s *Service) ApplyTrade(ctx context.Context, t models.Trade) (bool, error) {
+	applied, err := s.ApplyTrades(ctx, []models.Trade{t})
+	if err != nil {
+		return false, err
+	}
+	return applied[0], nil
+}
+
+// ApplyTrades is ApplyTrade for a batch, in a single transaction: either every
+// trade is recorded or none is. applied[i] reports whether trades[i] was new;
+// a trade_id repeated within the batch counts once. Trades are folded per
+// holding in batch order so each affected holding is written exactly once.
+//
+// Amends and cancels are applied in their place in the batch (see correct);
+// for them applied[i] reports whether the version was new.
+func (s *Service) ApplyTrades(ctx context.Context, trades []models.Trade) ([]bool, error) {
+	applied := make([]bool, len(trades))
+	if len(trades) == 0 {
+		return applied, nil
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	committed := make([]Change, 0, len(trades))
+	for start := 0; start < len(trades); {
+		if trades[start].IsCorrection() {
+			ch, ok, err := s.correct(ctx, tx, trades[start])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				applied[start] = true
+				committed = append(committed, ch)
+			}
+			start++
+			continue
+		}
+		end := start + 1
+		for end < len(trades) && !trades[end].IsCorrection() {
+			end++
+		}
+		changes, err := s.applyNew(ctx, tx, trades[start:end], applied[start:end])
+		if err != nil {
+			return nil, err
+		}
+		committed = append(committed, changes...)
+		start = end
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	if len(committed) > 0 {
+		for _, o := range s.Observers {
+			o.TradesCommitted(committed)
+		}
+	}
+	return applied, nil
+}
+
+// applyNew records new trades in tx, folds them into their holdings, and
+// returns what each new one did.
+func (s *Service) applyNew(ctx context.Context, tx pgx.Tx, trades []models.Trade, applied []bool) ([]Change, error) {
+	// 1) Record the trades; a conflict on trade_id marks a duplicate
+	// delivery, which is counted on the stored trade instead.
+	seen := make(map[string]bool, len(trades))
+	queued := make([]int, 0, len(trades))
+	batch := &pgx.Batch{}
+	for i, t := range trades {
+		if seen[t.TradeID] {
+			continue
+		}
+		seen[t.TradeID] = true
+		queued = append(queued, i)
+		o := originOf(t)
+		batch.Queue(`
+			INSERT INTO trades (trade_id, entity, instrument_type, symbol, quantity, price, ts,
+			                    source, source_topic, source_partition, source_offset, received_at, applied_at)
+			VALUES ($1, $2, $3::instrument_type, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12, now())
+			ON CONFLICT (trade_id) DO UPDATE
+			SET duplicate_count = trades.duplicate_count + 1, last_duplicate_at = now()
+			RETURNING xmax = 0
+		`, t.TradeID, t.Entity, t.InstrumentType, t.Symbol, t.Quantity, t.Price, t.TS,
+			o.Source, o.Topic, o.Partition, o.Offset, o.ReceivedAt)
+	}
+	br := tx.SendBatch(ctx, batch)
+	for _, i := range queued {
+		// xmax = 0 only for a freshly inserted row.
+		if err := br.QueryRow().Scan(&applied[i]); err != nil {
+			_ = br.Close()
+			return nil, err
+		}
+	}
+	if err := br.Close(); err != nil {
+		return nil, err
+	}
+
+	// 2) Group the new trades (by index) per holding, keeping arrival order.
+	byKey := make(map[Key][]int)
+	for i, t := range trades {
+		if applied[i] {
+			k := KeyOf(t)
+			byKey[k] = append(byKey[k], i)
+		}
+	}
+	if len(byKey) == 0 {
+		// Everything was a replay: holdings already reflect these trades;
+		// only the duplicate counts need committing.
+		return nil, nil
+	}
+
+	// 3) Lock each holding (creating it if needed), in key order so
+	// concurrent batches cannot deadlock, and fold its trades in.
+	keys := SortedKeys(byKey)
+
+	positions, err := lockPositions(ctx, tx, keys)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*Change, len(trades))
+	for _, k := range keys {
+		p, size := positions[k], k.size()
+		for _, i := range byKey[k] {
+			t := trades[i]
+			next := p.Apply(t.Quantity, t.Price, size)
+			changes[i] = &Change{
+				Trade:             t,
+				Holding:           next.holding(k),
+				QuantityChange:    next.Quantity.Sub(p.Quantity),
+				RealizedPnLChange: next.RealizedPnL.Sub(p.RealizedPnL),
+			}
+			p = next
+		}
+		positions[k] = p
+	}
+
+	// 4) Write each holding back once.
+	if err := savePositions(ctx, tx, keys, positions); err != nil {
+		return nil, err
+	}
+
+	// 5) Let dependent subsystems book the same trades atomically.
+	fresh := make([]models.Trade, 0, len(trades))
+	committed := make([]Change, 0, len(trades))
+	for i, t := range trades {
+		if applied[i] {
+			fresh = append(fresh, t)
+			committed = append(committed, *changes[i])
+		}
+	}
+	for _, h := range s.Hooks {
+		if err := h.TradesApplied(ctx, tx, fresh); err != nil {
+			return nil, err
+		}
+	}
+	return committed, nil
+}
+
+// lockPositions locks the holdings for keys, creating empty ones as needed,
+// and returns their current state.
+func lockPositions(ctx context.Context, tx pgx.Tx, keys []Key) (map[Key]Position, error) {
+	batch := &pgx.Batch{}
+	for _, k := range keys {
+		batch.Queue(`
+			INSERT INTO holdings (entity, instrument_type, symbol)
+			VALUES ($1, $2::instrument_type, $3)
+			ON CONFLICT (entity, instrument_type, symbol) DO NOTHING
+		`, k.Entity, k.InstrumentType, k.Symbol)
+		batch.Queue(`
+			SELECT quantity, avg_cost, cost_basis, realized_pnl FROM holdings
+			WHERE entity = $1 AND instrument_type = $2::instrument_type AND symbol = $3
+			FOR UPDATE
+		`, k.Entity, k.InstrumentType, k.Symbol)
+	}
+	br := tx.SendBatch(ctx, batch)
+	defer br.Close()
+
+	out := make(map[Key]Position, len(keys))
+	for _, k := range keys {
+		if _, err := br.Exec(); err != nil {
+			return nil, err
+		}
+		var p Position
+		if err := br.QueryRow().Scan(&p.Quantity, &p.AvgCost, &p.CostBasis, &p.RealizedPnL); err != nil {
+			return nil, err
+		}
+		out[k] = p
+	}
+	return out, br.Close()
+}
+
+func savePositions(ctx context.Context, tx pgx.Tx, keys []Key, positions map[Key]Position) error {
+	batch := &pgx.Batch{}
+	for _, k := range keys {
+		p := positions[k]
+		batch.Queue(`
+			UPDATE holdings
+			SET quantity = $4, avg_cost = $5, cost_basis = $6, realized_pnl = $7, updated_at = now()
+			WHERE entity = $1 AND instrument_type = $2::instrument_type AND symbol = $3
+		`, k.Entity, k.InstrumentType, k.Symbol, p.Quantity, p.AvgCost, p.CostBasis, p.RealizedPnL)
+	}
+	return execBatch(ctx, tx, batch, nil)
+}
+
+// execBatch sends b and reports the rows affected by each queued statement.
+func execBatch(ctx context.Context, tx pgx.Tx, b *pgx.Batch, each func(n int, rows int64)) error {
+	br := tx.SendBatch(ctx, b)
+	for n := 0; n < b.Len(); n++ {
+		tag, err := br.Exec()
+		if err != nil {
+			_ = br.Close()
+			return err
+		}
+		if each != nil {
+			each(n, tag.RowsAffected())
+		}
+	}
+	return br.Close()
+}
+
+// originOf returns t's origin, defaulting to a trade received just now.
+func originOf(t models.Trade) models.Origin {
+	if t.Origin != nil {
+		return *t.Origin
+	}
+	return models.Origin{ReceivedAt: time.Now().UTC()}
+}
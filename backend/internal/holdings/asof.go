@@ -0,0 +1,270 @@
+package holdings
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// Point-in-time holdings are folded from the trades ledger in trade-time
+// order (ts, then arrival), starting from the latest checkpoint at or before
+// the requested time. The live holdings table folds in arrival order instead,
+// so average cost can differ slightly when trades arrive out of order.
+// Amended trades count as they are now, at their current ts, and cancelled
+// ones not at all.
+
+// AsOf returns the holdings of entity (every entity when empty) including all
+// trades with ts <= asOf.
+func (s *Service) AsOf(ctx context.Context, asOf time.Time, entity string) ([]models.Holding, error) {
+	// One snapshot for the checkpoint lookup and the replay, so a trade
+	// committed in between is neither missed nor counted twice.
+	tx, err := s.DB.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	cp, err := latestCheckpoint(ctx, tx, asOf)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := loadCheckpoint(ctx, tx, cp.ID, entity)
+	if err != nil {
+		return nil, err
+	}
+	if err := replay(ctx, tx, positions, cp.AsOf, asOf, entity, 0); err != nil {
+		return nil, err
+	}
+
+	out := make([]models.Holding, 0, len(positions))
+	for _, k := range SortedKeys(positions) {
+		out = append(out, positions[k].holding(k))
+	}
+	return out, nil
+}
+
+// Checkpoint stores the holdings as of asOf, folding forward from the
+// previous checkpoint. It reports false when no trade landed since then, in
+// which case the previous checkpoint already answers for asOf, or when a
+// correction invalidated it while it was being computed. Checkpoints made
+// obsolete by late trades are dropped first.
+//
+// Trades are only locked while the checkpoint's extent is fixed (the last
+// trade id it covers); the replay, which on the first run covers the whole
+// ledger, runs alongside ingestion.
+func (s *Service) Checkpoint(ctx context.Context, asOf time.Time) (bool, error) {
+	prev, cp, lastID, err := s.beginCheckpoint(ctx, asOf)
+	if err != nil || cp == 0 {
+		return false, err
+	}
+
+	// Trades up to lastID are all committed; an amend or cancel of one of
+	// them since deletes the pending checkpoint, which the update below
+	// then finds gone (or, if it is still running, conflicts with).
+	tx, err := s.DB.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	positions, err := loadCheckpoint(ctx, tx, prev.ID, "")
+	if err != nil {
+		return false, err
+	}
+	if err := replay(ctx, tx, positions, prev.AsOf, asOf, "", lastID); err != nil {
+		return false, err
+	}
+	tag, err := tx.Exec(ctx, `UPDATE holdings_checkpoints SET complete = true WHERE id = $1`, cp)
+	if isSerializationFailure(err) || (err == nil && tag.RowsAffected() == 0) {
+		return false, s.dropCheckpoint(ctx, cp)
+	}
+	if err != nil {
+		return false, err
+	}
+	batch := &pgx.Batch{}
+	for _, k := range SortedKeys(positions) {
+		p := positions[k]
+		batch.Queue(`
+			INSERT INTO holdings_checkpoint_positions
+			  (checkpoint_id, entity, instrument_type, symbol, quantity, avg_cost, cost_basis, realized_pnl)
+			VALUES ($1, $2, $3::instrument_type, $4, $5, $6, $7, $8)
+		`, cp, k.Entity, k.InstrumentType, k.Symbol, p.Quantity, p.AvgCost, p.CostBasis, p.RealizedPnL)
+	}
+	if err := execBatch(ctx, tx, batch, nil); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); isSerializationFailure(err) {
+		return false, s.dropCheckpoint(ctx, cp)
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// beginCheckpoint records a pending checkpoint as of asOf covering the
+// trades up to lastID, and returns it with the checkpoint to fold forward
+// from. cp is 0 when no trade landed since prev.
+func (s *Service) beginCheckpoint(ctx context.Context, asOf time.Time) (prev checkpoint, cp, lastID int64, err error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return checkpoint{}, 0, 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Waits for in-flight trade inserts and holds off new ones, so every
+	// trade up to MAX(id) is committed and any later trade gets a higher id.
+	if _, err := tx.Exec(ctx, `LOCK TABLE trades IN SHARE MODE`); err != nil {
+		return checkpoint{}, 0, 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM holdings_checkpoints
+		WHERE as_of >= (SELECT MIN(ts) FROM trades WHERE id > `+lastCheckpointedTrade+`)
+		   OR (NOT complete AND created_at < now() - interval '1 day')
+	`); err != nil {
+		return checkpoint{}, 0, 0, err
+	}
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM trades`).Scan(&lastID); err != nil {
+		return checkpoint{}, 0, 0, err
+	}
+	if prev, err = latestCheckpoint(ctx, tx, asOf); err != nil {
+		return checkpoint{}, 0, 0, err
+	}
+	if prev.ID != 0 {
+		var pending bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM trades WHERE ts > $1 AND ts <= $2)
+		`, prev.AsOf, asOf).Scan(&pending); err != nil {
+			return checkpoint{}, 0, 0, err
+		}
+		if !pending {
+			return prev, 0, lastID, tx.Commit(ctx)
+		}
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO holdings_checkpoints (as_of, last_trade_id, complete) VALUES ($1, $2, false) RETURNING id
+	`, asOf, lastID).Scan(&cp); err != nil {
+		return checkpoint{}, 0, 0, err
+	}
+	return prev, cp, lastID, tx.Commit(ctx)
+}
+
+// dropCheckpoint discards a pending checkpoint a correction invalidated.
+func (s *Service) dropCheckpoint(ctx context.Context, id int64) error {
+	_, err := s.DB.Exec(ctx, `DELETE FROM holdings_checkpoints WHERE id = $1 AND NOT complete`, id)
+	return err
+}
+
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40001"
+}
+
+// RunCheckpoints takes a checkpoint every interval until ctx is cancelled.
+// Failures are logged and retried at the next tick.
+func (s *Service) RunCheckpoints(ctx context.Context, every time.Duration, logger *zap.Logger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			created, err := s.Checkpoint(ctx, now.UTC())
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("holdings_checkpoint_failed", zap.Error(err))
+				}
+				continue
+			}
+			logger.Info("holdings_checkpoint", zap.Time("as_of", now.UTC()), zap.Bool("created", created))
+		}
+	}
+}
+
+// lastCheckpointedTrade is the highest trade id covered by any checkpoint.
+// Every checkpoint was valid for the trades up to it when the latest one was
+// taken, so only trades past it can make a checkpoint obsolete.
+const lastCheckpointedTrade = `(SELECT COALESCE(MAX(last_trade_id), 0) FROM holdings_checkpoints)`
+
+type checkpoint struct {
+	ID   int64 // 0: none, replay from the beginning of the ledger
+	AsOf time.Time
+}
+
+// latestCheckpoint returns the newest checkpoint at or before asOf that no
+// late trade has made obsolete.
+func latestCheckpoint(ctx context.Context, tx pgx.Tx, asOf time.Time) (checkpoint, error) {
+	var cp checkpoint
+	err := tx.QueryRow(ctx, `
+		SELECT id, as_of FROM holdings_checkpoints
+		WHERE as_of <= $1 AND complete
+		  AND as_of < COALESCE((SELECT MIN(ts) FROM trades WHERE id > `+lastCheckpointedTrade+`), 'infinity')
+		ORDER BY as_of DESC, id DESC
+		LIMIT 1
+	`, asOf).Scan(&cp.ID, &cp.AsOf)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return checkpoint{}, nil
+	}
+	return cp, err
+}
+
+func loadCheckpoint(ctx context.Context, tx pgx.Tx, id int64, entity string) (map[Key]Position, error) {
+	out := make(map[Key]Position)
+	if id == 0 {
+		return out, nil
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT entity::text, instrument_type::text, symbol, quantity, avg_cost, cost_basis, realized_pnl
+		FROM holdings_checkpoint_positions
+		WHERE checkpoint_id = $1 AND ($2 = '' OR entity::text = $2)
+	`, id, entity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k Key
+		var p Position
+		if err := rows.Scan(&k.Entity, &k.InstrumentType, &k.Symbol, &p.Quantity, &p.AvgCost, &p.CostBasis, &p.RealizedPnL); err != nil {
+			return nil, err
+		}
+		out[k] = p
+	}
+	return out, rows.Err()
+}
+
+// replay folds the booked trades with from < ts <= to into positions. A zero from
+// replays from the beginning; a non-zero maxID leaves out later trades.
+func replay(ctx context.Context, tx pgx.Tx, positions map[Key]Position, from, to time.Time, entity string, maxID int64) error {
+	var after *time.Time
+	if !from.IsZero() {
+		after = &from
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT entity::text, instrument_type::text, symbol, quantity, price
+		FROM trades
+		WHERE ($1::timestamptz IS NULL OR ts > $1) AND ts <= $2 AND ($3 = '' OR entity::text = $3)
+		  AND ($4::bigint = 0 OR id <= $4::bigint) AND event_type <> 'cancel'
+		ORDER BY ts, id
+	`, after, to, entity, maxID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k Key
+		var qty decimal.Decimal
+		var price *decimal.Decimal
+		if err := rows.Scan(&k.Entity, &k.InstrumentType, &k.Symbol, &qty, &price); err != nil {
+			return err
+		}
+		positions[k] = positions[k].Apply(qty, price, k.size())
+	}
+	return rows.Err()
+}
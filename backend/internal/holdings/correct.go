@@ -0,0 +1,195 @@
+package holdings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// Amends and cancels rewrite history: the trade keeps its place in the
+// ledger, so every holding it was or now is booked on is folded again from
+// its trades, in arrival order, as Rebuild would. Checkpoints from the
+// trade's (old or new) time onwards are dropped, since point-in-time
+// holdings change with it. End-of-day snapshots are records of what was
+// booked at the time and are left as they are.
+//
+// A correction therefore costs a read of every trade on the (at most two)
+// holdings it touches, plus whatever each ReplayHook rebuilds for them, and
+// it runs under those holdings' row locks: live trades on them wait for it,
+// other holdings do not. It cannot be applied as a delta instead, because
+// Apply loses the average cost when a position closes or flips, and
+// checkpoints fold in trade time rather than arrival order, so none of them
+// is a state the arrival-order fold passes through.
+
+// Reasons a correction is rejected. They are permanent: retrying the same
+// message cannot succeed, though it may once the missing versions arrive.
+var (
+	ErrUnknownTrade   = errors.New("no such trade")
+	ErrVersionGap     = errors.New("version out of sequence")
+	ErrTradeCancelled = errors.New("trade is cancelled")
+)
+
+// IsCorrectionError reports whether err rejects an amend or cancel.
+func IsCorrectionError(err error) bool {
+	return errors.Is(err, ErrUnknownTrade) || errors.Is(err, ErrVersionGap) || errors.Is(err, ErrTradeCancelled)
+}
+
+// correct applies an amend or cancel of t.TradeID in tx. It reports false,
+// counting a duplicate delivery, when t.Version is already recorded. The
+// version must otherwise follow the current one, and a cancelled trade
+// takes no further versions.
+func (s *Service) correct(ctx context.Context, tx pgx.Tx, t models.Trade) (Change, bool, error) {
+	var prev models.Trade
+	err := tx.QueryRow(ctx, `
+		SELECT trade_id::text, entity::text, instrument_type::text, symbol, quantity, price, ts, event_type, version
+		FROM trades WHERE trade_id = $1
+		FOR UPDATE
+	`, t.TradeID).Scan(&prev.TradeID, &prev.Entity, &prev.InstrumentType, &prev.Symbol, &prev.Quantity, &prev.Price, &prev.TS,
+		&prev.Event, &prev.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Change{}, false, fmt.Errorf("%s trade %s: %w", t.Event, t.TradeID, ErrUnknownTrade)
+	}
+	if err != nil {
+		return Change{}, false, err
+	}
+	switch {
+	case t.Version <= prev.Version:
+		_, err := tx.Exec(ctx, `
+			UPDATE trades SET duplicate_count = duplicate_count + 1, last_duplicate_at = now() WHERE trade_id = $1
+		`, t.TradeID)
+		return Change{}, false, err
+	case prev.Event == models.EventCancel:
+		return Change{}, false, fmt.Errorf("%s trade %s: %w", t.Event, t.TradeID, ErrTradeCancelled)
+	case t.Version != prev.Version+1:
+		return Change{}, false, fmt.Errorf("%s trade %s to version %d, current is %d: %w",
+			t.Event, t.TradeID, t.Version, prev.Version, ErrVersionGap)
+	}
+
+	next := corrected(prev, t)
+
+	// 1) Keep the replaced version and record the new one in its place.
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO trade_versions (trade_id, version, event_type, entity, instrument_type, symbol, quantity, price, ts,
+		                            source, source_topic, source_partition, source_offset, received_at, applied_at)
+		SELECT trade_id, version, event_type, entity, instrument_type, symbol, quantity, price, ts,
+		       source, source_topic, source_partition, source_offset, received_at, applied_at
+		FROM trades WHERE trade_id = $1
+	`, t.TradeID); err != nil {
+		return Change{}, false, err
+	}
+	o := originOf(t)
+	if _, err := tx.Exec(ctx, `
+		UPDATE trades
+		SET event_type = $2, version = $3,
+		    entity = $4, instrument_type = $5::instrument_type, symbol = $6, quantity = $7, price = $8, ts = $9,
+		    source = NULLIF($10, ''), source_topic = NULLIF($11, ''), source_partition = $12, source_offset = $13,
+		    received_at = $14, applied_at = now()
+		WHERE trade_id = $1
+	`, t.TradeID, next.Event, next.Version,
+		next.Entity, next.InstrumentType, next.Symbol, next.Quantity, next.Price, next.TS,
+		o.Source, o.Topic, o.Partition, o.Offset, o.ReceivedAt); err != nil {
+		return Change{}, false, err
+	}
+
+	// 2) Point-in-time holdings from the earlier of the two times on no
+	// longer hold.
+	from := prev.TS
+	if next.TS.Before(from) {
+		from = next.TS
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM holdings_checkpoints WHERE as_of >= $1`, from); err != nil {
+		return Change{}, false, err
+	}
+
+	// 3) Fold the affected holdings again.
+	oldKey, newKey := KeyOf(prev), KeyOf(next)
+	affected := map[Key]bool{oldKey: true, newKey: true}
+	keys := SortedKeys(affected)
+	before, err := lockPositions(ctx, tx, keys)
+	if err != nil {
+		return Change{}, false, err
+	}
+	after, err := foldPositions(ctx, tx, keys)
+	if err != nil {
+		return Change{}, false, err
+	}
+	if err := savePositions(ctx, tx, keys, after); err != nil {
+		return Change{}, false, err
+	}
+	for _, h := range s.Hooks {
+		if rh, ok := h.(ReplayHook); ok {
+			if err := rh.HoldingsReplayed(ctx, tx, keys); err != nil {
+				return Change{}, false, err
+			}
+		}
+	}
+
+	change := func(t models.Trade, k Key) Change {
+		return Change{
+			Trade:             t,
+			Holding:           after[k].holding(k),
+			QuantityChange:    after[k].Quantity.Sub(before[k].Quantity),
+			RealizedPnLChange: after[k].RealizedPnL.Sub(before[k].RealizedPnL),
+		}
+	}
+	ch := change(next, newKey)
+	ch.Previous = &prev
+	if oldKey != newKey {
+		r := change(prev, oldKey)
+		ch.Reversed = &r
+	}
+	return ch, true, nil
+}
+
+// corrected returns the trade as the amend or cancel t leaves prev: a cancel
+// voids the trade as booked, and an amend without a ts keeps the booked one
+// rather than moving the trade to the time the amend arrived.
+func corrected(prev, t models.Trade) models.Trade {
+	next := t
+	switch {
+	case t.Event == models.EventCancel:
+		next = prev
+		next.Event, next.Version, next.Origin = t.Event, t.Version, t.Origin
+	case t.TS.IsZero():
+		next.TS = prev.TS
+	}
+	return next
+}
+
+// foldPositions recomputes the holdings for keys from their booked trades,
+// in arrival order. It reads only the trades of keys, one query per key.
+func foldPositions(ctx context.Context, tx pgx.Tx, keys []Key) (map[Key]Position, error) {
+	out := make(map[Key]Position, len(keys))
+	for _, k := range keys {
+		rows, err := tx.Query(ctx, `
+			SELECT quantity, price FROM trades
+			WHERE entity = $1 AND instrument_type = $2::instrument_type AND symbol = $3
+			  AND event_type <> 'cancel'
+			ORDER BY id
+		`, k.Entity, k.InstrumentType, k.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		var p Position
+		size := k.size()
+		for rows.Next() {
+			var qty decimal.Decimal
+			var price *decimal.Decimal
+			if err := rows.Scan(&qty, &price); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			p = p.Apply(qty, price, size)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		out[k] = p
+	}
+	return out, nil
+}
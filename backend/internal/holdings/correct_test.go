@@ -0,0 +1,72 @@
+package holdings
+
+import (
+	"testing"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+const tradeID = "0b8e6a52-6f1e-4c1a-9a57-2f4f6f7f4a10"
+
+var booked = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+func bookedTrade() models.Trade {
+	px := decimal.MustParse("189.25")
+	return models.Trade{
+		TradeID: tradeID, Entity: "zurich", InstrumentType: "stock", Symbol: "AAPL",
+		Quantity: decimal.MustParse("10"), Price: &px, TS: booked, Event: models.EventNew, Version: 1,
+	}
+}
+
+func TestAmendWithoutTSKeepsBookedTime(t *testing.T) {
+	// The amend message as it arrives: quantity changed, no ts.
+	msg := bookedTrade()
+	msg.Event, msg.Version, msg.TS = "Amend", 2, time.Time{}
+	msg.Quantity = decimal.MustParse("12")
+	amend, err := PrepareTrade(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !amend.TS.IsZero() {
+		t.Fatalf("amend stamped with %s", amend.TS)
+	}
+
+	next := corrected(bookedTrade(), amend)
+	if !next.TS.Equal(booked) {
+		t.Errorf("ts = %s, want the booked %s", next.TS, booked)
+	}
+	if next.Quantity.String() != "12" || next.Version != 2 || next.Event != models.EventAmend {
+		t.Errorf("amended to %s v%d %s", next.Quantity, next.Version, next.Event)
+	}
+}
+
+func TestCorrected(t *testing.T) {
+	prev := bookedTrade()
+
+	moved := prev
+	moved.Event, moved.Version, moved.TS = models.EventAmend, 2, booked.Add(time.Hour)
+	if got := corrected(prev, moved); !got.TS.Equal(booked.Add(time.Hour)) {
+		t.Errorf("amend with ts: ts = %s", got.TS)
+	}
+
+	cancel := models.Trade{TradeID: tradeID, Event: models.EventCancel, Version: 2}
+	got := corrected(prev, cancel)
+	if got.Event != models.EventCancel || got.Version != 2 || !got.TS.Equal(booked) || got.Quantity.String() != "10" || got.Symbol != "AAPL" {
+		t.Errorf("cancel = %+v, want the booked trade at version 2", got)
+	}
+}
+
+func TestPrepareTradeStampsNewTrades(t *testing.T) {
+	msg := bookedTrade()
+	msg.Event, msg.Version, msg.TS = "", 0, time.Time{}
+	before := time.Now()
+	tr, err := PrepareTrade(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.TS.Before(before) || tr.TS.After(time.Now()) {
+		t.Errorf("new trade stamped with %s", tr.TS)
+	}
+}
@@ -0,0 +1,101 @@
+package holdings
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/jackc/pgx/v5"
+)
+
+// ledgerTx answers foldPositions' per-key query from an in-memory ledger
+// and counts what it serves.
+type ledgerTx struct {
+	pgx.Tx
+	trades  map[Key][]ledgerRow
+	queries []Key
+	scanned int
+}
+
+type ledgerRow struct {
+	qty   decimal.Decimal
+	price *decimal.Decimal
+}
+
+func (tx *ledgerTx) Query(_ context.Context, _ string, args ...any) (pgx.Rows, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("query not restricted to a holding: %v", args)
+	}
+	k := Key{Entity: args[0].(string), InstrumentType: args[1].(string), Symbol: args[2].(string)}
+	tx.queries = append(tx.queries, k)
+	return &ledgerRows{tx: tx, rows: tx.trades[k], at: -1}, nil
+}
+
+type ledgerRows struct {
+	pgx.Rows
+	tx   *ledgerTx
+	rows []ledgerRow
+	at   int
+}
+
+func (r *ledgerRows) Next() bool { r.at++; return r.at < len(r.rows) }
+func (r *ledgerRows) Close()     {}
+func (r *ledgerRows) Err() error { return nil }
+
+func (r *ledgerRows) Scan(dest ...any) error {
+	row := r.rows[r.at]
+	*dest[0].(*decimal.Decimal) = row.qty
+	*dest[1].(**decimal.Decimal) = row.price
+	r.tx.scanned++
+	return nil
+}
+
+// TestFoldPositionsReadsOnlyAffectedHoldings bounds the cost of a
+// correction: the fold reads each affected holding's trades once and
+// nothing of the rest of the ledger, however large.
+func TestFoldPositionsReadsOnlyAffectedHoldings(t *testing.T) {
+	aapl := Key{Entity: "zurich", InstrumentType: "stock", Symbol: "AAPL"}
+	msft := Key{Entity: "zurich", InstrumentType: "stock", Symbol: "MSFT"}
+	other := Key{Entity: "new_york", InstrumentType: "stock", Symbol: "AAPL"}
+	tx := &ledgerTx{trades: map[Key][]ledgerRow{}}
+	book := func(k Key, n int) {
+		for i := range n {
+			px := decimal.MustParse(strconv.Itoa(100 + i%7))
+			qty := decimal.MustParse("10")
+			if i%3 == 2 {
+				qty = decimal.MustParse("-15")
+			}
+			tx.trades[k] = append(tx.trades[k], ledgerRow{qty, &px})
+		}
+	}
+	book(aapl, 30)
+	book(msft, 12)
+	book(other, 5000)
+
+	keys := []Key{aapl, msft}
+	got, err := foldPositions(context.Background(), tx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.queries) != len(keys) || tx.queries[0] != aapl || tx.queries[1] != msft {
+		t.Errorf("queried %v, want one query per affected holding", tx.queries)
+	}
+	if want := len(tx.trades[aapl]) + len(tx.trades[msft]); tx.scanned != want {
+		t.Errorf("read %d trades, want the %d on the affected holdings", tx.scanned, want)
+	}
+	for _, k := range keys {
+		var want Position
+		for _, row := range tx.trades[k] {
+			want = want.Apply(row.qty, row.price, k.size())
+		}
+		if got[k].Quantity.String() != want.Quantity.String() || got[k].RealizedPnL.String() != want.RealizedPnL.String() {
+			t.Errorf("%v folded to %s / %s, want %s / %s", k,
+				got[k].Quantity, got[k].RealizedPnL, want.Quantity, want.RealizedPnL)
+		}
+	}
+	if _, ok := got[other]; ok {
+		t.Errorf("folded %v, which the correction did not touch", other)
+	}
+}
@@ -0,0 +1,83 @@
+package holdings
+
+import (
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+// costPlaces is the scale of the NUMERIC(38,16) cost columns.
+const costPlaces = 16
+
+// Position is the running state of one holding. Quantity is signed (negative
+// means short); AvgCost is the average entry price of the open quantity and
+// CostBasis its total cost, both always non-negative. Prices are per unit of
+// quantity as quoted; costs and P&L are money, so for instruments with a
+// contract size (futures, options, bonds) they are scaled by it.
+type Position struct {
+	Quantity    decimal.Decimal
+	AvgCost     decimal.Decimal
+	CostBasis   decimal.Decimal
+	RealizedPnL decimal.Decimal
+}
+
+// Apply returns the position after a trade of qty (positive buys, negative
+// sells) at price. Trades that grow the position move the average cost;
+// trades that shrink it realize (price - avg) per unit closed, with the sign
+// flipped for shorts; a trade that crosses zero closes the old side in full
+// and opens the remainder at price. A trade without a price is booked at the
+// current average cost, so it moves quantity without touching P&L. size is
+// the instrument's contract size (see domain.ContractSize).
+func (p Position) Apply(qty decimal.Decimal, price *decimal.Decimal, size decimal.Decimal) Position {
+	if qty.IsZero() {
+		return p
+	}
+	px := p.AvgCost
+	if price != nil {
+		px = *price
+	}
+
+	// Growing (or opening) the position.
+	if p.Quantity.IsZero() || p.Quantity.Sign() == qty.Sign() {
+		p.Quantity = p.Quantity.Add(qty)
+		p.CostBasis = p.CostBasis.Add(qty.Abs().Mul(px).Mul(size)).Round(costPlaces)
+		p.AvgCost = p.CostBasis.Div(p.Quantity.Abs().Mul(size), costPlaces)
+		return p
+	}
+
+	// Reducing, possibly through zero.
+	open := p.Quantity.Abs()
+	closing := qty.Abs()
+	if closing.Cmp(open) > 0 {
+		closing = open
+	}
+	pnl := closing.Mul(px.Sub(p.AvgCost)).Mul(size)
+	if p.Quantity.Sign() < 0 {
+		pnl = pnl.Neg()
+	}
+	p.RealizedPnL = p.RealizedPnL.Add(pnl).Round(costPlaces)
+	p.Quantity = p.Quantity.Add(qty)
+
+	switch remaining := p.Quantity.Abs(); {
+	case remaining.IsZero():
+		p.AvgCost, p.CostBasis = decimal.Zero, decimal.Zero
+	case p.Quantity.Sign() == qty.Sign():
+		// Flipped: the remainder is a fresh position opened at px.
+		p.AvgCost = px
+		p.CostBasis = remaining.Mul(px).Mul(size).Round(costPlaces)
+	default:
+		p.CostBasis = p.CostBasis.Sub(closing.Mul(p.AvgCost).Mul(size)).Round(costPlaces)
+	}
+	return p
+}
+
+func (p Position) holding(k Key) models.Holding {
+	return models.Holding{
+		Entity:         k.Entity,
+		InstrumentType: k.InstrumentType,
+		Symbol:         k.Symbol,
+		Quantity:       p.Quantity,
+		AvgCost:        p.AvgCost,
+		CostBasis:      p.CostBasis,
+		RealizedPnL:    p.RealizedPnL,
+	}
+}
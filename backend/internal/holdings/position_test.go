@@ -0,0 +1,79 @@
+package holdings
+
+import (
+	"testing"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+)
+
+type step struct {
+	qty, price                string // price "" books without a price
+	quantity, avg, basis, pnl string
+}
+
+func runSteps(t *testing.T, size string, steps []step) {
+	t.Helper()
+	var p Position
+	for i, s := range steps {
+		var price *decimal.Decimal
+		if s.price != "" {
+			px := decimal.MustParse(s.price)
+			price = &px
+		}
+		p = p.Apply(decimal.MustParse(s.qty), price, decimal.MustParse(size))
+		got := [4]string{p.Quantity.String(), p.AvgCost.String(), p.CostBasis.String(), p.RealizedPnL.String()}
+		want := [4]string{s.quantity, s.avg, s.basis, s.pnl}
+		if got != want {
+			t.Fatalf("step %d (%s @ %s): quantity, avg_cost, cost_basis, realized_pnl = %v, want %v", i, s.qty, s.price, got, want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  string
+		steps []step
+	}{
+		{"long", "1", []step{
+			{"10", "100", "10", "100", "1000", "0"},
+			{"10", "110", "20", "105", "2100", "0"},
+			{"-5", "120", "15", "105", "1575", "75"}, // partial reduction keeps the average
+			{"-15", "100", "0", "0", "0", "0"},       // closed flat at a loss of 75
+			{"0", "500", "0", "0", "0", "0"},         // no-op
+		}},
+		{"flip long to short and back", "1", []step{
+			{"10", "100", "10", "100", "1000", "0"},
+			{"-15", "90", "-5", "90", "450", "-100"}, // closes 10, opens 5 short at 90
+			{"2", "80", "-3", "90", "270", "-80"},    // short covered below entry gains
+			{"-1", "100", "-4", "92.5", "370", "-80"},
+			{"1", "95", "-3", "92.5", "277.5", "-82.5"},
+			{"8", "92", "5", "92", "460", "-81"}, // covers 3 at a 1.5 gain, opens 5 long
+		}},
+		{"short", "1", []step{
+			{"-4", "50", "-4", "50", "200", "0"},
+			{"-4", "60", "-8", "55", "440", "0"},
+			{"8", "40", "0", "0", "0", "120"},
+		}},
+		{"without a price", "1", []step{
+			{"3", "10", "3", "10", "30", "0"},
+			{"3", "", "6", "10", "60", "0"},  // transfer in at the average cost
+			{"-2", "", "4", "10", "40", "0"}, // and out without a gain
+			{"-4", "12", "0", "0", "0", "8"},
+			{"1", "", "1", "0", "0", "8"}, // nothing to value a priceless opening at
+		}},
+		{"contract size", "100", []step{
+			{"3", "2.5", "3", "2.5", "750", "0"},
+			{"-1", "4", "2", "2.5", "500", "150"},
+			{"-3", "1", "-1", "1", "100", "-150"},
+		}},
+		{"repeating average", "1", []step{
+			{"1", "1", "1", "1", "1", "0"},
+			{"2", "2", "3", "1.6666666666666667", "5", "0"},
+			{"-1", "2", "2", "1.6666666666666667", "3.3333333333333333", "0.3333333333333333"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { runSteps(t, tt.size, tt.steps) })
+	}
+}
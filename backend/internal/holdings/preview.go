@@ -0,0 +1,105 @@
+package holdings
+
+import (
+	"context"
+
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// Preview is what applying a set of trades would do to the holdings.
+type Preview struct {
+	// Duplicate flags the trades that would be skipped: their trade_id is
+	// already applied, or appears earlier in the set.
+	Duplicate []bool
+	Holdings  []HoldingChange
+}
+
+// HoldingChange is the state of one holding before and after a set of
+// trades, and how many of them it took.
+type HoldingChange struct {
+	Before models.Holding `json:"before"`
+	After  models.Holding `json:"after"`
+	Trades int            `json:"trades"`
+}
+
+// Preview computes what ApplyTrades would do with trades, folding them in
+// the same order, without writing anything.
+func (s *Service) Preview(ctx context.Context, trades []models.Trade) (Preview, error) {
+	out := Preview{Duplicate: make([]bool, len(trades))}
+	if len(trades) == 0 {
+		return out, nil
+	}
+	tx, err := s.DB.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return Preview{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	ids := make([]string, len(trades))
+	for i, t := range trades {
+		ids[i] = t.TradeID
+	}
+	rows, err := tx.Query(ctx, `SELECT trade_id::text FROM trades WHERE trade_id = ANY($1::uuid[])`, ids)
+	if err != nil {
+		return Preview{}, err
+	}
+	seen, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return Preview{}, err
+	}
+	applied := make(map[string]bool, len(seen))
+	for _, id := range seen {
+		applied[id] = true
+	}
+
+	byKey := make(map[Key][]models.Trade)
+	for i, t := range trades {
+		if applied[t.TradeID] {
+			out.Duplicate[i] = true
+			continue
+		}
+		applied[t.TradeID] = true
+		k := KeyOf(t)
+		byKey[k] = append(byKey[k], t)
+	}
+	keys := SortedKeys(byKey)
+	positions, err := readPositions(ctx, tx, keys)
+	if err != nil {
+		return Preview{}, err
+	}
+	for _, k := range keys {
+		before := positions[k]
+		p, size := before, k.size()
+		for _, t := range byKey[k] {
+			p = p.Apply(t.Quantity, t.Price, size)
+		}
+		out.Holdings = append(out.Holdings, HoldingChange{Before: before.holding(k), After: p.holding(k), Trades: len(byKey[k])})
+	}
+	return out, nil
+}
+
+// readPositions is lockPositions for a read-only transaction: holdings that
+// do not exist yet are returned empty.
+func readPositions(ctx context.Context, tx pgx.Tx, keys []Key) (map[Key]Position, error) {
+	batch := &pgx.Batch{}
+	for _, k := range keys {
+		batch.Queue(`
+			SELECT quantity, avg_cost, cost_basis, realized_pnl FROM holdings
+			WHERE entity = $1 AND instrument_type = $2::instrument_type AND symbol = $3
+		`, k.Entity, k.InstrumentType, k.Symbol)
+	}
+	br := tx.SendBatch(ctx, batch)
+	defer br.Close()
+
+	out := make(map[Key]Position, len(keys))
+	for _, k := range keys {
+		var p Position
+		err := br.QueryRow().Scan(&p.Quantity, &p.AvgCost, &p.CostBasis, &p.RealizedPnL)
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+		out[k] = p
+	}
+	return out, br.Close()
+}
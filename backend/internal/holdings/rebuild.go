@@ -0,0 +1,64 @@
+package holdings
+
+import (
+	"context"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/jackc/pgx/v5"
+)
+
+// Rebuild recomputes every holding from the trades ledger, replaying booked
+// (not cancelled) trades in arrival order, and returns how many holdings
+// were written. Writers block for the duration; readers keep seeing the old
+// rows until it commits.
+func (s *Service) Rebuild(ctx context.Context) (int, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `LOCK TABLE holdings IN EXCLUSIVE MODE`); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT entity::text, instrument_type::text, symbol, quantity, price
+		FROM trades WHERE event_type <> 'cancel' ORDER BY id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	positions := make(map[Key]Position)
+	for rows.Next() {
+		var k Key
+		var qty decimal.Decimal
+		var price *decimal.Decimal
+		if err := rows.Scan(&k.Entity, &k.InstrumentType, &k.Symbol, &qty, &price); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		positions[k] = positions[k].Apply(qty, price, k.size())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM holdings`); err != nil {
+		return 0, err
+	}
+	keys := SortedKeys(positions)
+	batch := &pgx.Batch{}
+	for _, k := range keys {
+		p := positions[k]
+		batch.Queue(`
+			INSERT INTO holdings (entity, instrument_type, symbol, quantity, avg_cost, cost_basis, realized_pnl)
+			VALUES ($1, $2::instrument_type, $3, $4, $5, $6, $7)
+		`, k.Entity, k.InstrumentType, k.Symbol, p.Quantity, p.AvgCost, p.CostBasis, p.RealizedPnL)
+	}
+	if err := execBatch(ctx, tx, batch, nil); err != nil {
+		return 0, err
+	}
+	return len(keys), tx.Commit(ctx)
+}
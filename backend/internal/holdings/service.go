@@ -2,99 +2,123 @@ package holdings
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"time"
 
-	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/decimal"
 	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/validation"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type Service struct{ DB *pgxpool.Pool }
+type Service struct {
+	DB *pgxpool.Pool
+	// Hooks run inside every ApplyTrades transaction, after holdings are
+	// updated, with the trades that were new.
+	Hooks []TxHook
+	// Observers are told about new trades once their transaction commits.
+	// Register them before trades start flowing.
+	Observers []Observer
+}
+
+// TxHook lets another subsystem maintain its own tables from applied trades
+// in the same transaction as holdings, so the two never disagree.
+type TxHook interface {
+	TradesApplied(ctx context.Context, tx pgx.Tx, trades []models.Trade) error
+}
+
+// ReplayHook is a TxHook whose state depends on the whole history of a
+// holding rather than only on new trades. After an amend or cancel it is
+// asked to rebuild that state for the holdings the correction touched. It
+// runs under their row locks, so it should read only their trades.
+type ReplayHook interface {
+	HoldingsReplayed(ctx context.Context, tx pgx.Tx, keys []Key) error
+}
+
+// Observer reacts to committed trades outside the transaction, e.g. to
+// invalidate caches or notify clients. It must not block.
+type Observer interface {
+	TradesCommitted(changes []Change)
+}
+
+// Change is a newly applied trade, amend or cancel and what it did to its
+// holding.
+type Change struct {
+	Trade             models.Trade
+	Holding           models.Holding // after the trade
+	QuantityChange    decimal.Decimal
+	RealizedPnLChange decimal.Decimal
+
+	// Previous is the version an amend or cancel replaced; nil for new
+	// trades.
+	Previous *models.Trade
+	// Reversed is what taking Previous out did to its holding, when an
+	// amend moved the trade to another holding.
+	Reversed *Change
+}
 
 func New(db *pgxpool.Pool) *Service { return &Service{DB: db} }
 
-func (s *Service) ApplyTrade(ctx context.Context, t models.Trade) error {
-	_, err := s.DB.Exec(ctx, `
-		WITH ins AS (
-		  INSERT INTO trades (trade_id, entity, instrument_type, symbol, quantity, price, ts)
-		  VALUES ($1, $2::entity, $3::instrument_type, $4, $5, $6, $7)
-		  ON CONFLICT (trade_id) DO NOTHING
-		  RETURNING 1
-		)
-		INSERT INTO holdings (entity, instrument_type, symbol, quantity)
-		VALUES ($2::entity, $3::instrument_type, $4, $5)
-		ON CONFLICT (entity, instrument_type, symbol)
-		DO UPDATE SET quantity = holdings.quantity + EXCLUDED.quantity,
-		              updated_at = now();
-	`, t.TradeID, t.Entity, t.InstrumentType, t.Symbol, t.Quantity, t.Price, t.TS)
-	return err
+// DecodeTrade parses, normalizes and validates a trade message as published
+// on the trades topic. New trades without a timestamp are stamped with the
+// current time; an amend without one keeps the trade's booked time. Validation
+// failures are returned as *validation.Error.
+func DecodeTrade(b []byte) (models.Trade, error) {
+	var t models.Trade
+	if err := json.Unmarshal(b, &t); err != nil {
+		return models.Trade{}, err
+	}
+	return PrepareTrade(t)
 }
 
+// PrepareTrade is DecodeTrade for a trade that is already parsed.
+func PrepareTrade(t models.Trade) (models.Trade, error) {
+	t = validation.Normalize(t)
+	if t.TS.IsZero() && t.Event == models.EventNew {
+		t.TS = time.Now().UTC()
+	}
+	if err := validation.Trade(t); err != nil {
+		return models.Trade{}, err
+	}
+	return t, nil
+}
+
+const holdingColumns = `entity::text, instrument_type::text, symbol, quantity, avg_cost, cost_basis, realized_pnl`
+
 func (s *Service) GetAll(ctx context.Context) ([]models.Holding, error) {
-	rows, err := s.DB.Query(ctx, `SELECT entity::text, instrument_type::text, symbol, quantity FROM holdings ORDER BY entity, instrument_type, symbol`)
+	rows, err := s.DB.Query(ctx, `SELECT `+holdingColumns+` FROM holdings ORDER BY entity, instrument_type, symbol`)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	out := make([]models.Holding, 0)
-	for rows.Next() {
-		var h models.Holding
-		if err := rows.Scan(&h.Entity, &h.InstrumentType, &h.Symbol, &h.Quantity); err != nil {
-			return nil, err
-		}
-		out = append(out, h)
-	}
-	return out, rows.Err()
+	return scanHoldings(rows)
 }
 
 func (s *Service) GetByEntity(ctx context.Context, entity string) ([]models.Holding, error) {
-	rows, err := s.DB.Query(ctx, `SELECT entity::text, instrument_type::text, symbol, quantity FROM holdings WHERE entity=$1::entity ORDER BY instrument_type, symbol`, entity)
+	rows, err := s.DB.Query(ctx, `SELECT `+holdingColumns+` FROM holdings WHERE entity=$1 ORDER BY instrument_type, symbol`, entity)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	out := make([]models.Holding, 0)
-	for rows.Next() {
-		var h models.Holding
-		if err := rows.Scan(&h.Entity, &h.InstrumentType, &h.Symbol, &h.Quantity); err != nil {
-			return nil, err
-		}
-		out = append(out, h)
+	out, err := scanHoldings(rows)
+	if err != nil {
+		return nil, err
 	}
 	if len(out) == 0 {
 		return nil, pgx.ErrNoRows
 	}
-	return out, rows.Err()
+	return out, nil
 }
 
-func (s *Service) GetTrades(ctx context.Context, limit uint16, entity *domain.Entity) ([]models.Trade, error) {
-	q := `SELECT trade_id::text, entity::text, instrument_type::text, symbol, quantity, price, ts FROM trades`
-	var args []any
-	fmt.Println("Entity in GetTrades:", entity)
-	if entity != nil && *entity != domain.EntityAll {
-		fmt.Println("Filtering by entity:", entity.String())
-		q += ` WHERE entity = $1::entity`
-		args = append(args, entity.String())
-	}
-	q += ` ORDER BY ts DESC LIMIT ` + fmt.Sprint(limit)
-	rows, err := s.DB.Query(ctx, q, args...)
-	if err != nil {
-		return nil, err
-	}
+func scanHoldings(rows pgx.Rows) ([]models.Holding, error) {
 	defer rows.Close()
-	out := make([]models.Trade, 0)
+	out := make([]models.Holding, 0)
 	for rows.Next() {
-		var tid, ent, itype, sym string
-		var qty float64
-		var price *float64
-		var ts time.Time
-		if err := rows.Scan(&tid, &ent, &itype, &sym, &qty, &price, &ts); err != nil {
+		var h models.Holding
+		if err := rows.Scan(&h.Entity, &h.InstrumentType, &h.Symbol, &h.Quantity, &h.AvgCost, &h.CostBasis, &h.RealizedPnL); err != nil {
 			return nil, err
 		}
-		out = append(out, models.Trade{TradeID: tid, Entity: ent, InstrumentType: itype, Symbol: sym, Quantity: qty, Price: price, TS: ts})
+		out = append(out, h)
 	}
 	return out, rows.Err()
 }
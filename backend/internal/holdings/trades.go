@@ -0,0 +1,261 @@
+package holdings
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// Trade sides.
+const (
+	SideBuy  = "buy"  // quantity > 0
+	SideSell = "sell" // quantity < 0
+)
+
+// TradeFilter narrows GetTrades. Zero fields do not filter. Quantity bounds
+// apply to the absolute quantity, so they mean the same for buys and sells;
+// From is inclusive and To exclusive. Cancelled trades are left out unless
+// IncludeCancelled is set.
+type TradeFilter struct {
+	Entity           string
+	InstrumentType   string
+	Symbol           string
+	Side             string
+	MinQuantity      *decimal.Decimal
+	MaxQuantity      *decimal.Decimal
+	MinPrice         *decimal.Decimal
+	MaxPrice         *decimal.Decimal
+	From             *time.Time
+	To               *time.Time
+	IncludeCancelled bool
+}
+
+// TradeCursor marks the last trade of a page. Pages are ordered by (ts, id)
+// descending, so a cursor stays valid however many trades arrive meanwhile.
+type TradeCursor struct {
+	TS time.Time `json:"ts"`
+	ID int64     `json:"id"`
+}
+
+var ErrBadCursor = errors.New("invalid cursor")
+
+// Encode returns the opaque form handed to API clients.
+func (c TradeCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeTradeCursor(s string) (TradeCursor, error) {
+	var c TradeCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.TS.IsZero() || c.ID <= 0 {
+		return TradeCursor{}, ErrBadCursor
+	}
+	return c, nil
+}
+
+// where accumulates SQL conditions with bound parameters.
+type where struct {
+	conds []string
+	args  []any
+}
+
+// add appends cond, in which each "?" is replaced by the placeholder of the
+// next argument.
+func (w *where) add(cond string, args ...any) {
+	for _, a := range args {
+		w.args = append(w.args, a)
+		cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(w.args)), 1)
+	}
+	w.conds = append(w.conds, cond)
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// where returns the conditions of f.
+func (f TradeFilter) where() *where {
+	w := &where{}
+	if !f.IncludeCancelled {
+		w.add(`event_type <> 'cancel'`)
+	}
+	if f.Entity != "" {
+		w.add(`entity = ?`, f.Entity)
+	}
+	if f.InstrumentType != "" {
+		w.add(`instrument_type = ?::instrument_type`, f.InstrumentType)
+	}
+	if f.Symbol != "" {
+		w.add(`symbol = ?`, f.Symbol)
+	}
+	switch f.Side {
+	case SideBuy:
+		w.add(`quantity > 0`)
+	case SideSell:
+		w.add(`quantity < 0`)
+	}
+	if f.MinQuantity != nil {
+		w.add(`abs(quantity) >= ?`, *f.MinQuantity)
+	}
+	if f.MaxQuantity != nil {
+		w.add(`abs(quantity) <= ?`, *f.MaxQuantity)
+	}
+	if f.MinPrice != nil {
+		w.add(`price >= ?`, *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		w.add(`price <= ?`, *f.MaxPrice)
+	}
+	if f.From != nil {
+		w.add(`ts >= ?`, *f.From)
+	}
+	if f.To != nil {
+		w.add(`ts < ?`, *f.To)
+	}
+	return w
+}
+
+// GetTrades returns up to limit trades matching f, newest first, starting
+// after the cursor (from the newest when nil). next is nil on the last page.
+func (s *Service) GetTrades(ctx context.Context, f TradeFilter, limit int, after *TradeCursor) (trades []models.Trade, next *TradeCursor, err error) {
+	w := f.where()
+	if after != nil {
+		w.add(`(ts, id) < (?, ?)`, after.TS, after.ID)
+	}
+	w.args = append(w.args, limit+1)
+	q := `SELECT id, ` + tradeColumns + ` FROM trades` +
+		w.String() + fmt.Sprintf(` ORDER BY ts DESC, id DESC LIMIT $%d`, len(w.args))
+
+	rows, err := s.DB.Query(ctx, q, w.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	out := make([]models.Trade, 0)
+	var last TradeCursor
+	for rows.Next() {
+		if len(out) == limit {
+			next = &last
+			break
+		}
+		var t models.Trade
+		if err := rows.Scan(append([]any{&last.ID}, tradeDest(&t)...)...); err != nil {
+			return nil, nil, err
+		}
+		last.TS = t.TS
+		out = append(out, t)
+	}
+	return out, next, rows.Err()
+}
+
+// tradeColumns are the columns of a trade version, scanned by tradeDest.
+const tradeColumns = `trade_id::text, entity::text, instrument_type::text, symbol, quantity, price, ts, event_type, version`
+
+func tradeDest(t *models.Trade) []any {
+	return []any{&t.TradeID, &t.Entity, &t.InstrumentType, &t.Symbol, &t.Quantity, &t.Price, &t.TS, &t.Event, &t.Version}
+}
+
+// GetTrade returns the current version of one trade and its processing
+// record, or pgx.ErrNoRows.
+func (s *Service) GetTrade(ctx context.Context, tradeID string) (models.Trade, models.Processing, error) {
+	var t models.Trade
+	var p models.Processing
+	err := s.DB.QueryRow(ctx, `
+		SELECT `+tradeColumns+`,
+		       COALESCE(source, ''), COALESCE(source_topic, ''), source_partition, source_offset,
+		       received_at, applied_at, duplicate_count, last_duplicate_at
+		FROM trades WHERE trade_id = $1
+	`, tradeID).Scan(append(tradeDest(&t),
+		&p.Source, &p.Topic, &p.Partition, &p.Offset,
+		&p.ReceivedAt, &p.AppliedAt, &p.DuplicateCount, &p.LastDuplicateAt)...)
+	if err != nil {
+		return models.Trade{}, models.Processing{}, err
+	}
+	p.Duplicate = p.DuplicateCount > 0
+	return t, p, nil
+}
+
+// versionsQuery selects every version of trade $1, the replaced ones from
+// trade_versions and the current one from trades. Duplicate deliveries are
+// only counted on the current version.
+const versionsQuery = `
+	SELECT ` + tradeColumns + `, COALESCE(source, ''), COALESCE(source_topic, ''), source_partition, source_offset,
+	       received_at, applied_at, 0, NULL::timestamptz
+	FROM trade_versions WHERE trade_id = $1
+	UNION ALL
+	SELECT ` + tradeColumns + `, COALESCE(source, ''), COALESCE(source_topic, ''), source_partition, source_offset,
+	       received_at, applied_at, duplicate_count, last_duplicate_at
+	FROM trades WHERE trade_id = $1
+`
+
+// TradeHistory returns every version of a trade, oldest first, or
+// pgx.ErrNoRows for an unknown trade.
+func (s *Service) TradeHistory(ctx context.Context, tradeID string) ([]models.TradeVersion, error) {
+	rows, err := s.DB.Query(ctx, `SELECT * FROM (`+versionsQuery+`) v ORDER BY version`, tradeID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := pgx.CollectRows(rows, scanTradeVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return out, nil
+}
+
+// GetTradeVersion returns one version of a trade, or pgx.ErrNoRows.
+func (s *Service) GetTradeVersion(ctx context.Context, tradeID string, version int) (models.TradeVersion, error) {
+	rows, err := s.DB.Query(ctx, `SELECT * FROM (`+versionsQuery+`) v WHERE version = $2`, tradeID, version)
+	if err != nil {
+		return models.TradeVersion{}, err
+	}
+	return pgx.CollectExactlyOneRow(rows, scanTradeVersion)
+}
+
+func scanTradeVersion(row pgx.CollectableRow) (models.TradeVersion, error) {
+	var v models.TradeVersion
+	p := &v.Processing
+	err := row.Scan(append(tradeDest(&v.Trade),
+		&p.Source, &p.Topic, &p.Partition, &p.Offset,
+		&p.ReceivedAt, &p.AppliedAt, &p.DuplicateCount, &p.LastDuplicateAt)...)
+	p.Duplicate = p.DuplicateCount > 0
+	return v, err
+}
+
+// EachTrade calls fn with every trade matching f, oldest first, as rows
+// arrive from the database, so the result set is never held in memory. It
+// stops at the first error fn returns.
+func (s *Service) EachTrade(ctx context.Context, f TradeFilter, fn func(models.Trade) error) error {
+	w := f.where()
+	q := `SELECT ` + tradeColumns + ` FROM trades` + w.String() + ` ORDER BY ts, id`
+
+	rows, err := s.DB.Query(ctx, q, w.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.Trade
+		if err := rows.Scan(tradeDest(&t)...); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	gin "github.com/gin-gonic/gin"
+)
+
+// Access configures who may call the API. Reads are public. Writes (uploads,
+// imports, price posts) and the admin routes need APIToken as a bearer
+// token; with no token they are refused. Browsers may only write from
+// CORSOrigin when it names an origin: "*" allows cross-origin reads only.
+type Access struct {
+	CORSOrigin string
+	APIToken   string
+}
+
+// cors answers preflight requests and sets the CORS headers.
+func (a Access) cors(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	h := c.Writer.Header()
+	h.Set("Vary", "Origin")
+	h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, Last-Event-ID")
+	h.Set("Access-Control-Max-Age", "86400")
+	switch {
+	case a.CORSOrigin == "*":
+		h.Set("Access-Control-Allow-Origin", "*")
+		h.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	case origin != "" && origin == a.CORSOrigin:
+		h.Set("Access-Control-Allow-Origin", a.CORSOrigin)
+		h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	}
+	if c.Request.Method == http.MethodOptions {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	// A form post needs no preflight, so a foreign page could still send
+	// one; refuse it here rather than rely on the browser.
+	if isWrite(c.Request.Method) && origin != "" && (a.CORSOrigin == "*" || origin != a.CORSOrigin) {
+		c.AbortWithStatusJSON(http.StatusForbidden, apiError{Code: "forbidden", Message: "writes are not allowed from origin " + origin})
+		return
+	}
+	c.Next()
+}
+
+// authorize admits requests carrying the API token.
+func (a Access) authorize(c *gin.Context) {
+	if a.APIToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, apiError{Code: "forbidden", Message: "writes are disabled: no API_TOKEN is configured"})
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.APIToken)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="trades-aggregator"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, apiError{Code: "unauthorized", Message: "missing or invalid bearer token"})
+		return
+	}
+	c.Next()
+}
+
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gin "github.com/gin-gonic/gin"
+)
+
+func accessRouter(a Access) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	g.Use(a.cors)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	g.GET("/api/trades", ok)
+	auth := g.Group("/", a.authorize)
+	auth.POST("/api/trades", ok)
+	auth.GET("/api/admin/dlq", ok)
+	return g
+}
+
+func TestAccess(t *testing.T) {
+	tests := []struct {
+		name          string
+		access        Access
+		method, path  string
+		origin, token string
+		status        int
+	}{
+		{"public read", Access{CORSOrigin: "*", APIToken: "t0k"}, "GET", "/api/trades", "https://evil.example", "", 200},
+		{"write with token", Access{CORSOrigin: "*", APIToken: "t0k"}, "POST", "/api/trades", "", "t0k", 200},
+		{"write without token", Access{CORSOrigin: "*", APIToken: "t0k"}, "POST", "/api/trades", "", "", 401},
+		{"write with a wrong token", Access{CORSOrigin: "*", APIToken: "t0k"}, "POST", "/api/trades", "", "t0", 401},
+		{"admin read without token", Access{CORSOrigin: "*", APIToken: "t0k"}, "GET", "/api/admin/dlq", "", "", 401},
+		{"admin read with token", Access{CORSOrigin: "*", APIToken: "t0k"}, "GET", "/api/admin/dlq", "", "t0k", 200},
+		{"writes disabled without a configured token", Access{CORSOrigin: "*"}, "POST", "/api/trades", "", "", 403},
+		{"empty token never matches", Access{CORSOrigin: "*"}, "POST", "/api/trades", "", " ", 403},
+		{"browser write with a wildcard origin", Access{CORSOrigin: "*", APIToken: "t0k"}, "POST", "/api/trades", "https://evil.example", "t0k", 403},
+		{"browser write from another origin", Access{CORSOrigin: "https://app.example", APIToken: "t0k"}, "POST", "/api/trades", "https://evil.example", "t0k", 403},
+		{"browser write from the configured origin", Access{CORSOrigin: "https://app.example", APIToken: "t0k"}, "POST", "/api/trades", "https://app.example", "t0k", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			accessRouter(tt.access).ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d (%s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		corsOrigin, origin string
+		allowOrigin        string
+		allowMethods       string
+	}{
+		{"*", "https://any.example", "*", "GET, OPTIONS"},
+		{"https://app.example", "https://app.example", "https://app.example", "GET, POST, PUT, DELETE, OPTIONS"},
+		{"https://app.example", "https://evil.example", "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodOptions, "/api/trades", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		accessRouter(Access{CORSOrigin: tt.corsOrigin, APIToken: "t0k"}).ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s from %s: status %d", tt.corsOrigin, tt.origin, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+			t.Errorf("%s from %s: Allow-Origin %q, want %q", tt.corsOrigin, tt.origin, got, tt.allowOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.allowMethods {
+			t.Errorf("%s from %s: Allow-Methods %q, want %q", tt.corsOrigin, tt.origin, got, tt.allowMethods)
+		}
+	}
+}
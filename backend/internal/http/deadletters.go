@@ -0,0 +1,52 @@
+package http
+
+import (
+	"net/http"
+
+	gin "github.com/gin-gonic/gin"
+
+	"github.com/example/trades-aggregator/internal/deadletter"
+)
+
+type redriveRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+type redriveResponse struct {
+	Results []deadletter.RedriveResult `json:"results"`
+}
+
+// listDeadLetters returns pending DLQ entries, newest first.
+// ?status=all also includes entries that were already re-driven.
+func (s *Server) listDeadLetters(c *gin.Context) {
+	limit := parseLimit(c.Query("limit"), 100, 1, 1000)
+	includeRedriven := c.Query("status") == "all"
+
+	rows, err := s.DeadLetters.List(c.Request.Context(), limit, includeRedriven)
+	if err != nil {
+		s.internalError(c, "ListDeadLetters", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rows": rows})
+}
+
+// redriveDeadLetters feeds the selected entries back through ApplyTrade and
+// reports the outcome per entry.
+func (s *Server) redriveDeadLetters(c *gin.Context) {
+	var req redriveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.badRequest(c, "body must be {\"ids\": [<dead letter id>, ...]}")
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > 1000 {
+		s.badRequest(c, "ids must contain between 1 and 1000 entries")
+		return
+	}
+
+	results, err := s.DeadLetters.Redrive(c.Request.Context(), req.IDs)
+	if err != nil {
+		s.internalError(c, "RedriveDeadLetters", err)
+		return
+	}
+	c.JSON(http.StatusOK, redriveResponse{Results: results})
+}
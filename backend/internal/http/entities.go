@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/http"
+
+	gin "github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/example/trades-aggregator/internal/entities"
+)
+
+type entityRequest struct {
+	Name         string `json:"name"`
+	Timezone     string `json:"timezone"`
+	BaseCurrency string `json:"base_currency"`
+	Active       *bool  `json:"active"`
+}
+
+// getEntities lists the registered entities, inactive ones included.
+func (s *Server) getEntities(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"entities": s.Entities.All()})
+}
+
+// putEntity registers an entity or updates one. Trades for it are accepted
+// as soon as it is active; other replicas pick it up on their next reload.
+func (s *Server) putEntity(c *gin.Context) {
+	var req entityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.badRequest(c, `body must be {"name": ..., "timezone": ..., "base_currency": ..., "active": true|false}`)
+		return
+	}
+	e := entities.Entity{Code: c.Param("code"), Name: req.Name, Timezone: req.Timezone, BaseCurrency: req.BaseCurrency, Active: true}
+	if req.Active != nil {
+		e.Active = *req.Active
+	}
+	if err := entities.Check(&e); err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	e, created, err := s.Entities.Put(c.Request.Context(), e)
+	if err != nil {
+		s.internalError(c, "PutEntity", err)
+		return
+	}
+	s.Logger.Info("entity_saved", zap.String("code", e.Code), zap.Bool("active", e.Active), zap.Bool("created", created))
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, e)
+}
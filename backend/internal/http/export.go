@@ -0,0 +1,217 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/export"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+// negotiateFormat picks the export format: ?format= when given, otherwise
+// the most preferred type of the Accept header that we can produce, and
+// CSV when the client accepts anything.
+func negotiateFormat(c *gin.Context) (export.Format, error) {
+	if raw := strings.ToLower(strings.TrimSpace(c.Query("format"))); raw != "" {
+		f, ok := export.ParseFormat(raw)
+		if !ok {
+			return "", errors.New("invalid format (use 'csv', 'ndjson' or 'parquet')")
+		}
+		return f, nil
+	}
+	accept := strings.TrimSpace(c.GetHeader("Accept"))
+	if accept == "" {
+		return export.CSV, nil
+	}
+	type choice struct {
+		mediaType string
+		q         float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{mt, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, ch := range choices {
+		if f, ok := export.FormatOf(ch.mediaType); ok {
+			return f, nil
+		}
+		if ch.mediaType == "*/*" || ch.mediaType == "text/*" {
+			return export.CSV, nil
+		}
+	}
+	return "", errNotAcceptable
+}
+
+var errNotAcceptable = errors.New("no acceptable format (accept text/csv, application/x-ndjson or application/vnd.apache.parquet)")
+
+// exportFormat negotiates the format, answering the request itself when
+// that fails.
+func (s *Server) exportFormat(c *gin.Context) (export.Format, bool) {
+	f, err := negotiateFormat(c)
+	if errors.Is(err, errNotAcceptable) {
+		c.JSON(http.StatusNotAcceptable, apiError{Code: "not_acceptable", Message: err.Error()})
+		return "", false
+	}
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return "", false
+	}
+	return f, true
+}
+
+// startExport sets the headers of a download named name.
+func startExport(c *gin.Context, f export.Format, name string) {
+	h := c.Writer.Header()
+	h.Set("Content-Type", f.ContentType())
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, f))
+	h.Set("Cache-Control", "no-store")
+	h.Add("Vary", "Accept")
+}
+
+// failExport reports an export that failed. Before anything was sent it is
+// a plain 500; afterwards the status is gone, and the truncated download
+// (a Parquet file without its footer, a CSV without its last rows) is all
+// the client gets, so the error is logged for the operator.
+func (s *Server) failExport(c *gin.Context, where string, rows int, err error) {
+	if !c.Writer.Written() {
+		h := c.Writer.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Disposition")
+		s.internalError(c, where, err)
+		return
+	}
+	s.Logger.Error("export_failed", zap.String("where", where), zap.Int("rows", rows), zap.Error(err))
+}
+
+// exportTrades streams every trade matching the filters of GET /api/trades
+// (less the cursor), oldest first, as CSV, NDJSON or Parquet. There is no
+// row limit: rows are encoded as the database returns them.
+func (s *Server) exportTrades(c *gin.Context) {
+	q, err := parseTradesQuery(c)
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	if q.after != nil {
+		s.badRequest(c, "exports include every matching trade and take no cursor")
+		return
+	}
+	f, ok := s.exportFormat(c)
+	if !ok {
+		return
+	}
+
+	// A large export outlives the server's write timeout.
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.internalError(c, "SetWriteDeadline", err)
+		return
+	}
+
+	startExport(c, f, "trades")
+	w := export.Trades(c.Writer, f)
+	rows := 0
+	err = s.HoldingsService.EachTrade(c.Request.Context(), q.filter, func(t models.Trade) error {
+		rows++
+		return w.Write(t)
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		s.failExport(c, "ExportTrades", rows, err)
+		return
+	}
+	s.Logger.Info("export_done", zap.String("what", "trades"), zap.String("format", string(f)), zap.Int("rows", rows))
+}
+
+// exportHoldings exports the holdings of ?entity= (all when absent), as
+// they are now and marked to market, or as of ?as_of= from the ledger.
+func (s *Server) exportHoldings(c *gin.Context) {
+	entity := ""
+	if raw := strings.TrimSpace(c.Query("entity")); raw != "" {
+		ent, ok := domain.ParseEntity(raw)
+		if !ok {
+			s.badRequest(c, msgUnknownEntity)
+			return
+		}
+		if ent != domain.EntityAll {
+			entity = ent.String()
+		}
+	}
+	var asOf *time.Time
+	if raw := strings.TrimSpace(c.Query("as_of")); raw != "" {
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			s.badRequest(c, "invalid as_of (use an RFC3339 timestamp, e.g. 2024-05-01T17:30:00Z)")
+			return
+		}
+		asOf = &t
+	}
+	f, ok := s.exportFormat(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var rows []models.Holding
+	var err error
+	switch {
+	case asOf != nil:
+		rows, err = s.HoldingsService.AsOf(ctx, *asOf, entity)
+	case entity != "":
+		rows, err = s.HoldingsService.GetByEntity(ctx, entity)
+		if holdings.IsNotFound(err) {
+			rows, err = nil, nil
+		}
+	default:
+		rows, err = s.HoldingsService.GetAll(ctx)
+	}
+	if err != nil {
+		s.internalError(c, "ExportHoldings", err)
+		return
+	}
+	if asOf == nil {
+		rows = s.Prices.Enrich(rows)
+	}
+
+	name := "holdings"
+	if asOf != nil {
+		name += "-" + asOf.UTC().Format("20060102T150405Z")
+	}
+	startExport(c, f, name)
+	w := export.Holdings(c.Writer, f)
+	for i, h := range rows {
+		if err := w.Write(h); err != nil {
+			s.failExport(c, "ExportHoldings", i, err)
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		s.failExport(c, "ExportHoldings", len(rows), err)
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,18 +11,36 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/example/trades-aggregator/internal/cache"
+	"github.com/example/trades-aggregator/internal/deadletter"
 	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/entities"
 	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/instruments"
+	"github.com/example/trades-aggregator/internal/lots"
 	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/prices"
+	"github.com/example/trades-aggregator/internal/redis"
+	"github.com/example/trades-aggregator/internal/snapshots"
+	"github.com/example/trades-aggregator/internal/stream"
 )
 
 type Server struct {
 	R               *gin.Engine
 	HoldingsService *holdings.Service
-	HoldingsCache   *cache.MapCache[cache.HoldingsKey, []models.Holding]
-	TradesCache     *cache.MapCache[cache.TradesKey, []models.Trade]
+	DeadLetters     *deadletter.Service
+	Entities        *entities.Service
+	Instruments     *instruments.Service
+	Lots            *lots.Service
+	Prices          *prices.Service
+	Snapshots       *snapshots.Service
+	Stream          *stream.Hub
+	HoldingsCache   cache.Cache[cache.HoldingsKey, []models.Holding]
+	TradesCache     cache.Cache[cache.TradesKey, tradesResponse]
+	CacheGeneration *cache.Generation  // nil: the caches are per replica
+	Invalidations   *cache.Broadcaster // nil: single replica
 	Logger          *zap.Logger
 	TTL             time.Duration
+	CORSOrigin      string
 }
 
 type apiError struct {
@@ -31,10 +50,36 @@ type apiError struct {
 
 type tradesResponse struct {
 	Rows []models.Trade `json:"rows"`
+	// NextCursor fetches the following page via ?cursor=; absent on the last.
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
-// NewServer wires the router, service, caches, and middleware.
-func NewServer(holdingsService *holdings.Service, logger *zap.Logger, corsOrigin string) *Server {
+// Services are the domain services the HTTP layer exposes.
+type Services struct {
+	Holdings    *holdings.Service
+	DeadLetters *deadletter.Service
+	Entities    *entities.Service
+	Instruments *instruments.Service
+	Lots        *lots.Service
+	Prices      *prices.Service
+	Snapshots   *snapshots.Service
+	Stream      *stream.Hub
+}
+
+// CacheOptions configures the server's response caches. With Redis set
+// the caches are shared through it; otherwise each replica keeps its own
+// in-memory LRU of MaxEntries. Invalidations, when set, tells the other
+// replicas about committed trades.
+type CacheOptions struct {
+	TTL           time.Duration
+	MaxEntries    int
+	Redis         *redis.Client
+	RedisPrefix   string
+	Invalidations *cache.Broadcaster
+}
+
+// NewServer wires the router, services, caches, and middleware.
+func NewServer(services Services, logger *zap.Logger, access Access, opts CacheOptions) *Server {
 	g := gin.New()
 
 	// Request logging
@@ -53,46 +98,84 @@ func NewServer(holdingsService *holdings.Service, logger *zap.Logger, corsOrigin
 	g.Use(gin.Recovery())
 
 	// CORS
-	g.Use(func(cn *gin.Context) {
-		origin := cn.GetHeader("Origin")
-		cn.Writer.Header().Set("Vary", "Origin")
-		cn.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		cn.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		cn.Writer.Header().Set("Access-Control-Max-Age", "86400")
-		if corsOrigin == "*" {
-			cn.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		} else if origin != "" && origin == corsOrigin {
-			cn.Writer.Header().Set("Access-Control-Allow-Origin", corsOrigin)
-		}
-		if cn.Request.Method == http.MethodOptions {
-			cn.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-		cn.Next()
-	})
-
-	// Typed caches (no TTLs)
-	hc := cache.NewMapCache[cache.HoldingsKey, []models.Holding]()
-	tc := cache.NewMapCache[cache.TradesKey, []models.Trade]()
+	g.Use(access.cors)
+
+	// Typed caches; entries expire after opts.TTL and are invalidated as
+	// trades commit (see TradesCommitted)
+	var hc cache.Cache[cache.HoldingsKey, []models.Holding]
+	var tc cache.Cache[cache.TradesKey, tradesResponse]
+	var gen *cache.Generation
+	if opts.Redis != nil {
+		gen = cache.NewGeneration(opts.Redis, opts.RedisPrefix+":gen", logger)
+		hc = cache.NewRedis[cache.HoldingsKey, []models.Holding](opts.Redis, gen, opts.RedisPrefix+":holdings", opts.TTL, logger)
+		tc = cache.NewRedis[cache.TradesKey, tradesResponse](opts.Redis, gen, opts.RedisPrefix+":trades", opts.TTL, logger)
+	} else {
+		hc = cache.NewLRU[cache.HoldingsKey, []models.Holding](opts.MaxEntries, opts.TTL)
+		tc = cache.NewLRU[cache.TradesKey, tradesResponse](opts.MaxEntries, opts.TTL)
+	}
 
 	s := &Server{
 		R:               g,
-		HoldingsService: holdingsService,
+		HoldingsService: services.Holdings,
+		DeadLetters:     services.DeadLetters,
+		Entities:        services.Entities,
+		Instruments:     services.Instruments,
+		Lots:            services.Lots,
+		Prices:          services.Prices,
+		Snapshots:       services.Snapshots,
+		Stream:          services.Stream,
 		HoldingsCache:   hc,
 		TradesCache:     tc,
+		CacheGeneration: gen,
+		Invalidations:   opts.Invalidations,
 		Logger:          logger,
+		TTL:             opts.TTL,
+		CORSOrigin:      access.CORSOrigin,
 	}
 
 	g.GET("/health", func(cn *gin.Context) { cn.JSON(http.StatusOK, gin.H{"ok": true}) })
 	g.GET("/api/holdings", s.getAllHoldings)
 	g.GET("/api/holdings/:entity", s.getEntityHoldings)
 	g.GET("/api/trades", s.getTrades)
+	g.GET("/api/trades/:trade_id", s.getTrade)
+	g.GET("/api/trades/:trade_id/history", s.getTradeHistory)
+	g.GET("/api/stream", s.getStream)
+	g.GET("/api/ws", s.getWebSocket)
+	g.GET("/api/export/trades", s.exportTrades)
+	g.GET("/api/export/holdings", s.exportHoldings)
+
+	g.GET("/api/entities", s.getEntities)
+	g.GET("/api/instruments", s.getInstruments)
+	g.GET("/api/instruments/:instrument_type/:symbol", s.getInstrument)
+	g.GET("/api/lots/:entity/:symbol", s.getLots)
+	g.GET("/api/prices", s.getPrices)
+	g.GET("/api/snapshots", s.listSnapshots)
+	g.GET("/api/snapshots/:entity/diff", s.diffSnapshots)
+	g.GET("/api/snapshots/:entity/:date", s.getSnapshot)
+
+	// Writes and the admin API need the API token
+	auth := g.Group("/", access.authorize)
+	auth.POST("/api/trades", s.postTrades)
+	auth.POST("/api/trades/import", s.postTradesImport)
+	auth.POST("/api/prices", s.postPrices)
+
+	auth.GET("/api/admin/dlq", s.listDeadLetters)
+	auth.POST("/api/admin/dlq/redrive", s.redriveDeadLetters)
+	auth.GET("/api/admin/cache", s.getCacheStats)
+	auth.GET("/api/admin/lot-methods", s.getLotMethods)
+	auth.PUT("/api/admin/lot-methods/:entity", s.putLotMethod)
+	auth.PUT("/api/admin/entities/:code", s.putEntity)
+	auth.PUT("/api/admin/instruments/:instrument_type/:symbol", s.putInstrument)
+	auth.DELETE("/api/admin/instruments/:instrument_type/:symbol", s.deleteInstrument)
+	auth.POST("/api/admin/instruments/bulk", s.postInstrumentsBulk)
 
 	return s
 }
 
 // --- Helpers ---
 
+const msgUnknownEntity = "unknown entity (see GET /api/entities)"
+
 func (s *Server) badRequest(c *gin.Context, msg string) {
 	c.JSON(http.StatusBadRequest, apiError{Code: "bad_request", Message: msg})
 }
@@ -116,25 +199,22 @@ func parseLimit(v string, def, min, max int) int {
 // --- Handlers ---
 
 func (s *Server) getAllHoldings(c *gin.Context) {
-	// Use the enum value for "all"
-	key := cache.HoldingsKey{Entity: domain.EntityAll}
-
-	if rows, ok := s.HoldingsCache.Get(key); ok && rows != nil {
-		c.JSON(http.StatusOK, rows)
+	if c.Query("as_of") != "" {
+		s.getHoldingsAsOf(c, "")
 		return
 	}
 
-	rows, err := s.HoldingsService.GetAll(c.Request.Context())
+	// Use the enum value for "all"
+	key := cache.HoldingsKey{Entity: domain.EntityAll}
+
+	rows, err := s.HoldingsCache.GetOrLoad(c.Request.Context(), key, func(ctx context.Context) ([]models.Holding, error) {
+		return s.HoldingsService.GetAll(ctx)
+	})
 	if err != nil {
 		s.internalError(c, "GetAll", err)
 		return
 	}
-	if rows == nil {
-		rows = []models.Holding{}
-	}
-
-	s.HoldingsCache.Set(key, rows)
-	c.JSON(http.StatusOK, rows)
+	c.JSON(http.StatusOK, s.Prices.Enrich(rows))
 }
 
 func (s *Server) getEntityHoldings(c *gin.Context) {
@@ -142,69 +222,79 @@ func (s *Server) getEntityHoldings(c *gin.Context) {
 	entRaw := strings.TrimSpace(c.Param("entity"))
 	ent, ok := domain.ParseEntity(entRaw)
 	if !ok {
-		s.badRequest(c, "invalid entity (use 'zurich' or 'new_york')")
+		s.badRequest(c, msgUnknownEntity)
 		return
 	}
-
-	key := cache.HoldingsKey{Entity: ent}
-	if rows, ok := s.HoldingsCache.Get(key); ok && rows != nil {
-		c.JSON(http.StatusOK, rows)
+	if c.Query("as_of") != "" {
+		entity := ent.String()
+		if ent == domain.EntityAll {
+			entity = ""
+		}
+		s.getHoldingsAsOf(c, entity)
 		return
 	}
 
-	rows, err := s.HoldingsService.GetByEntity(c.Request.Context(), ent.String())
-	if err != nil {
+	key := cache.HoldingsKey{Entity: ent}
+	rows, err := s.HoldingsCache.GetOrLoad(c.Request.Context(), key, func(ctx context.Context) ([]models.Holding, error) {
+		rows, err := s.HoldingsService.GetByEntity(ctx, ent.String())
 		if holdings.IsNotFound(err) {
-			rows = []models.Holding{}
-			s.HoldingsCache.Set(key, rows)
-			c.JSON(http.StatusOK, rows)
-			return
+			return []models.Holding{}, nil
 		}
+		return rows, err
+	})
+	if err != nil {
 		s.internalError(c, "GetByEntity", err)
 		return
 	}
-	if rows == nil {
-		rows = []models.Holding{}
-	}
-
-	s.HoldingsCache.Set(key, rows)
-	c.JSON(http.StatusOK, rows)
+	c.JSON(http.StatusOK, s.Prices.Enrich(rows))
 }
 
 func (s *Server) getTrades(c *gin.Context) {
-	limitInt := parseLimit(c.Query("limit"), 100, 1, 1000)
-	limit := uint16(limitInt) // cache.TradesKey.Limit is uint16
-
-	entity := domain.EntityAll // default "all" means no filter
-
-	if raw := strings.TrimSpace(c.Query("entity")); raw != "" {
-		if ent, ok := domain.ParseEntity(raw); ok {
-			if ent != domain.EntityAll {
-				entity = ent
-			}
-		} else {
-			s.badRequest(c, "invalid entity (use 'zurich' or 'new_york')")
-			return
-		}
+	limit := parseLimit(c.Query("limit"), 100, 1, 1000)
+	q, err := parseTradesQuery(c)
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
 	}
 
 	// Cache key uses the enum string ("all" if none)
-	tkey := cache.TradesKey{Entity: entity, Limit: limit}
-	if rows, ok := s.TradesCache.Get(tkey); ok && rows != nil {
-		c.JSON(http.StatusOK, tradesResponse{Rows: rows})
+	entity := domain.EntityAll
+	if q.filter.Entity != "" {
+		entity = domain.Entity(q.filter.Entity)
+	}
+	tkey := cache.Trades(entity, limit, q.canonical)
+	page, err := s.TradesCache.GetOrLoad(c.Request.Context(), tkey, func(ctx context.Context) (tradesResponse, error) {
+		s.Logger.Info("cache_miss", zap.String("entity", entity.String()), zap.Int("limit", limit), zap.String("query", q.canonical))
+		rows, next, err := s.HoldingsService.GetTrades(ctx, q.filter, limit, q.after)
+		if err != nil {
+			return tradesResponse{}, err
+		}
+		page := tradesResponse{Rows: rows}
+		if next != nil {
+			page.NextCursor = next.Encode()
+		}
+		return page, nil
+	})
+	if err != nil {
+		s.internalError(c, "GetTrades", err)
 		return
 	}
-	s.Logger.Info("cache_miss", zap.String("entity", entity.String()), zap.Uint16("limit", limit))
+	c.JSON(http.StatusOK, page)
+}
 
-	rows, err := s.HoldingsService.GetTrades(c.Request.Context(), limit, &entity)
+// getHoldingsAsOf answers ?as_of=<RFC3339> from the trades ledger. Results
+// are neither cached nor marked to market: current prices say nothing about
+// a past position's value.
+func (s *Server) getHoldingsAsOf(c *gin.Context, entity string) {
+	asOf, err := time.Parse(time.RFC3339Nano, c.Query("as_of"))
 	if err != nil {
-		s.internalError(c, "GetTrades", err)
+		s.badRequest(c, "invalid as_of (use an RFC3339 timestamp, e.g. 2024-05-01T17:30:00Z)")
 		return
 	}
-	if rows == nil {
-		rows = make([]models.Trade, 0)
+	rows, err := s.HoldingsService.AsOf(c.Request.Context(), asOf, entity)
+	if err != nil {
+		s.internalError(c, "HoldingsAsOf", err)
+		return
 	}
-
-	s.TradesCache.Set(tkey, rows)
-	c.JSON(http.StatusOK, tradesResponse{Rows: rows})
+	c.JSON(http.StatusOK, rows)
 }
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	gin "github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/example/trades-aggregator/internal/csvimport"
+	"github.com/example/trades-aggregator/internal/db"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+const (
+	maxImportBody = 32 << 20
+	maxImportRows = 100000
+)
+
+// postTradesImport loads trades from a CSV file, sent either as the raw
+// body or as the "file" field of a multipart form. Query parameters:
+//
+//	map=field=Header,...   column mapping (see csvimport.ParseMapping)
+//	delimiter=;            field delimiter (default ",")
+//	time_format=LAYOUT     Go layout of the ts column
+//	key=...                namespaces trade_ids derived from row content
+//	dry_run=true           validate and preview holdings, apply nothing
+//	format=csv             answer with the per-row report as CSV
+func (s *Server) postTradesImport(c *gin.Context) {
+	opts := csvimport.Options{TimeFormat: c.Query("time_format"), Key: c.Query("key")}
+	var err error
+	if opts.Mapping, err = csvimport.ParseMapping(c.Query("map")); err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	if d := c.Query("delimiter"); d != "" {
+		r, size := utf8.DecodeRuneInString(d)
+		if size != len(d) || r == '"' || r == '\r' || r == '\n' {
+			s.badRequest(c, "delimiter must be a single character")
+			return
+		}
+		opts.Comma = r
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	data, err := readUpload(c)
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	rows, err := csvimport.Parse(data, opts)
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	if len(rows) > maxImportRows {
+		s.badRequest(c, fmt.Sprintf("at most %d rows per import", maxImportRows))
+		return
+	}
+
+	rep, err := csvimport.Import(c.Request.Context(), s.HoldingsService, rows, models.SourceCSV, dryRun)
+	if err != nil {
+		if db.IsTransient(err) {
+			s.Logger.Warn("import_unavailable", zap.Error(err))
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, apiError{Code: "unavailable", Message: "database unavailable, retry later"})
+			return
+		}
+		s.internalError(c, "ImportTrades", err)
+		return
+	}
+	s.Logger.Info("trades_imported", zap.Bool("dry_run", dryRun), zap.Int("rows", rep.Total),
+		zap.Int("accepted", rep.Accepted), zap.Int("duplicates", rep.Duplicates), zap.Int("rejected", rep.Rejected))
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := rep.WriteCSV(c.Writer); err != nil {
+			s.Logger.Warn("import_report_write_failed", zap.Error(err))
+		}
+		return
+	}
+	c.JSON(http.StatusOK, rep)
+}
+
+// readUpload returns the uploaded file: the "file" part of a multipart
+// form, or else the request body.
+func readUpload(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBody)
+	if c.ContentType() == "multipart/form-data" {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("multipart upload needs a \"file\" field (at most 32 MiB): %v", err)
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read body (at most 32 MiB)")
+	}
+	return data, nil
+}
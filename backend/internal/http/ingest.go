@@ -0,0 +1,159 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/example/trades-aggregator/internal/db"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/ingest"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/validation"
+)
+
+const (
+	// maxTradeUpload bounds how many trades one POST /api/trades may carry.
+	maxTradeUpload = 1000
+	maxTradeBody   = 8 << 20
+	maxIdemKeyLen  = 255
+)
+
+type ingestResult struct {
+	Index   int                     `json:"index"`
+	TradeID string                  `json:"trade_id,omitempty"`
+	Status  string                  `json:"status"`
+	Errors  []validation.FieldError `json:"errors,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+type ingestResponse struct {
+	Accepted   int            `json:"accepted"`
+	Duplicates int            `json:"duplicates"`
+	Rejected   int            `json:"rejected"`
+	Results    []ingestResult `json:"results"`
+}
+
+// postTrades ingests trades for systems that cannot publish to Kafka: one
+// trade object or an array of them, in the same shape as the topic messages.
+// Trades go through the same validation as the consumer and are applied by
+// ingest.Apply, so they are booked, streamed and invalidate caches the same
+// way. Amends and cancels of earlier trades (event_type with the version
+// they create) are accepted as well.
+//
+// Retries are safe: a trade_id is only ever applied once. Trades without a
+// trade_id get one derived from the Idempotency-Key header (and their
+// position in the array), so resending the same request with the same key
+// reports them as duplicates instead of booking them twice.
+//
+// The response lists an outcome per trade, in request order. It is 200
+// unless every trade was rejected (422); a transient database failure
+// answers 503 and the whole request should be retried.
+func (s *Server) postTrades(c *gin.Context) {
+	idemKey := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+	if len(idemKey) > maxIdemKeyLen {
+		s.badRequest(c, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdemKeyLen))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxTradeBody))
+	if err != nil {
+		s.badRequest(c, "could not read body (at most 8 MiB)")
+		return
+	}
+	raws, err := splitTrades(body)
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	if len(raws) == 0 || len(raws) > maxTradeUpload {
+		s.badRequest(c, fmt.Sprintf("body must contain between 1 and %d trades", maxTradeUpload))
+		return
+	}
+
+	results := make([]ingestResult, len(raws))
+	trades := make([]models.Trade, 0, len(raws))
+	index := make([]int, 0, len(raws)) // trades[j] is results[index[j]]
+	received := time.Now().UTC()
+	for i, raw := range raws {
+		results[i] = ingestResult{Index: i}
+		var t models.Trade
+		if err := json.Unmarshal(raw, &t); err != nil {
+			results[i].Status, results[i].Error = ingest.Rejected, err.Error()
+			continue
+		}
+		if strings.TrimSpace(t.TradeID) == "" && idemKey != "" {
+			t.TradeID = ingest.DeriveTradeID(idemKey, i)
+		}
+		t, err := holdings.PrepareTrade(t)
+		if ve, ok := validation.AsError(err); ok {
+			results[i].Status, results[i].Errors = ingest.Rejected, ve.Fields
+			continue
+		}
+		if err != nil {
+			results[i].Status, results[i].Error = ingest.Rejected, err.Error()
+			continue
+		}
+		t.Origin = &models.Origin{Source: models.SourceHTTP, ReceivedAt: received}
+		results[i].TradeID = t.TradeID
+		trades = append(trades, t)
+		index = append(index, i)
+	}
+
+	outcomes, err := ingest.Apply(c.Request.Context(), s.HoldingsService, trades)
+	if err != nil {
+		if db.IsTransient(err) {
+			s.Logger.Warn("ingest_unavailable", zap.Error(err))
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, apiError{Code: "unavailable", Message: "database unavailable, retry later"})
+			return
+		}
+		s.internalError(c, "IngestTrades", err)
+		return
+	}
+	for j, o := range outcomes {
+		results[index[j]].Status, results[index[j]].Error = o.Status, o.Error
+	}
+
+	resp := ingestResponse{Results: results}
+	for _, r := range results {
+		switch r.Status {
+		case ingest.Accepted:
+			resp.Accepted++
+		case ingest.Duplicate:
+			resp.Duplicates++
+		default:
+			resp.Rejected++
+		}
+	}
+	status := http.StatusOK
+	if resp.Rejected == len(results) {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, resp)
+}
+
+// splitTrades returns the raw trades of a body holding one trade object or
+// an array of them.
+func splitTrades(body []byte) ([]json.RawMessage, error) {
+	const shape = `body must be a trade object or an array of them`
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, fmt.Errorf("%s: %v", shape, err)
+		}
+		return raws, nil
+	}
+	if len(body) == 0 || body[0] != '{' {
+		return nil, errors.New(shape)
+	}
+	return []json.RawMessage{body}, nil
+}
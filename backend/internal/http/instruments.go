@@ -0,0 +1,251 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/example/trades-aggregator/internal/deadletter"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/instruments"
+)
+
+const (
+	msgInvalidInstrumentType = "invalid instrument_type (use 'stock', 'crypto', 'fx', 'future', 'option' or 'bond')"
+	msgUnknownInstrument     = "unknown instrument (see GET /api/instruments)"
+
+	maxInstrumentsPerLoad = 10000
+)
+
+// getInstruments lists the instrument master, optionally one
+// ?instrument_type= only, the instrument with ?isin=, or (?tradable=true)
+// those that trade today.
+func (s *Server) getInstruments(c *gin.Context) {
+	it := ""
+	if raw := strings.TrimSpace(c.Query("instrument_type")); raw != "" {
+		t, ok := domain.ParseInstrumentType(raw)
+		if !ok {
+			s.badRequest(c, msgInvalidInstrumentType)
+			return
+		}
+		it = t.String()
+	}
+	tradable := false
+	if raw := strings.TrimSpace(c.Query("tradable")); raw != "" {
+		var err error
+		if tradable, err = strconv.ParseBool(raw); err != nil {
+			s.badRequest(c, "invalid tradable (use true or false)")
+			return
+		}
+	}
+	isin := strings.ToUpper(strings.TrimSpace(c.Query("isin")))
+
+	now := time.Now()
+	out := make([]instruments.Instrument, 0)
+	for _, i := range s.Instruments.All(it) {
+		if (isin == "" || i.ISIN == isin) && (!tradable || i.TradableOn(now)) {
+			out = append(out, i)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"instruments": out})
+}
+
+// getInstrument returns one instrument of the master.
+func (s *Server) getInstrument(c *gin.Context) {
+	t, ok := domain.ParseInstrumentType(c.Param("instrument_type"))
+	if !ok {
+		s.badRequest(c, msgInvalidInstrumentType)
+		return
+	}
+	i, ok := s.Instruments.Get(t.String(), c.Param("symbol"))
+	if !ok {
+		c.JSON(http.StatusNotFound, apiError{Code: "not_found", Message: msgUnknownInstrument})
+		return
+	}
+	c.JSON(http.StatusOK, i)
+}
+
+// putInstrument registers an instrument or replaces its terms. The body
+// holds the attributes of its type, e.g. for a future
+// {"underlying": "SPX", "multiplier": "50", "expiry": "2026-12-18", "currency": "USD"}.
+func (s *Server) putInstrument(c *gin.Context) {
+	var i instruments.Instrument
+	if err := c.ShouldBindJSON(&i); err != nil {
+		s.badRequest(c, "body must be a JSON object of instrument attributes")
+		return
+	}
+	i.InstrumentType, i.Symbol = c.Param("instrument_type"), c.Param("symbol")
+	if err := instruments.Check(&i); err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	i, created, err := s.Instruments.Put(c.Request.Context(), i)
+	if s.instrumentConflict(c, err) {
+		return
+	}
+	if err != nil {
+		s.internalError(c, "PutInstrument", err)
+		return
+	}
+	s.Logger.Info("instrument_saved", zap.String("instrument_type", i.InstrumentType), zap.String("symbol", i.Symbol),
+		zap.Bool("created", created))
+	s.releaseQuarantined(c, func(instrumentType, symbol string) bool {
+		return instrumentType == i.InstrumentType && symbol == i.Symbol
+	})
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, i)
+}
+
+// deleteInstrument removes an instrument that was entered by mistake.
+// Traded instruments stay; end them with active_to instead.
+func (s *Server) deleteInstrument(c *gin.Context) {
+	t, ok := domain.ParseInstrumentType(c.Param("instrument_type"))
+	if !ok {
+		s.badRequest(c, msgInvalidInstrumentType)
+		return
+	}
+	symbol := c.Param("symbol")
+	err := s.Instruments.Delete(c.Request.Context(), t.String(), symbol)
+	switch {
+	case errors.Is(err, instruments.ErrNotFound):
+		c.JSON(http.StatusNotFound, apiError{Code: "not_found", Message: msgUnknownInstrument})
+		return
+	case errors.Is(err, instruments.ErrInUse):
+		c.JSON(http.StatusConflict, apiError{Code: "conflict", Message: err.Error()})
+		return
+	case err != nil:
+		s.internalError(c, "DeleteInstrument", err)
+		return
+	}
+	s.Logger.Info("instrument_deleted", zap.String("instrument_type", t.String()), zap.String("symbol", symbol))
+	c.Status(http.StatusNoContent)
+}
+
+type bulkResult struct {
+	InstrumentType string `json:"instrument_type"`
+	Symbol         string `json:"symbol"`
+	Created        bool   `json:"created"`
+}
+
+// postInstrumentsBulk loads many instruments at once: a CSV file (header
+// row of attribute names, identifiers.<scheme> for other identifiers) or a
+// JSON array of instruments. Loads are all or nothing: if any instrument is
+// rejected the response lists why, with 422, and none is saved.
+// ?dry_run=true only checks them.
+func (s *Server) postInstrumentsBulk(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	data, err := readUpload(c)
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+
+	var is []instruments.Instrument
+	var bad []instruments.RowError
+	if c.ContentType() == "application/json" {
+		if err := json.Unmarshal(data, &is); err != nil {
+			s.badRequest(c, "body must be a JSON array of instruments")
+			return
+		}
+	} else if is, bad, err = instruments.ParseCSV(bytes.NewReader(data)); err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	if len(is) == 0 {
+		s.badRequest(c, "no instruments to load")
+		return
+	}
+	if len(is) > maxInstrumentsPerLoad {
+		s.badRequest(c, fmt.Sprintf("at most %d instruments per load", maxInstrumentsPerLoad))
+		return
+	}
+	// Rows that could not be read are reported on their own; the rest are
+	// checked once they all can be.
+	if len(bad) == 0 {
+		bad = instruments.Prepare(is)
+	}
+	if len(bad) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"total": len(is), "errors": bad})
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{"dry_run": true, "total": len(is)})
+		return
+	}
+
+	saved, created, err := s.Instruments.PutAll(c.Request.Context(), is)
+	if s.instrumentConflict(c, err) {
+		return
+	}
+	if err != nil {
+		s.internalError(c, "PutInstruments", err)
+		return
+	}
+	results := make([]bulkResult, len(saved))
+	loaded := make(map[[2]string]bool, len(saved))
+	nCreated := 0
+	for n, i := range saved {
+		results[n] = bulkResult{InstrumentType: i.InstrumentType, Symbol: i.Symbol, Created: created[n]}
+		loaded[[2]string{i.InstrumentType, i.Symbol}] = true
+		if created[n] {
+			nCreated++
+		}
+	}
+	s.Logger.Info("instruments_loaded", zap.Int("total", len(saved)), zap.Int("created", nCreated))
+	s.releaseQuarantined(c, func(instrumentType, symbol string) bool {
+		return loaded[[2]string{instrumentType, symbol}]
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"total":       len(saved),
+		"created":     nCreated,
+		"updated":     len(saved) - nCreated,
+		"instruments": results,
+	})
+}
+
+// instrumentConflict answers a save that conflicts with the trades or
+// other instruments on file, and reports whether it did.
+func (s *Server) instrumentConflict(c *gin.Context, err error) bool {
+	if errors.Is(err, instruments.ErrContractSizeInUse) || errors.Is(err, instruments.ErrDuplicateISIN) {
+		c.JSON(http.StatusConflict, apiError{Code: "conflict", Message: err.Error()})
+		return true
+	}
+	return false
+}
+
+// releaseQuarantined re-drives the quarantined trades for the instruments
+// match accepts, now that the master lists them. The save already happened,
+// so failures are logged rather than answered; the entries stay in the DLQ.
+func (s *Server) releaseQuarantined(c *gin.Context, match func(instrumentType, symbol string) bool) {
+	if s.DeadLetters == nil {
+		return
+	}
+	res, err := s.DeadLetters.RedriveQuarantined(c.Request.Context(), match)
+	if err != nil {
+		s.Logger.Warn("quarantine_redrive_failed", zap.Error(err))
+	}
+	if len(res) == 0 {
+		return
+	}
+	applied, failed := 0, 0
+	for _, r := range res {
+		switch r.Status {
+		case deadletter.RedriveFailed:
+			failed++
+		case deadletter.RedriveApplied:
+			applied++
+		}
+	}
+	s.Logger.Info("quarantine_redriven", zap.Int("entries", len(res)), zap.Int("applied", applied), zap.Int("failed", failed))
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	gin "github.com/gin-gonic/gin"
+
+	"github.com/example/trades-aggregator/internal/cache"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"go.uber.org/zap"
+)
+
+// TradesCommitted implements holdings.Observer: it drops the cached holdings
+// and trades of every entity the trades touched, plus the "all" aggregates,
+// and tells the other replicas to do the same. Shared caches are retired
+// with one generation bump for the whole batch.
+func (s *Server) TradesCommitted(changes []holdings.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	seen := make(map[string]bool)
+	entities := make([]string, 0, 2)
+	add := func(e string) {
+		if !seen[e] {
+			seen[e] = true
+			entities = append(entities, e)
+		}
+	}
+	for _, ch := range changes {
+		add(ch.Trade.Entity)
+		if ch.Previous != nil {
+			add(ch.Previous.Entity)
+		}
+	}
+	s.InvalidateEntities(entities)
+	if s.CacheGeneration != nil {
+		s.CacheGeneration.Bump()
+	}
+	if s.Invalidations != nil {
+		go s.Invalidations.Publish(context.Background(), entities)
+	}
+}
+
+// InvalidateEntities drops this replica's cached views that include any of
+// entities. It is also how invalidations from other replicas are applied.
+func (s *Server) InvalidateEntities(entities []string) {
+	affected := map[domain.Entity]bool{domain.EntityAll: true}
+	for _, e := range entities {
+		affected[domain.Entity(e)] = true
+	}
+	for e := range affected {
+		s.HoldingsCache.Delete(cache.HoldingsByEntity(e))
+	}
+	s.TradesCache.DeleteFunc(func(k cache.TradesKey) bool { return affected[k.Entity] })
+	s.Logger.Debug("cache_invalidated", zap.Strings("entities", entities))
+}
+
+// ClearCaches drops every cached view of this replica.
+func (s *Server) ClearCaches() {
+	s.HoldingsCache.Clear()
+	s.TradesCache.Clear()
+}
+
+// getCacheStats reports hit/miss/eviction counters of the response caches.
+func (s *Server) getCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"holdings": s.HoldingsCache.Stats(), "trades": s.TradesCache.Stats()})
+}
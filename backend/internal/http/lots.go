@@ -0,0 +1,88 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	gin "github.com/gin-gonic/gin"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/lots"
+)
+
+type lotsResponse struct {
+	Entity   string             `json:"entity"`
+	Symbol   string             `json:"symbol"`
+	Open     []lots.Lot         `json:"open"`
+	Realized []lots.Realization `json:"realized"`
+}
+
+type lotMethodRequest struct {
+	Method string `json:"method"`
+}
+
+// parseTradingEntity accepts a concrete entity; "all" is not allowed.
+func parseTradingEntity(raw string) (domain.Entity, bool) {
+	ent, ok := domain.ParseEntity(raw)
+	if !ok || ent == domain.EntityAll {
+		return "", false
+	}
+	return ent, true
+}
+
+// getLots lists the open lots and most recent realizations of one symbol.
+func (s *Server) getLots(c *gin.Context) {
+	ent, ok := parseTradingEntity(c.Param("entity"))
+	if !ok {
+		s.badRequest(c, msgUnknownEntity)
+		return
+	}
+	symbol := strings.TrimSpace(c.Param("symbol"))
+	limit := parseLimit(c.Query("limit"), 100, 1, 1000)
+
+	open, err := s.Lots.OpenLots(c.Request.Context(), ent.String(), symbol)
+	if err != nil {
+		s.internalError(c, "OpenLots", err)
+		return
+	}
+	realized, err := s.Lots.Realizations(c.Request.Context(), ent.String(), symbol, limit)
+	if err != nil {
+		s.internalError(c, "Realizations", err)
+		return
+	}
+	c.JSON(http.StatusOK, lotsResponse{Entity: ent.String(), Symbol: symbol, Open: open, Realized: realized})
+}
+
+func (s *Server) getLotMethods(c *gin.Context) {
+	methods, err := s.Lots.Methods(c.Request.Context())
+	if err != nil {
+		s.internalError(c, "LotMethods", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"default": lots.DefaultMethod, "entities": methods})
+}
+
+// putLotMethod changes an entity's relief method; its lots are rebuilt from
+// the trades ledger before the call returns.
+func (s *Server) putLotMethod(c *gin.Context) {
+	ent, ok := parseTradingEntity(c.Param("entity"))
+	if !ok {
+		s.badRequest(c, msgUnknownEntity)
+		return
+	}
+	var req lotMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.badRequest(c, "body must be {\"method\": \"fifo\"|\"lifo\"|\"hifo\"}")
+		return
+	}
+	m, err := lots.ParseMethod(strings.ToLower(strings.TrimSpace(req.Method)))
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	if err := s.Lots.SetMethod(c.Request.Context(), ent.String(), m); err != nil {
+		s.internalError(c, "SetLotMethod", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entity": ent, "method": m})
+}
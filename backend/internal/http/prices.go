@@ -0,0 +1,72 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	gin "github.com/gin-gonic/gin"
+
+	"github.com/example/trades-aggregator/internal/prices"
+)
+
+// maxPriceUpload bounds how many quotes one upload may carry.
+const maxPriceUpload = 10000
+
+// getPrices lists the last known price of every symbol.
+func (s *Server) getPrices(c *gin.Context) {
+	rows := s.Prices.All()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Symbol < rows[j].Symbol })
+	c.JSON(http.StatusOK, gin.H{"rows": rows})
+}
+
+// postPrices uploads prices by hand: either one quote object or an array of
+// them. Quotes without "ts" are stamped with the upload time.
+func (s *Server) postPrices(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		s.badRequest(c, "could not read body")
+		return
+	}
+	quotes, err := decodeQuotes(body)
+	if err != nil {
+		s.badRequest(c, err.Error())
+		return
+	}
+	if len(quotes) == 0 || len(quotes) > maxPriceUpload {
+		s.badRequest(c, fmt.Sprintf("body must contain between 1 and %d quotes", maxPriceUpload))
+		return
+	}
+	for i := range quotes {
+		if err := prices.Check(&quotes[i]); err != nil {
+			s.badRequest(c, fmt.Sprintf("quote %d: %v", i, err))
+			return
+		}
+	}
+
+	if err := s.Prices.Upsert(c.Request.Context(), prices.SourceManual, quotes); err != nil {
+		s.internalError(c, "UpsertPrices", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stored": len(quotes)})
+}
+
+func decodeQuotes(body []byte) ([]prices.Quote, error) {
+	const shape = `body must be {"symbol": ..., "price": ..., "ts": ...} or an array of them`
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var qs []prices.Quote
+		if err := json.Unmarshal(body, &qs); err != nil {
+			return nil, fmt.Errorf("%s: %v", shape, err)
+		}
+		return qs, nil
+	}
+	var q prices.Quote
+	if err := json.Unmarshal(body, &q); err != nil {
+		return nil, fmt.Errorf("%s: %v", shape, err)
+	}
+	return []prices.Quote{q}, nil
+}
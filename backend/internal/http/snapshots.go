@@ -0,0 +1,107 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/snapshots"
+)
+
+type snapshotDiffResponse struct {
+	Entity  string             `json:"entity"`
+	From    string             `json:"from"`
+	To      string             `json:"to"`
+	Changes []snapshots.Change `json:"changes"`
+}
+
+// validDate accepts an empty string or a YYYY-MM-DD business date.
+func validDate(s string) bool {
+	if s == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+// listSnapshots lists end-of-day snapshots, newest first.
+// ?entity= restricts to one entity; ?from=/?to= bound the business date.
+func (s *Server) listSnapshots(c *gin.Context) {
+	ent, ok := domain.ParseEntity(c.Query("entity"))
+	if !ok {
+		s.badRequest(c, msgUnknownEntity)
+		return
+	}
+	entity := ent.String()
+	if ent == domain.EntityAll {
+		entity = ""
+	}
+	from, to := strings.TrimSpace(c.Query("from")), strings.TrimSpace(c.Query("to"))
+	if !validDate(from) || !validDate(to) {
+		s.badRequest(c, "from and to must be dates (YYYY-MM-DD)")
+		return
+	}
+	limit := parseLimit(c.Query("limit"), 100, 1, 1000)
+
+	rows, err := s.Snapshots.List(c.Request.Context(), entity, from, to, limit)
+	if err != nil {
+		s.internalError(c, "ListSnapshots", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rows": rows})
+}
+
+// getSnapshot returns one entity's book for a business date.
+func (s *Server) getSnapshot(c *gin.Context) {
+	ent, ok := parseTradingEntity(c.Param("entity"))
+	if !ok {
+		s.badRequest(c, msgUnknownEntity)
+		return
+	}
+	date := c.Param("date")
+	if date == "" || !validDate(date) {
+		s.badRequest(c, "date must be YYYY-MM-DD")
+		return
+	}
+
+	d, err := s.Snapshots.Get(c.Request.Context(), ent.String(), date)
+	if holdings.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, apiError{Code: "not_found", Message: "no snapshot for " + ent.String() + " on " + date})
+		return
+	}
+	if err != nil {
+		s.internalError(c, "GetSnapshot", err)
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// diffSnapshots compares an entity's books for ?from= and ?to= business
+// dates, listing only the holdings that changed.
+func (s *Server) diffSnapshots(c *gin.Context) {
+	ent, ok := parseTradingEntity(c.Param("entity"))
+	if !ok {
+		s.badRequest(c, msgUnknownEntity)
+		return
+	}
+	from, to := strings.TrimSpace(c.Query("from")), strings.TrimSpace(c.Query("to"))
+	if from == "" || to == "" || !validDate(from) || !validDate(to) {
+		s.badRequest(c, "from and to are required dates (YYYY-MM-DD)")
+		return
+	}
+
+	changes, err := s.Snapshots.Diff(c.Request.Context(), ent.String(), from, to)
+	if holdings.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, apiError{Code: "not_found", Message: "both snapshots must exist"})
+		return
+	}
+	if err != nil {
+		s.internalError(c, "DiffSnapshots", err)
+		return
+	}
+	c.JSON(http.StatusOK, snapshotDiffResponse{Entity: ent.String(), From: from, To: to, Changes: changes})
+}
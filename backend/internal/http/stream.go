@@ -0,0 +1,95 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/stream"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment line, so that
+// proxies keep the connection open and dead clients are noticed.
+const streamHeartbeat = 15 * time.Second
+
+// getStream serves GET /api/stream as Server-Sent Events: a "trade" event
+// for every applied trade followed by a "holding" event with the resulting
+// position and its change. Optional entity and symbol query parameters
+// filter both. A reconnecting client sends Last-Event-ID (or ?last_event_id=)
+// to receive what it missed; when that is no longer possible a "reset" event
+// tells it to reload holdings and trades before relying on the stream.
+func (s *Server) getStream(c *gin.Context) {
+	var entity string
+	if raw := strings.TrimSpace(c.Query("entity")); raw != "" {
+		ent, ok := domain.ParseEntity(raw)
+		if !ok {
+			s.badRequest(c, msgUnknownEntity)
+			return
+		}
+		if ent != domain.EntityAll {
+			entity = ent.String()
+		}
+	}
+	symbol := strings.TrimSpace(c.Query("symbol"))
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.internalError(c, "SetWriteDeadline", err)
+		return
+	}
+
+	sub, backlog, resumed := s.Stream.Subscribe(stream.Filter(entity, symbol, stream.TypeTrade, stream.TypeHolding), lastID)
+	defer s.Stream.Unsubscribe(sub)
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+	if !resumed {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range backlog {
+		writeEvent(w, e)
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.Done():
+			fmt.Fprintf(w, "event: close\ndata: {\"reason\":%q}\n\n", sub.Reason())
+			w.Flush()
+			s.Logger.Info("stream_closed", zap.String("reason", sub.Reason()), zap.String("ip", c.ClientIP()))
+			return
+		case e := <-sub.C:
+			writeEvent(w, e)
+			w.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			w.Flush()
+		}
+	}
+}
+
+// writeEvent writes e in SSE framing; Data is single-line JSON.
+func writeEvent(w gin.ResponseWriter, e stream.Event) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/validation"
+)
+
+// tradesQuery is a parsed GET /api/trades request. canonical identifies the
+// filters (other than entity) and cursor in cache keys.
+type tradesQuery struct {
+	filter    holdings.TradeFilter
+	after     *holdings.TradeCursor
+	canonical string
+}
+
+// parseTradesQuery reads the filters of GET /api/trades:
+//
+//	entity, instrument_type, symbol, side (buy|sell),
+//	min_quantity, max_quantity (absolute), min_price, max_price,
+//	from (inclusive), to (exclusive) as RFC3339, include_cancelled, cursor
+func parseTradesQuery(c *gin.Context) (tradesQuery, error) {
+	var q tradesQuery
+	canon := url.Values{}
+
+	if raw := strings.TrimSpace(c.Query("entity")); raw != "" {
+		ent, ok := domain.ParseEntity(raw)
+		if !ok {
+			return q, errors.New(msgUnknownEntity)
+		}
+		if ent != domain.EntityAll {
+			q.filter.Entity = ent.String()
+		}
+	}
+	if raw := strings.TrimSpace(c.Query("instrument_type")); raw != "" {
+		it, ok := domain.ParseInstrumentType(raw)
+		if !ok {
+			return q, errors.New(msgInvalidInstrumentType)
+		}
+		q.filter.InstrumentType = it.String()
+		canon.Set("instrument_type", q.filter.InstrumentType)
+	}
+	if raw := strings.TrimSpace(c.Query("symbol")); raw != "" {
+		q.filter.Symbol = raw
+		canon.Set("symbol", q.filter.Symbol)
+	}
+	switch side := strings.ToLower(strings.TrimSpace(c.Query("side"))); side {
+	case "":
+	case holdings.SideBuy, holdings.SideSell:
+		q.filter.Side = side
+		canon.Set("side", side)
+	default:
+		return q, errors.New("invalid side (use 'buy' or 'sell')")
+	}
+
+	for _, d := range []struct {
+		name string
+		dst  **decimal.Decimal
+	}{
+		{"min_quantity", &q.filter.MinQuantity},
+		{"max_quantity", &q.filter.MaxQuantity},
+		{"min_price", &q.filter.MinPrice},
+		{"max_price", &q.filter.MaxPrice},
+	} {
+		raw := strings.TrimSpace(c.Query(d.name))
+		if raw == "" {
+			continue
+		}
+		v, err := decimal.Parse(raw)
+		if err != nil || v.Sign() < 0 {
+			return q, fmt.Errorf("invalid %s (use a non-negative number)", d.name)
+		}
+		*d.dst = &v
+		canon.Set(d.name, v.String())
+	}
+
+	for _, t := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"from", &q.filter.From},
+		{"to", &q.filter.To},
+	} {
+		raw := strings.TrimSpace(c.Query(t.name))
+		if raw == "" {
+			continue
+		}
+		v, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s (use an RFC3339 timestamp)", t.name)
+		}
+		*t.dst = &v
+		canon.Set(t.name, v.UTC().Format(time.RFC3339Nano))
+	}
+
+	if raw := strings.TrimSpace(c.Query("include_cancelled")); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, errors.New("invalid include_cancelled (use 'true' or 'false')")
+		}
+		q.filter.IncludeCancelled = v
+		if v {
+			canon.Set("include_cancelled", "true")
+		}
+	}
+
+	if raw := strings.TrimSpace(c.Query("cursor")); raw != "" {
+		cur, err := holdings.DecodeTradeCursor(raw)
+		if err != nil {
+			return q, err
+		}
+		q.after = &cur
+		canon.Set("cursor", raw)
+	}
+
+	q.canonical = canon.Encode()
+	return q, nil
+}
+
+type tradeResponse struct {
+	Trade      models.Trade      `json:"trade"`
+	Processing models.Processing `json:"processing"`
+}
+
+// getTrade looks one trade up by trade_id, with how and when it was
+// received, applied and whether it was delivered more than once. It answers
+// with the current version, or the one asked for with ?version=.
+func (s *Server) getTrade(c *gin.Context) {
+	id := strings.ToLower(strings.TrimSpace(c.Param("trade_id")))
+	if !validation.IsUUID(id) {
+		s.badRequest(c, "trade_id must be a UUID")
+		return
+	}
+	if raw := strings.TrimSpace(c.Query("version")); raw != "" {
+		version, err := strconv.Atoi(raw)
+		if err != nil || version < 1 {
+			s.badRequest(c, "invalid version (use a positive integer)")
+			return
+		}
+		v, err := s.HoldingsService.GetTradeVersion(c.Request.Context(), id, version)
+		if holdings.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, apiError{Code: "not_found", Message: fmt.Sprintf("no version %d of trade %s", version, id)})
+			return
+		}
+		if err != nil {
+			s.internalError(c, "GetTradeVersion", err)
+			return
+		}
+		c.JSON(http.StatusOK, tradeResponse{Trade: v.Trade, Processing: v.Processing})
+		return
+	}
+	t, p, err := s.HoldingsService.GetTrade(c.Request.Context(), id)
+	if holdings.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, apiError{Code: "not_found", Message: "no trade " + id})
+		return
+	}
+	if err != nil {
+		s.internalError(c, "GetTrade", err)
+		return
+	}
+	c.JSON(http.StatusOK, tradeResponse{Trade: t, Processing: p})
+}
+
+type tradeHistoryResponse struct {
+	TradeID  string                `json:"trade_id"`
+	Versions []models.TradeVersion `json:"versions"`
+}
+
+// getTradeHistory lists every version of a trade, oldest first: the one it
+// was booked with, each amend, and the cancel if any.
+func (s *Server) getTradeHistory(c *gin.Context) {
+	id := strings.ToLower(strings.TrimSpace(c.Param("trade_id")))
+	if !validation.IsUUID(id) {
+		s.badRequest(c, "trade_id must be a UUID")
+		return
+	}
+	versions, err := s.HoldingsService.TradeHistory(c.Request.Context(), id)
+	if holdings.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, apiError{Code: "not_found", Message: "no trade " + id})
+		return
+	}
+	if err != nil {
+		s.internalError(c, "TradeHistory", err)
+		return
+	}
+	c.JSON(http.StatusOK, tradeHistoryResponse{TradeID: id, Versions: versions})
+}
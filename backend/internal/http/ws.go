@@ -0,0 +1,413 @@
+package http
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/stream"
+)
+
+const (
+	wsWriteTimeout    = 10 * time.Second
+	wsMaxMessage      = 64 << 10
+	wsMaxChannels     = 64
+	wsTradesSnapshot  = 100 // latest trades sent on subscribe
+	wsAlertsSnapshot  = 50  // latest alerts sent on subscribe
+	wsSnapshotTimeout = 10 * time.Second
+	wsMaxPending      = 1000 // updates held back per channel while its snapshot loads
+)
+
+// errWSOverflow closes a connection whose updates outgrew wsMaxPending; the
+// client is told why first.
+var errWSOverflow = errors.New("too many updates while loading snapshot")
+
+// WebSocket operations sent by clients.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsPing        = "ping"
+)
+
+type wsRequest struct {
+	Op      string `json:"op"`
+	Channel string `json:"channel"`
+}
+
+// wsMessage is everything the server sends. Type is "subscribed" (Data is
+// the channel snapshot), "unsubscribed", "trade", "holding", "alert",
+// "heartbeat", "pong", "error" or "closed" (Reason says why).
+type wsMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Data    any    `json:"data,omitempty"`
+	Message string `json:"message,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// wsChannel is a parsed channel name:
+//
+//	trades[:entity[:symbol]]
+//	holdings[:entity[:symbol]]
+//	alerts
+//
+// name is the canonical form, which replies and events carry: the entity in
+// lower case, and "all" only where a symbol follows ("trades:All" is
+// "trades", "trades::AAPL" is "trades:all:AAPL").
+type wsChannel struct {
+	name      string
+	eventType string
+	entity    string // empty: every entity
+	symbol    string // empty: every symbol
+
+	// Events that arrive while the snapshot is loading are held back until
+	// it has been sent. Only the writer loop touches these.
+	ready   bool
+	pending []stream.Event
+}
+
+func parseChannel(name string) (*wsChannel, error) {
+	parts := strings.Split(strings.TrimSpace(name), ":")
+	ch := &wsChannel{name: parts[0]}
+	switch parts[0] {
+	case "trades":
+		ch.eventType = stream.TypeTrade
+	case "holdings":
+		ch.eventType = stream.TypeHolding
+	case "alerts":
+		if len(parts) > 1 {
+			return nil, errors.New("the alerts channel takes no entity or symbol")
+		}
+		ch.eventType = stream.TypeAlert
+		return ch, nil
+	default:
+		return nil, fmt.Errorf("unknown channel %q (use 'trades[:entity[:symbol]]', 'holdings[:entity[:symbol]]' or 'alerts')", name)
+	}
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid channel %q", name)
+	}
+	if len(parts) > 1 {
+		ent, ok := domain.ParseEntity(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown entity %q in channel (see GET /api/entities)", parts[1])
+		}
+		if ent != domain.EntityAll {
+			ch.entity = ent.String()
+		}
+	}
+	if len(parts) > 2 {
+		if ch.symbol = parts[2]; ch.symbol == "" {
+			return nil, fmt.Errorf("invalid channel %q: empty symbol", name)
+		}
+	}
+	switch {
+	case ch.symbol != "":
+		ch.name += ":" + cmp.Or(ch.entity, string(domain.EntityAll)) + ":" + ch.symbol
+	case ch.entity != "":
+		ch.name += ":" + ch.entity
+	}
+	return ch, nil
+}
+
+func (ch *wsChannel) match(e stream.Event) bool {
+	return e.Type == ch.eventType &&
+		(ch.entity == "" || e.Entity == ch.entity) &&
+		(ch.symbol == "" || e.Symbol == ch.symbol)
+}
+
+// wsClient is the state of one WebSocket connection. The reader goroutine
+// handles requests and loads snapshots; everything is written by the
+// connection's writer loop so messages never interleave.
+//
+// The subscribed channels are replaced on every change, never modified, so
+// the hub matches events against them without locking and a slow socket
+// write never holds up publishing; mu only serializes the replacements.
+type wsClient struct {
+	s    *Server
+	conn *websocket.Conn
+	out  chan wsMessage // replies from the reader to the writer
+
+	mu       sync.Mutex
+	channels atomic.Pointer[map[string]*wsChannel]
+}
+
+func newWSClient(s *Server, conn *websocket.Conn) *wsClient {
+	cl := &wsClient{s: s, conn: conn, out: make(chan wsMessage, 16)}
+	channels := make(map[string]*wsChannel)
+	cl.channels.Store(&channels)
+	return cl
+}
+
+// subscribed is the current channel set; it must not be modified.
+func (cl *wsClient) subscribed() map[string]*wsChannel { return *cl.channels.Load() }
+
+// update replaces the channel set with a copy changed by edit.
+func (cl *wsClient) update(edit func(channels map[string]*wsChannel)) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	next := maps.Clone(cl.subscribed())
+	edit(next)
+	cl.channels.Store(&next)
+}
+
+// getWebSocket serves GET /api/ws. Clients send
+//
+//	{"op": "subscribe", "channel": "holdings:new_york:BTC"}
+//	{"op": "unsubscribe", "channel": "holdings:new_york:BTC"}
+//	{"op": "ping"}
+//
+// and receive a "subscribed" message carrying the channel's current state
+// (latest trades, current holdings or recent alerts), then one message per
+// matching event. Updates can overlap the snapshot; holding updates carry the
+// whole position and trades their trade_id, so re-applying one is harmless.
+// A heartbeat is sent every 15 seconds, and a client that cannot keep up is
+// sent "closed" with reason "slow_consumer" (or, while a snapshot loads,
+// "too many updates while loading snapshot") and disconnected. Replies and
+// updates name the channel in its canonical form (see wsChannel).
+func (s *Server) getWebSocket(c *gin.Context) {
+	srv := websocket.Server{
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			// Browsers always send Origin; other tools may omit it.
+			if origin := r.Header.Get("Origin"); s.CORSOrigin != "*" && origin != "" && origin != s.CORSOrigin {
+				return fmt.Errorf("origin %q not allowed", origin)
+			}
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			conn.MaxPayloadBytes = wsMaxMessage
+			// The hijacked connection keeps the server's read/write timeouts.
+			_ = conn.SetDeadline(time.Time{})
+			cl := newWSClient(s, conn)
+			cl.run(c.Request.Context(), c.ClientIP())
+		},
+	}
+	srv.ServeHTTP(c.Writer, c.Request)
+}
+
+func (cl *wsClient) run(ctx context.Context, ip string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer cl.conn.Close()
+
+	sub, _, _ := cl.s.Stream.Subscribe(cl.match, "")
+	defer cl.s.Stream.Unsubscribe(sub)
+	go func() {
+		defer cancel()
+		cl.read(ctx)
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Done():
+			_ = cl.write(wsMessage{Type: "closed", Reason: sub.Reason()})
+			cl.s.Logger.Info("ws_closed", zap.String("reason", sub.Reason()), zap.String("ip", ip))
+			return
+		case m := <-cl.out:
+			err = cl.reply(m)
+		case e := <-sub.C:
+			err = cl.deliver(e)
+		case <-heartbeat.C:
+			err = cl.write(wsMessage{Type: "heartbeat"})
+		}
+		if errors.Is(err, errWSOverflow) {
+			cl.s.Logger.Info("ws_closed", zap.String("reason", err.Error()), zap.String("ip", ip))
+			return
+		}
+		if err != nil {
+			cl.s.Logger.Debug("ws_write_failed", zap.String("ip", ip), zap.Error(err))
+			return
+		}
+	}
+}
+
+// read handles client requests until the connection fails or ctx ends.
+func (cl *wsClient) read(ctx context.Context) {
+	for {
+		var req wsRequest
+		if err := websocket.JSON.Receive(cl.conn, &req); err != nil {
+			return
+		}
+		var m wsMessage
+		switch req.Op {
+		case wsSubscribe:
+			m = cl.subscribe(ctx, req.Channel)
+		case wsUnsubscribe:
+			m = cl.unsubscribe(req.Channel)
+		case wsPing:
+			m = wsMessage{Type: "pong"}
+		default:
+			m = wsMessage{Type: "error", Message: fmt.Sprintf("unknown op %q (use 'subscribe', 'unsubscribe' or 'ping')", req.Op)}
+		}
+		select {
+		case cl.out <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (cl *wsClient) subscribe(ctx context.Context, name string) wsMessage {
+	ch, err := parseChannel(name)
+	if err != nil {
+		return wsMessage{Type: "error", Channel: name, Message: err.Error()}
+	}
+	var dup, full bool
+	cl.update(func(channels map[string]*wsChannel) {
+		_, dup = channels[ch.name]
+		full = len(channels) >= wsMaxChannels
+		if !dup && !full {
+			channels[ch.name] = ch // start collecting updates before the snapshot
+		}
+	})
+	switch {
+	case dup:
+		return wsMessage{Type: "error", Channel: ch.name, Message: "already subscribed"}
+	case full:
+		return wsMessage{Type: "error", Channel: ch.name, Message: fmt.Sprintf("at most %d channels per connection", wsMaxChannels)}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wsSnapshotTimeout)
+	defer cancel()
+	snap, err := cl.snapshot(ctx, ch)
+	if err != nil {
+		cl.update(func(channels map[string]*wsChannel) { delete(channels, ch.name) })
+		cl.s.Logger.Error("internal_error", zap.String("where", "WebSocketSnapshot"), zap.Error(err))
+		return wsMessage{Type: "error", Channel: ch.name, Message: "could not load snapshot"}
+	}
+	return wsMessage{Type: "subscribed", Channel: ch.name, Data: snap}
+}
+
+func (cl *wsClient) unsubscribe(name string) wsMessage {
+	ch, err := parseChannel(name)
+	if err != nil {
+		return wsMessage{Type: "error", Channel: name, Message: err.Error()}
+	}
+	var ok bool
+	cl.update(func(channels map[string]*wsChannel) {
+		_, ok = channels[ch.name]
+		delete(channels, ch.name)
+	})
+	if !ok {
+		return wsMessage{Type: "error", Channel: ch.name, Message: "not subscribed"}
+	}
+	return wsMessage{Type: "unsubscribed", Channel: ch.name}
+}
+
+func (cl *wsClient) snapshot(ctx context.Context, ch *wsChannel) (any, error) {
+	switch ch.eventType {
+	case stream.TypeTrade:
+		rows, _, err := cl.s.HoldingsService.GetTrades(ctx, holdings.TradeFilter{Entity: ch.entity, Symbol: ch.symbol}, wsTradesSnapshot, nil)
+		return rows, err
+	case stream.TypeHolding:
+		var rows []models.Holding
+		var err error
+		if ch.entity == "" {
+			rows, err = cl.s.HoldingsService.GetAll(ctx)
+		} else {
+			rows, err = cl.s.HoldingsService.GetByEntity(ctx, ch.entity)
+		}
+		if err != nil && !holdings.IsNotFound(err) {
+			return nil, err
+		}
+		out := make([]models.Holding, 0, len(rows))
+		for _, h := range rows {
+			if ch.symbol == "" || h.Symbol == ch.symbol {
+				out = append(out, h)
+			}
+		}
+		return cl.s.Prices.Enrich(out), nil
+	default:
+		recent := cl.s.Stream.Recent(ch.match, wsAlertsSnapshot)
+		out := make([]any, len(recent))
+		for i, e := range recent {
+			out[i] = e.Data
+		}
+		return out, nil
+	}
+}
+
+// match is the hub filter of the connection: any subscribed channel.
+func (cl *wsClient) match(e stream.Event) bool {
+	for _, ch := range cl.subscribed() {
+		if ch.match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver sends e on every subscribed channel it matches, or holds it back
+// for channels whose snapshot has not been sent yet.
+func (cl *wsClient) deliver(e stream.Event) error {
+	for _, ch := range cl.subscribed() {
+		if !ch.match(e) {
+			continue
+		}
+		if !ch.ready {
+			if len(ch.pending) >= wsMaxPending {
+				_ = cl.write(wsMessage{Type: "closed", Reason: errWSOverflow.Error()})
+				return errWSOverflow
+			}
+			ch.pending = append(ch.pending, e)
+			continue
+		}
+		if err := cl.write(eventMessage(ch.name, e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reply sends a reply of the reader; after a snapshot it releases the
+// updates held back meanwhile.
+func (cl *wsClient) reply(m wsMessage) error {
+	if m.Type != "subscribed" {
+		return cl.write(m)
+	}
+	ch, ok := cl.subscribed()[m.Channel]
+	if !ok {
+		return nil // unsubscribed while loading
+	}
+	if err := cl.write(m); err != nil {
+		return err
+	}
+	ch.ready = true
+	for _, e := range ch.pending {
+		if err := cl.write(eventMessage(ch.name, e)); err != nil {
+			return err
+		}
+	}
+	ch.pending = nil
+	return nil
+}
+
+func (cl *wsClient) write(m wsMessage) error {
+	if err := cl.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return err
+	}
+	return websocket.JSON.Send(cl.conn, m)
+}
+
+func eventMessage(channel string, e stream.Event) wsMessage {
+	return wsMessage{Type: e.Type, Channel: channel, ID: e.ID, Data: e.Data}
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/example/trades-aggregator/internal/stream"
+)
+
+func TestParseChannel(t *testing.T) {
+	tests := []struct {
+		in, name       string
+		entity, symbol string
+		eventType      string
+	}{
+		{"trades", "trades", "", "", stream.TypeTrade},
+		{" trades:all ", "trades", "", "", stream.TypeTrade},
+		{"trades:", "trades", "", "", stream.TypeTrade},
+		{"trades:Zurich", "trades:zurich", "zurich", "", stream.TypeTrade},
+		{"trades:zurich", "trades:zurich", "zurich", "", stream.TypeTrade},
+		{"trades:ALL:AAPL", "trades:all:AAPL", "", "AAPL", stream.TypeTrade},
+		{"trades::AAPL", "trades:all:AAPL", "", "AAPL", stream.TypeTrade},
+		{"holdings:New_York:BTC", "holdings:new_york:BTC", "new_york", "BTC", stream.TypeHolding},
+		{"alerts", "alerts", "", "", stream.TypeAlert},
+	}
+	for _, tt := range tests {
+		ch, err := parseChannel(tt.in)
+		if err != nil {
+			t.Errorf("parseChannel(%q): %v", tt.in, err)
+			continue
+		}
+		if ch.name != tt.name || ch.entity != tt.entity || ch.symbol != tt.symbol || ch.eventType != tt.eventType {
+			t.Errorf("parseChannel(%q) = %q %s/%s %s, want %q %s/%s %s", tt.in,
+				ch.name, ch.entity, ch.symbol, ch.eventType, tt.name, tt.entity, tt.symbol, tt.eventType)
+		}
+		if again, err := parseChannel(ch.name); err != nil || again.name != ch.name {
+			t.Errorf("canonical name %q does not parse to itself", ch.name)
+		}
+	}
+	for _, in := range []string{"", "prices", "alerts:zurich", "trades:z", "trades:zurich:AAPL:x", "trades:zurich:"} {
+		if ch, err := parseChannel(in); err == nil {
+			t.Errorf("parseChannel(%q) = %q, want an error", in, ch.name)
+		}
+	}
+}
+
+// TestDeliverOverflow checks that a client whose snapshot takes too long is
+// told why it is disconnected.
+func TestDeliverOverflow(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		cl := newWSClient(nil, conn)
+		ch, _ := parseChannel("trades")
+		cl.update(func(channels map[string]*wsChannel) { channels[ch.name] = ch })
+		e := stream.Event{Type: stream.TypeTrade, Entity: "zurich", Symbol: "AAPL"}
+		var err error
+		for range wsMaxPending + 1 {
+			if err = cl.deliver(e); err != nil {
+				break
+			}
+		}
+		errs <- err
+	}))
+	defer srv.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var m wsMessage
+	if err := websocket.JSON.Receive(conn, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != "closed" || m.Reason != "too many updates while loading snapshot" {
+		t.Errorf("got %+v, want closed with the overflow reason", m)
+	}
+	if err := <-errs; err != errWSOverflow {
+		t.Errorf("deliver returned %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package ingest applies trades that arrive outside Kafka (HTTP uploads,
+// CSV imports) through the same idempotent ApplyTrades path as the
+// consumer, and reports what happened to each of them.
+package ingest
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+
+	"github.com/example/trades-aggregator/internal/db"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+// BatchSize is how many trades are applied per transaction.
+const BatchSize = 500
+
+// Outcome statuses.
+const (
+	Accepted  = "accepted"
+	Duplicate = "duplicate" // already applied with the same contents
+	Conflict  = "conflict"  // trade_id already applied with other contents
+	Rejected  = "rejected"
+)
+
+// Outcome is what happened to one trade.
+type Outcome struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Apply applies trades (already prepared with holdings.PrepareTrade) in
+// batches and returns an outcome per trade. When the database rejects a
+// batch its trades are applied one by one so only the offending ones are
+// rejected, as the consumer does. A transient failure is returned as is;
+// the trades of the failing batch and those after it are not applied, and
+// retrying the whole call is safe.
+func Apply(ctx context.Context, svc *holdings.Service, trades []models.Trade) ([]Outcome, error) {
+	out := make([]Outcome, len(trades))
+	for start := 0; start < len(trades); start += BatchSize {
+		end := min(start+BatchSize, len(trades))
+		if err := apply(ctx, svc, trades[start:end], out[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func apply(ctx context.Context, svc *holdings.Service, trades []models.Trade, out []Outcome) error {
+	applied, err := svc.ApplyTrades(ctx, trades)
+	switch {
+	case err == nil:
+	case db.IsTransient(err) || ctx.Err() != nil:
+		return err
+	case len(trades) > 1:
+		for i := range trades {
+			if err := apply(ctx, svc, trades[i:i+1], out[i:i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case holdings.IsCorrectionError(err):
+		out[0] = Outcome{Status: Rejected, Error: err.Error()}
+		return nil
+	default:
+		out[0] = Outcome{Status: Rejected, Error: "rejected by the database: " + err.Error()}
+		return nil
+	}
+
+	for i, ok := range applied {
+		if ok {
+			out[i] = Outcome{Status: Accepted}
+			continue
+		}
+		t := trades[i]
+		stored, err := svc.GetTradeVersion(ctx, t.TradeID, t.Version)
+		if err != nil {
+			return err
+		}
+		out[i] = Outcome{Status: Duplicate}
+		if stored.Trade.Event != t.Event || (t.Event != models.EventCancel && !SameTrade(stored.Trade, t)) {
+			out[i] = Outcome{Status: Conflict, Error: fmt.Sprintf("version %d of trade_id was already applied with different contents", t.Version)}
+		}
+	}
+	return nil
+}
+
+// SameTrade reports whether a resent trade matches the stored one. The
+// timestamp is not compared: resent trades without "ts" are stamped anew.
+func SameTrade(a, b models.Trade) bool {
+	samePrice := (a.Price == nil) == (b.Price == nil) && (a.Price == nil || a.Price.Cmp(*b.Price) == 0)
+	return a.Entity == b.Entity && a.InstrumentType == b.InstrumentType && a.Symbol == b.Symbol &&
+		a.Quantity.Cmp(b.Quantity) == 0 && samePrice
+}
+
+// DeriveTradeID returns a stable trade_id for the i-th trade of an upload
+// identified by key (an Idempotency-Key, a file checksum), as a SHA-1
+// name-based (version 5 layout) UUID. Uploading the same thing again yields
+// the same IDs, so its trades are reported as duplicates.
+func DeriveTradeID(key string, i int) string {
+	sum := sha1.Sum([]byte("trades-aggregator/ingest/" + key + "/" + strconv.Itoa(i)))
+	u := sum[:16]
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,103 @@
+package instruments
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RowError is why one instrument of a bulk load was rejected. Index counts
+// instruments from 0, in the order given (CSV data rows, not lines).
+type RowError struct {
+	Index  int    `json:"index"`
+	Symbol string `json:"symbol,omitempty"`
+	Error  string `json:"error"`
+}
+
+// csvFields are the columns of a CSV bulk load, named as the JSON fields.
+// Identifiers come in columns named identifiers.<scheme>.
+var csvFields = map[string]bool{
+	"instrument_type": true, "symbol": true, "underlying": true, "multiplier": true, "expiry": true,
+	"strike": true, "option_type": true, "face_value": true, "coupon_rate": true, "base_currency": true,
+	"quote_currency": true, "currency": true, "tick_size": true, "lot_size": true, "isin": true,
+	"active_from": true, "active_to": true,
+}
+
+// ParseCSV reads a bulk load in CSV: a header row, then one instrument per
+// row. Empty cells leave the attribute unset. Rows that cannot be read as an
+// instrument are reported, with a zero Instrument in their place.
+func ParseCSV(r io.Reader) ([]Instrument, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("empty file: want a header row")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for n, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		header[n] = h
+		if !csvFields[h] && (!strings.HasPrefix(h, "identifiers.") || h == "identifiers.") {
+			return nil, nil, fmt.Errorf("unknown column %q", h)
+		}
+	}
+
+	var out []Instrument
+	var bad []RowError
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return out, bad, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		fields := make(map[string]any, len(rec))
+		ids := make(map[string]string)
+		for n, v := range rec {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			if scheme, ok := strings.CutPrefix(header[n], "identifiers."); ok {
+				ids[scheme] = v
+				continue
+			}
+			fields[header[n]] = v
+		}
+		if len(ids) > 0 {
+			fields["identifiers"] = ids
+		}
+		var i Instrument
+		b, _ := json.Marshal(fields)
+		if err := json.Unmarshal(b, &i); err != nil {
+			symbol, _ := fields["symbol"].(string)
+			bad = append(bad, RowError{Index: len(out), Symbol: symbol, Error: err.Error()})
+		}
+		out = append(out, i)
+	}
+}
+
+// Prepare checks every instrument of a bulk load as Check does, and that no
+// instrument is listed twice. It returns the rejected ones.
+func Prepare(is []Instrument) []RowError {
+	var bad []RowError
+	seen := make(map[key]int, len(is))
+	for n := range is {
+		if err := Check(&is[n]); err != nil {
+			bad = append(bad, RowError{Index: n, Symbol: is[n].Symbol, Error: err.Error()})
+			continue
+		}
+		k := key{is[n].InstrumentType, is[n].Symbol}
+		if first, ok := seen[k]; ok {
+			bad = append(bad, RowError{Index: n, Symbol: is[n].Symbol, Error: fmt.Sprintf("listed twice (first at %d)", first)})
+			continue
+		}
+		seen[k] = n
+	}
+	return bad
+}
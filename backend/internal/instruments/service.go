@@ -0,0 +1,555 @@
+// Package instruments is the instrument master: the reference data of every
+// tradable instrument (currency, tick and lot size, identifiers, the dates
+// it trades between) and the contract terms of those that have them (FX
+// pairs, futures, options, bonds). Like the entity registry it is kept in
+// Postgres, served from memory and reloaded periodically by every replica.
+package instruments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Option types.
+const (
+	Put  = "put"
+	Call = "call"
+)
+
+// Instrument is one entry of the master. Which attributes are set depends
+// on the type; see Check.
+type Instrument struct {
+	InstrumentType string            `json:"instrument_type"`
+	Symbol         string            `json:"symbol"`
+	Underlying     string            `json:"underlying,omitempty"`     // futures and options
+	Multiplier     decimal.Decimal   `json:"multiplier"`               // units per contract; 1 unless a future or option
+	Expiry         string            `json:"expiry,omitempty"`         // YYYY-MM-DD; a bond's maturity
+	Strike         *decimal.Decimal  `json:"strike,omitempty"`         // options
+	OptionType     string            `json:"option_type,omitempty"`    // options: put or call
+	FaceValue      *decimal.Decimal  `json:"face_value,omitempty"`     // bonds
+	CouponRate     *decimal.Decimal  `json:"coupon_rate,omitempty"`    // bonds, percent per year
+	BaseCurrency   string            `json:"base_currency,omitempty"`  // FX
+	QuoteCurrency  string            `json:"quote_currency,omitempty"` // FX
+	Currency       string            `json:"currency,omitempty"`       // of prices (an FX pair's quote currency)
+	TickSize       *decimal.Decimal  `json:"tick_size,omitempty"`
+	LotSize        *decimal.Decimal  `json:"lot_size,omitempty"`
+	ISIN           string            `json:"isin,omitempty"`
+	Identifiers    map[string]string `json:"identifiers,omitempty"` // other codes by scheme, e.g. {"figi": "..."}
+	ActiveFrom     string            `json:"active_from,omitempty"` // YYYY-MM-DD, first trading day
+	ActiveTo       string            `json:"active_to,omitempty"`   // YYYY-MM-DD, last trading day
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+var (
+	one     = decimal.NewFromInt(1)
+	hundred = decimal.NewFromInt(100)
+)
+
+// ContractSize is what one unit of quantity is worth per unit of price:
+// the multiplier, except for bonds, which are quoted in percent of their
+// face value.
+func (i Instrument) ContractSize() decimal.Decimal {
+	if i.InstrumentType == string(domain.InstrumentBond) && i.FaceValue != nil {
+		return i.FaceValue.Div(hundred, 16)
+	}
+	return i.Multiplier
+}
+
+// TradableOn reports whether the instrument trades on the (UTC) day of t:
+// between its active dates, and not after the expiry of a future or option
+// or the maturity of a bond.
+func (i Instrument) TradableOn(t time.Time) bool {
+	day := t.UTC().Format(dateLayout)
+	last := i.ActiveTo
+	if i.Expiry != "" && (last == "" || i.Expiry < last) {
+		last = i.Expiry
+	}
+	return (i.ActiveFrom == "" || day >= i.ActiveFrom) && (last == "" || day <= last)
+}
+
+const (
+	dateLayout       = "2006-01-02"
+	maxSymbolLen     = 32
+	maxIdentifiers   = 10
+	maxIdentifierLen = 64
+)
+
+// Check normalizes i and rejects entries that are incomplete for their type
+// or carry attributes that do not apply to it.
+func Check(i *Instrument) error {
+	i.InstrumentType = strings.ToLower(strings.TrimSpace(i.InstrumentType))
+	i.Symbol = strings.TrimSpace(i.Symbol)
+	i.Underlying = strings.TrimSpace(i.Underlying)
+	i.Expiry = strings.TrimSpace(i.Expiry)
+	i.OptionType = strings.ToLower(strings.TrimSpace(i.OptionType))
+	i.BaseCurrency = strings.ToUpper(strings.TrimSpace(i.BaseCurrency))
+	i.QuoteCurrency = strings.ToUpper(strings.TrimSpace(i.QuoteCurrency))
+	i.Currency = strings.ToUpper(strings.TrimSpace(i.Currency))
+	i.ISIN = strings.ToUpper(strings.TrimSpace(i.ISIN))
+	i.ActiveFrom = strings.TrimSpace(i.ActiveFrom)
+	i.ActiveTo = strings.TrimSpace(i.ActiveTo)
+
+	t, ok := domain.ParseInstrumentType(i.InstrumentType)
+	if !ok {
+		return fmt.Errorf("unknown instrument_type %q", i.InstrumentType)
+	}
+	if i.Symbol == "" || len(i.Symbol) > maxSymbolLen ||
+		strings.IndexFunc(i.Symbol, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0 {
+		return fmt.Errorf("symbol %q: use 1 to %d characters without whitespace", i.Symbol, maxSymbolLen)
+	}
+	for _, d := range []struct{ name, value string }{{"expiry", i.Expiry}, {"active_from", i.ActiveFrom}, {"active_to", i.ActiveTo}} {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, d.value); err != nil {
+			return fmt.Errorf("%s %q: use YYYY-MM-DD", d.name, d.value)
+		}
+	}
+	if i.ActiveFrom != "" && i.ActiveTo != "" && i.ActiveTo < i.ActiveFrom {
+		return errors.New("active_to is before active_from")
+	}
+	if err := checkReference(i); err != nil {
+		return err
+	}
+
+	derivative := t == domain.InstrumentFuture || t == domain.InstrumentOption
+	switch {
+	case i.Multiplier.Sign() < 0:
+		return errors.New("multiplier must be greater than zero")
+	case i.Multiplier.IsZero() && !derivative:
+		i.Multiplier = one
+	case !i.Multiplier.IsZero() && !derivative && !i.Multiplier.Equal(one):
+		return errors.New("multiplier applies to futures and options only")
+	}
+
+	var missing, extra []string
+	need := func(name string, set, wanted bool) {
+		switch {
+		case wanted && !set:
+			missing = append(missing, name)
+		case !wanted && set:
+			extra = append(extra, name)
+		}
+	}
+	if derivative && i.Multiplier.IsZero() {
+		missing = append(missing, "multiplier")
+	}
+	need("underlying", i.Underlying != "", derivative)
+	need("expiry", i.Expiry != "", derivative || t == domain.InstrumentBond)
+	need("strike", i.Strike != nil, t == domain.InstrumentOption)
+	need("option_type", i.OptionType != "", t == domain.InstrumentOption)
+	need("face_value", i.FaceValue != nil, t == domain.InstrumentBond)
+	need("coupon_rate", i.CouponRate != nil, t == domain.InstrumentBond)
+	need("base_currency", i.BaseCurrency != "", t == domain.InstrumentFX)
+	need("quote_currency", i.QuoteCurrency != "", t == domain.InstrumentFX)
+	if len(missing) > 0 {
+		return fmt.Errorf("%s requires %s", t, strings.Join(missing, ", "))
+	}
+	if len(extra) > 0 {
+		return fmt.Errorf("%s does not take %s", t, strings.Join(extra, ", "))
+	}
+
+	switch t {
+	case domain.InstrumentOption:
+		if i.Strike.Sign() <= 0 {
+			return errors.New("strike must be greater than zero")
+		}
+		if i.OptionType != Put && i.OptionType != Call {
+			return fmt.Errorf("option_type %q: use 'put' or 'call'", i.OptionType)
+		}
+	case domain.InstrumentBond:
+		if i.FaceValue.Sign() <= 0 {
+			return errors.New("face_value must be greater than zero")
+		}
+		if i.CouponRate.Sign() < 0 {
+			return errors.New("coupon_rate must not be negative")
+		}
+	case domain.InstrumentFX:
+		if !isCurrency(i.BaseCurrency) || !isCurrency(i.QuoteCurrency) || i.BaseCurrency == i.QuoteCurrency {
+			return errors.New("base_currency and quote_currency must be two different ISO 4217 codes")
+		}
+		if pair := i.BaseCurrency + i.QuoteCurrency; i.Symbol != pair {
+			return fmt.Errorf("symbol of an FX pair is base then quote currency, %s", pair)
+		}
+		switch i.Currency {
+		case "":
+			i.Currency = i.QuoteCurrency
+		case i.QuoteCurrency:
+		default:
+			return errors.New("an FX pair is priced in its quote currency")
+		}
+	}
+	if i.Currency == "" {
+		return errors.New("currency is required, e.g. USD")
+	}
+	return nil
+}
+
+// checkReference checks the attributes every type takes.
+func checkReference(i *Instrument) error {
+	if i.Currency != "" && !isCurrency(i.Currency) {
+		return fmt.Errorf("currency %q: use an ISO 4217 code, e.g. USD", i.Currency)
+	}
+	if i.TickSize != nil && i.TickSize.Sign() <= 0 {
+		return errors.New("tick_size must be greater than zero")
+	}
+	if i.LotSize != nil && i.LotSize.Sign() <= 0 {
+		return errors.New("lot_size must be greater than zero")
+	}
+	if i.ISIN != "" && !ValidISIN(i.ISIN) {
+		return fmt.Errorf("isin %q: not a valid ISIN", i.ISIN)
+	}
+	if len(i.Identifiers) > maxIdentifiers {
+		return fmt.Errorf("at most %d identifiers", maxIdentifiers)
+	}
+	ids := make(map[string]string, len(i.Identifiers))
+	for scheme, id := range i.Identifiers {
+		scheme, id = strings.ToLower(strings.TrimSpace(scheme)), strings.TrimSpace(id)
+		switch {
+		case scheme == "isin":
+			return errors.New("give the ISIN as isin, not among identifiers")
+		case !validScheme(scheme):
+			return fmt.Errorf("identifier scheme %q: use lower-case letters, digits and underscores, e.g. figi", scheme)
+		case id == "" || len(id) > maxIdentifierLen:
+			return fmt.Errorf("identifier %s: 1 to %d characters", scheme, maxIdentifierLen)
+		}
+		ids[scheme] = id
+	}
+	i.Identifiers = ids
+	return nil
+}
+
+// validScheme accepts identifier schemes like figi, cusip or bbg_ticker.
+func validScheme(s string) bool {
+	if s == "" || len(s) > 32 {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidISIN checks the format and check digit of an ISIN (ISO 6166).
+func ValidISIN(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	// Letters count as two digits (A=10 ... Z=35), then the Luhn algorithm
+	// runs over the digit string, check digit included.
+	var digits []int
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9' && i >= 2:
+			digits = append(digits, int(r-'0'))
+		case r >= 'A' && r <= 'Z' && i < 11:
+			n := int(r-'A') + 10
+			digits = append(digits, n/10, n%10)
+		default:
+			return false
+		}
+	}
+	sum := 0
+	for k := range digits {
+		d := digits[len(digits)-1-k]
+		if k%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func isCurrency(s string) bool {
+	return len(s) == 3 && strings.IndexFunc(s, func(r rune) bool { return r < 'A' || r > 'Z' }) < 0
+}
+
+var (
+	// ErrContractSizeInUse rejects changing the contract size of an
+	// instrument that has trades: their booked costs and P&L were scaled by
+	// the old one.
+	ErrContractSizeInUse = errors.New("instrument has trades; its multiplier and face value cannot change")
+	// ErrInUse rejects deleting an instrument that has trades; end its
+	// active dates instead.
+	ErrInUse = errors.New("instrument has trades; set active_to instead of deleting it")
+	// ErrNotFound is returned for an instrument the master does not have.
+	ErrNotFound = errors.New("no such instrument")
+	// ErrDuplicateISIN rejects an ISIN that another instrument already has.
+	ErrDuplicateISIN = errors.New("isin is already used by another instrument")
+)
+
+type key struct{ instrumentType, symbol string }
+
+// Service persists the master and answers lookups from memory. It
+// implements domain.InstrumentRegistry.
+type Service struct {
+	DB *pgxpool.Pool
+
+	mu    sync.RWMutex
+	byKey map[key]Instrument
+}
+
+func New(db *pgxpool.Pool) *Service {
+	return &Service{DB: db, byKey: make(map[key]Instrument)}
+}
+
+const columns = `instrument_type::text, symbol, COALESCE(underlying, ''), multiplier, COALESCE(expiry::text, ''), strike,
+	COALESCE(option_type, ''), face_value, coupon_rate, COALESCE(base_currency, ''), COALESCE(quote_currency, ''),
+	COALESCE(currency, ''), tick_size, lot_size, COALESCE(isin, ''), identifiers,
+	COALESCE(active_from::text, ''), COALESCE(active_to::text, ''), created_at, updated_at`
+
+// scanInstrument scans the columns, then into extra.
+func scanInstrument(row pgx.Row, extra ...any) (Instrument, error) {
+	var i Instrument
+	dest := append([]any{&i.InstrumentType, &i.Symbol, &i.Underlying, &i.Multiplier, &i.Expiry, &i.Strike,
+		&i.OptionType, &i.FaceValue, &i.CouponRate, &i.BaseCurrency, &i.QuoteCurrency,
+		&i.Currency, &i.TickSize, &i.LotSize, &i.ISIN, &i.Identifiers, &i.ActiveFrom, &i.ActiveTo,
+		&i.CreatedAt, &i.UpdatedAt}, extra...)
+	err := row.Scan(dest...)
+	return i, err
+}
+
+// Load replaces the in-memory view with the database's.
+func (s *Service) Load(ctx context.Context) error {
+	rows, err := s.DB.Query(ctx, `SELECT `+columns+` FROM instruments`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byKey := make(map[key]Instrument)
+	for rows.Next() {
+		i, err := scanInstrument(rows)
+		if err != nil {
+			return err
+		}
+		byKey[key{i.InstrumentType, i.Symbol}] = i
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byKey = byKey
+	s.mu.Unlock()
+	return nil
+}
+
+// Run reloads the master every interval until ctx is cancelled. Failures
+// are logged and the previous view kept.
+func (s *Service) Run(ctx context.Context, every time.Duration, logger *zap.Logger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.Load(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn("instruments_reload_failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// ContractSize implements domain.InstrumentRegistry.
+func (s *Service) ContractSize(instrumentType, symbol string) (decimal.Decimal, bool) {
+	i, ok := s.Get(instrumentType, symbol)
+	if !ok {
+		return decimal.Decimal{}, false
+	}
+	return i.ContractSize(), true
+}
+
+// TradableOn implements domain.InstrumentRegistry.
+func (s *Service) TradableOn(instrumentType, symbol string, t time.Time) (known, tradable bool) {
+	i, ok := s.Get(instrumentType, symbol)
+	return ok, ok && i.TradableOn(t)
+}
+
+// Get returns the instrument of that type and symbol.
+func (s *Service) Get(instrumentType, symbol string) (Instrument, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.byKey[key{instrumentType, symbol}]
+	return i, ok
+}
+
+// All returns the instruments of instrumentType (every type when empty),
+// ordered by type and symbol.
+func (s *Service) All(instrumentType string) []Instrument {
+	s.mu.RLock()
+	out := make([]Instrument, 0, len(s.byKey))
+	for k, i := range s.byKey {
+		if instrumentType == "" || k.instrumentType == instrumentType {
+			out = append(out, i)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].InstrumentType != out[b].InstrumentType {
+			return out[a].InstrumentType < out[b].InstrumentType
+		}
+		return out[a].Symbol < out[b].Symbol
+	})
+	return out
+}
+
+// Put registers i (already checked) or replaces the instrument with its
+// type and symbol, and reports whether it was created. The contract size of
+// an instrument with trades cannot change (ErrContractSizeInUse).
+func (s *Service) Put(ctx context.Context, i Instrument) (Instrument, bool, error) {
+	out, created, err := s.PutAll(ctx, []Instrument{i})
+	if err != nil {
+		return Instrument{}, false, err
+	}
+	return out[0], created[0], nil
+}
+
+// PutAll is Put for many instruments in one transaction: either all of them
+// are saved or, on the first error, none.
+func (s *Service) PutAll(ctx context.Context, is []Instrument) ([]Instrument, []bool, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	out := make([]Instrument, len(is))
+	created := make([]bool, len(is))
+	for n, i := range is {
+		if out[n], created[n], err = put(ctx, tx, i); err != nil {
+			return nil, nil, fmt.Errorf("%s %s: %w", i.InstrumentType, i.Symbol, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	for _, i := range out {
+		s.byKey[key{i.InstrumentType, i.Symbol}] = i
+	}
+	s.mu.Unlock()
+	return out, created, nil
+}
+
+func put(ctx context.Context, tx pgx.Tx, i Instrument) (Instrument, bool, error) {
+	prev, err := scanInstrument(tx.QueryRow(ctx, `
+		SELECT `+columns+` FROM instruments
+		WHERE instrument_type = $1::instrument_type AND symbol = $2
+		FOR UPDATE
+	`, i.InstrumentType, i.Symbol))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return Instrument{}, false, err
+	case !prev.ContractSize().Equal(i.ContractSize()):
+		traded, err := hasTrades(ctx, tx, i)
+		if err != nil {
+			return Instrument{}, false, err
+		}
+		if traded {
+			return Instrument{}, false, ErrContractSizeInUse
+		}
+	}
+	if i.ISIN != "" {
+		var taken bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM instruments WHERE isin = $1 AND (instrument_type::text, symbol) <> ($2, $3))
+		`, i.ISIN, i.InstrumentType, i.Symbol).Scan(&taken); err != nil {
+			return Instrument{}, false, err
+		}
+		if taken {
+			return Instrument{}, false, ErrDuplicateISIN
+		}
+	}
+	ids := i.Identifiers
+	if ids == nil {
+		ids = map[string]string{}
+	}
+
+	var created bool
+	out, err := scanInstrument(tx.QueryRow(ctx, `
+		INSERT INTO instruments (instrument_type, symbol, underlying, multiplier, expiry, strike, option_type,
+		                         face_value, coupon_rate, base_currency, quote_currency,
+		                         currency, tick_size, lot_size, isin, identifiers, active_from, active_to)
+		VALUES ($1::instrument_type, $2, NULLIF($3, ''), $4, NULLIF($5, '')::date, $6, NULLIF($7, ''),
+		        $8, $9, NULLIF($10, ''), NULLIF($11, ''),
+		        NULLIF($12, ''), $13, $14, NULLIF($15, ''), $16, NULLIF($17, '')::date, NULLIF($18, '')::date)
+		ON CONFLICT (instrument_type, symbol) DO UPDATE
+		SET underlying = EXCLUDED.underlying, multiplier = EXCLUDED.multiplier, expiry = EXCLUDED.expiry,
+		    strike = EXCLUDED.strike, option_type = EXCLUDED.option_type, face_value = EXCLUDED.face_value,
+		    coupon_rate = EXCLUDED.coupon_rate, base_currency = EXCLUDED.base_currency,
+		    quote_currency = EXCLUDED.quote_currency, currency = EXCLUDED.currency,
+		    tick_size = EXCLUDED.tick_size, lot_size = EXCLUDED.lot_size, isin = EXCLUDED.isin,
+		    identifiers = EXCLUDED.identifiers, active_from = EXCLUDED.active_from,
+		    active_to = EXCLUDED.active_to, updated_at = now()
+		RETURNING `+columns+`, xmax = 0
+	`, i.InstrumentType, i.Symbol, i.Underlying, i.Multiplier, i.Expiry, i.Strike, i.OptionType,
+		i.FaceValue, i.CouponRate, i.BaseCurrency, i.QuoteCurrency,
+		i.Currency, i.TickSize, i.LotSize, i.ISIN, ids, i.ActiveFrom, i.ActiveTo), &created)
+	if err != nil {
+		return Instrument{}, false, err
+	}
+	return out, created, nil
+}
+
+func hasTrades(ctx context.Context, tx pgx.Tx, i Instrument) (bool, error) {
+	var traded bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM trades WHERE instrument_type = $1::instrument_type AND symbol = $2)
+	`, i.InstrumentType, i.Symbol).Scan(&traded)
+	return traded, err
+}
+
+// Delete removes an instrument that was never traded (ErrInUse otherwise).
+func (s *Service) Delete(ctx context.Context, instrumentType, symbol string) error {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	i := Instrument{InstrumentType: instrumentType, Symbol: symbol}
+	tag, err := tx.Exec(ctx, `
+		DELETE FROM instruments WHERE instrument_type = $1::instrument_type AND symbol = $2
+	`, instrumentType, symbol)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	traded, err := hasTrades(ctx, tx, i)
+	if err != nil {
+		return err
+	}
+	if traded {
+		return ErrInUse
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.byKey, key{instrumentType, symbol})
+	s.mu.Unlock()
+	return nil
+}
@@ -2,22 +2,83 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/example/trades-aggregator/internal/db"
+	"github.com/example/trades-aggregator/internal/deadletter"
 	"github.com/example/trades-aggregator/internal/holdings"
 	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/validation"
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
+// Consumer applies trades from Kafka with at-least-once semantics: messages
+// are fetched in batches, applied in one transaction, and only then
+// committed. Redeliveries are harmless because ApplyTrades is idempotent on
+// trade_id.
 type Consumer struct {
 	Reader *kafka.Reader
 	Svc    *holdings.Service
 	Logger *zap.Logger
+	Retry  RetryPolicy
+	Batch  BatchPolicy
+	DLQ    *DeadLetterQueue
+	// OnUnknownInstrument handles trades that are only invalid because the
+	// instrument master does not list their instrument as tradable.
+	OnUnknownInstrument UnknownInstrumentAction
+
+	applied     atomic.Uint64
+	duplicates  atomic.Uint64
+	failed      atomic.Uint64
+	deadLetters atomic.Uint64
+}
+
+// UnknownInstrumentAction decides what happens to a trade for an instrument
+// the master does not know, or not as tradable on the trade's date.
+type UnknownInstrumentAction string
+
+const (
+	// UnknownInstrumentQuarantine dead-letters the trade as quarantined. It
+	// is re-driven automatically once the instrument is registered or its
+	// active dates are extended. This is the default.
+	UnknownInstrumentQuarantine UnknownInstrumentAction = "quarantine"
+	// UnknownInstrumentReject dead-letters it like any other invalid trade;
+	// only an operator re-drives it.
+	UnknownInstrumentReject UnknownInstrumentAction = "reject"
+)
+
+func ParseUnknownInstrumentAction(s string) (UnknownInstrumentAction, error) {
+	switch a := UnknownInstrumentAction(s); a {
+	case UnknownInstrumentQuarantine, UnknownInstrumentReject:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown instrument action %q (use 'quarantine' or 'reject')", s)
+	}
 }
 
-func NewConsumer(brokers, topic, groupID string, svc *holdings.Service, logger * /*  */ zap.Logger) *Consumer {
+// BatchPolicy bounds how many messages are accumulated, and for how long
+// after the first one arrives, before they are applied together.
+type BatchPolicy struct {
+	Size int
+	Wait time.Duration
+}
+
+// Stats is a point-in-time view of the consumer's counters.
+type Stats struct {
+	Applied    uint64 `json:"applied"`
+	Duplicates uint64 `json:"duplicates"`
+	Failed     uint64 `json:"failed"`
+	DeadLetter uint64 `json:"dead_lettered"`
+}
+
+func NewConsumer(brokers, topic, groupID string, retry RetryPolicy, batch BatchPolicy, dlq *DeadLetterQueue, svc *holdings.Service, logger *zap.Logger) *Consumer {
+	if batch.Size < 1 {
+		batch.Size = 1
+	}
 	return &Consumer{
 		Reader: kafka.NewReader(kafka.ReaderConfig{
 			Brokers:  []string{brokers},
@@ -26,31 +87,224 @@ func NewConsumer(brokers, topic, groupID string, svc *holdings.Service, logger *
 			MinBytes: 1e3,
 			MaxBytes: 1e6,
 			MaxWait:  500 * time.Millisecond,
+			// Offsets are committed explicitly after each successful batch.
+			CommitInterval: 0,
 		}),
 		Svc:    svc,
 		Logger: logger,
+		Retry:  retry,
+		Batch:  batch,
+		DLQ:    dlq,
 	}
 }
 
+// Stats returns how many trades were applied, skipped as duplicates, or failed.
+func (c *Consumer) Stats() Stats {
+	return Stats{
+		Applied:    c.applied.Load(),
+		Duplicates: c.duplicates.Load(),
+		Failed:     c.failed.Load(),
+		DeadLetter: c.deadLetters.Load(),
+	}
+}
+
+// Run consumes until ctx is cancelled or a batch exhausts its retries under
+// the stop policy; in the latter case the offsets stay uncommitted and the
+// returned error should bring the process down for a restart.
 func (c *Consumer) Run(ctx context.Context) error {
 	defer c.Reader.Close()
+	defer c.DLQ.Close()
 	for {
-		m, err := c.Reader.ReadMessage(ctx)
+		msgs, err := c.fetchBatch(ctx)
 		if err != nil {
 			return err
 		}
-		var t models.Trade
-		if err := json.Unmarshal(m.Value, &t); err != nil {
-			c.Logger.Warn("bad message", zap.Error(err))
+		if err := c.handleBatch(ctx, msgs); err != nil {
+			return err
+		}
+		if err := c.Reader.CommitMessages(ctx, msgs...); err != nil {
+			last := msgs[len(msgs)-1]
+			return fmt.Errorf("commit offsets up to %d/%d: %w", last.Partition, last.Offset, err)
+		}
+	}
+}
+
+func (c *Consumer) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	return fetchBatch(ctx, c.Reader, c.Batch)
+}
+
+// fetchBatch blocks for the first message, then keeps collecting until the
+// batch is full or p.Wait has elapsed.
+func fetchBatch(ctx context.Context, r *kafka.Reader, p BatchPolicy) ([]kafka.Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs := append(make([]kafka.Message, 0, p.Size), m)
+	if p.Size <= 1 {
+		return msgs, nil
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, p.Wait)
+	defer cancel()
+	for len(msgs) < p.Size {
+		m, err := r.FetchMessage(wctx)
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				break // budget spent: apply what we have
+			}
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// handleBatch processes msgs. A nil return means all their offsets may be
+// committed: every trade was applied, was a duplicate, or was dead-lettered
+// or deliberately skipped.
+func (c *Consumer) handleBatch(ctx context.Context, msgs []kafka.Message) error {
+	trades := make([]models.Trade, 0, len(msgs))
+	sources := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		t, err := holdings.DecodeTrade(m.Value)
+		if ve, ok := validation.AsError(err); ok {
+			c.failed.Add(1)
+			reason := deadletter.ReasonInvalid
+			if c.OnUnknownInstrument != UnknownInstrumentReject && ve.InstrumentOnly() {
+				reason = deadletter.ReasonQuarantined
+			}
+			c.Logger.Warn("invalid trade", zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset),
+				zap.String("reason", reason), zap.Any("errors", ve.Fields))
+			if err := c.deadLetter(ctx, m, reason, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			c.failed.Add(1)
+			c.Logger.Warn("bad message", zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset), zap.Error(err))
+			if err := c.deadLetter(ctx, m, deadletter.ReasonDecode, err); err != nil {
+				return err
+			}
 			continue
 		}
-		if t.TS.IsZero() {
-			t.TS = time.Now().UTC()
+		t.Origin = originOf(m)
+		trades = append(trades, t)
+		sources = append(sources, m)
+	}
+	if len(trades) == 0 {
+		return nil
+	}
+	return c.apply(ctx, sources, trades)
+}
+
+// apply applies trades (decoded from msgs) and settles the outcome of each.
+func (c *Consumer) apply(ctx context.Context, msgs []kafka.Message, trades []models.Trade) error {
+	applied, err := c.applyWithRetry(ctx, trades)
+	switch {
+	case err == nil:
+		for i, ok := range applied {
+			c.recordApplied(msgs[i], trades[i], ok)
+		}
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case db.IsTransient(err):
+		c.failed.Add(uint64(len(trades)))
+		switch c.Retry.OnExhausted {
+		case ExhaustedSkip:
+			for i, m := range msgs {
+				c.Logger.Error("apply trade retries exhausted; skipping",
+					zap.String("trade_id", trades[i].TradeID), zap.Int("partition", m.Partition),
+					zap.Int64("offset", m.Offset), zap.ByteString("payload", m.Value), zap.Error(err))
+			}
+			return nil
+		case ExhaustedDeadLetter:
+			for _, m := range msgs {
+				if err := c.deadLetter(ctx, m, deadletter.ReasonRetriesExhausted, err); err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			last := msgs[len(msgs)-1]
+			return fmt.Errorf("apply %d trades up to %d/%d: retries exhausted: %w", len(trades), last.Partition, last.Offset, err)
+		}
+	case len(trades) > 1:
+		// Permanent failure somewhere in the batch: apply one by one so only
+		// the offending trade is rejected.
+		c.Logger.Warn("batch rejected; isolating", zap.Int("size", len(trades)), zap.Error(err))
+		for i := range trades {
+			if err := c.apply(ctx, msgs[i:i+1], trades[i:i+1]); err != nil {
+				return err
+			}
 		}
-		if err := c.Svc.ApplyTrade(ctx, t); err != nil {
-			c.Logger.Error("apply trade", zap.Error(err))
-		} else {
-			c.Logger.Debug("trade applied", zap.String("trade_id", t.TradeID))
+		return nil
+	default:
+		// Permanent (e.g. a value Postgres rejects): retrying cannot help.
+		m := msgs[0]
+		c.failed.Add(1)
+		c.Logger.Error("apply trade rejected",
+			zap.String("trade_id", trades[0].TradeID), zap.Int("partition", m.Partition),
+			zap.Int64("offset", m.Offset), zap.Error(err))
+		return c.deadLetter(ctx, m, deadletter.ReasonRejected, err)
+	}
+}
+
+func (c *Consumer) recordApplied(m kafka.Message, t models.Trade, applied bool) {
+	if !applied {
+		c.duplicates.Add(1)
+		c.Logger.Info("duplicate trade skipped",
+			zap.String("trade_id", t.TradeID),
+			zap.Int("partition", m.Partition),
+			zap.Int64("offset", m.Offset),
+			zap.Uint64("duplicates_total", c.duplicates.Load()),
+		)
+		return
+	}
+	c.applied.Add(1)
+	c.Logger.Debug("trade applied", zap.String("trade_id", t.TradeID))
+}
+
+// deadLetter hands m to the DLQ. Failing to do so stops the consumer with the
+// offset uncommitted rather than losing the message.
+func (c *Consumer) deadLetter(ctx context.Context, m kafka.Message, reason string, cause error) error {
+	if err := c.DLQ.Send(ctx, m, reason, cause); err != nil {
+		return fmt.Errorf("dead-letter %d/%d: %w", m.Partition, m.Offset, err)
+	}
+	c.deadLetters.Add(1)
+	c.Logger.Warn("message dead-lettered",
+		zap.String("reason", reason), zap.Int("partition", m.Partition),
+		zap.Int64("offset", m.Offset), zap.Error(cause))
+	return nil
+}
+
+// applyWithRetry retries transient failures per c.Retry and returns the last
+// error once the budget is spent.
+func (c *Consumer) applyWithRetry(ctx context.Context, trades []models.Trade) ([]bool, error) {
+	for attempt := 1; ; attempt++ {
+		applied, err := c.Svc.ApplyTrades(ctx, trades)
+		if err == nil || !db.IsTransient(err) || attempt > c.Retry.MaxRetries {
+			return applied, err
+		}
+		wait := c.Retry.delay(attempt)
+		c.Logger.Warn("apply trades failed; retrying",
+			zap.Int("size", len(trades)), zap.Int("attempt", attempt),
+			zap.Duration("backoff", wait), zap.Error(err))
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
 		}
 	}
 }
+
+func originOf(m kafka.Message) *models.Origin {
+	partition, offset := m.Partition, m.Offset
+	return &models.Origin{
+		Source:     models.SourceKafka,
+		Topic:      m.Topic,
+		Partition:  &partition,
+		Offset:     &offset,
+		ReceivedAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,190 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/deadletter"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Headers added to every message forwarded to the dead-letter topic, next to
+// the original message headers.
+const (
+	HeaderDLQTopic     = "dlq-source-topic"
+	HeaderDLQPartition = "dlq-source-partition"
+	HeaderDLQOffset    = "dlq-source-offset"
+	HeaderDLQReason    = "dlq-reason"
+	HeaderDLQError     = "dlq-error"
+	HeaderDLQFailedAt  = "dlq-failed-at"
+)
+
+// DeadLetterQueue forwards messages that cannot be applied to a dead-letter
+// topic, from which a DeadLetterRecorder records them for the admin API.
+// Without a topic they are recorded directly.
+type DeadLetterQueue struct {
+	Writer *kafka.Writer // nil records entries in Store synchronously
+	Store  *deadletter.Service
+}
+
+func NewDeadLetterQueue(brokers, topic string, store *deadletter.Service) *DeadLetterQueue {
+	q := &DeadLetterQueue{Store: store}
+	if topic != "" {
+		q.Writer = &kafka.Writer{
+			Addr:                   kafka.TCP(brokers),
+			Topic:                  topic,
+			Balancer:               &kafka.LeastBytes{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		}
+	}
+	return q
+}
+
+// Send dead-letters m. It only returns nil once the message is safely
+// stored, so the caller may then commit its offset. With a topic that is the
+// Kafka write alone: the database row is backfilled from the topic, so a
+// database outage (often why the message is here) does not stop the
+// consumer.
+func (q *DeadLetterQueue) Send(ctx context.Context, m kafka.Message, reason string, cause error) error {
+	if q.Writer == nil {
+		return q.Store.Record(ctx, entryOf(m, m.Topic, m.Partition, m.Offset, reason, cause.Error()))
+	}
+	return q.Writer.WriteMessages(ctx, deadLetterMessage(m, reason, cause))
+}
+
+// deadLetterMessage is m as forwarded to the dead-letter topic.
+func deadLetterMessage(m kafka.Message, reason string, cause error) kafka.Message {
+	return kafka.Message{
+		Key:   m.Key,
+		Value: m.Value,
+		Headers: append(append([]kafka.Header{}, m.Headers...),
+			kafka.Header{Key: HeaderDLQTopic, Value: []byte(m.Topic)},
+			kafka.Header{Key: HeaderDLQPartition, Value: []byte(strconv.Itoa(m.Partition))},
+			kafka.Header{Key: HeaderDLQOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+			kafka.Header{Key: HeaderDLQReason, Value: []byte(reason)},
+			kafka.Header{Key: HeaderDLQError, Value: []byte(cause.Error())},
+			kafka.Header{Key: HeaderDLQFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+		),
+	}
+}
+
+func (q *DeadLetterQueue) Close() error {
+	if q.Writer == nil {
+		return nil
+	}
+	return q.Writer.Close()
+}
+
+// entryOf is the entry recording m, which came from topic/partition/offset.
+// Headers added by Send are left out.
+func entryOf(m kafka.Message, topic string, partition int, offset int64, reason, cause string) deadletter.Entry {
+	headers := make([]deadletter.Header, 0, len(m.Headers))
+	for _, h := range m.Headers {
+		if !strings.HasPrefix(h.Key, "dlq-") {
+			headers = append(headers, deadletter.Header{Key: h.Key, Value: h.Value})
+		}
+	}
+	return deadletter.Entry{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Key:       m.Key,
+		Payload:   m.Value,
+		Headers:   headers,
+		Reason:    reason,
+		Error:     cause,
+	}
+}
+
+// DeadLetterRecorder reads the dead-letter topic back and records every
+// message in the dead_letters table, retrying while the database is down.
+// Recording is idempotent on the source offset, so redeliveries are harmless.
+type DeadLetterRecorder struct {
+	Reader *kafka.Reader
+	Store  *deadletter.Service
+	Logger *zap.Logger
+	Retry  RetryPolicy // only Backoff and MaxBackoff are used: it never gives up
+}
+
+func NewDeadLetterRecorder(brokers, topic, groupID string, retry RetryPolicy, store *deadletter.Service, logger *zap.Logger) *DeadLetterRecorder {
+	return &DeadLetterRecorder{
+		Reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:        []string{brokers},
+			Topic:          topic,
+			GroupID:        groupID,
+			MinBytes:       1,
+			MaxBytes:       1e6,
+			MaxWait:        500 * time.Millisecond,
+			CommitInterval: 0,
+		}),
+		Store:  store,
+		Logger: logger,
+		Retry:  retry,
+	}
+}
+
+// Run records messages until ctx is cancelled.
+func (r *DeadLetterRecorder) Run(ctx context.Context) error {
+	defer r.Reader.Close()
+	for {
+		m, err := r.Reader.FetchMessage(ctx)
+		if err != nil {
+			return err
+		}
+		e, err := decodeDeadLetter(m)
+		if err != nil {
+			// Not written by Send: there is no source to record it under.
+			r.Logger.Warn("dlq_message_unrecognized", zap.Int("partition", m.Partition),
+				zap.Int64("offset", m.Offset), zap.Error(err))
+		} else if err := r.record(ctx, e); err != nil {
+			return err
+		}
+		if err := r.Reader.CommitMessages(ctx, m); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *DeadLetterRecorder) record(ctx context.Context, e deadletter.Entry) error {
+	for attempt := 1; ; attempt++ {
+		err := r.Store.Record(ctx, e)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		wait := r.Retry.delay(attempt)
+		r.Logger.Warn("dlq_record_failed", zap.String("topic", e.Topic), zap.Int("partition", e.Partition),
+			zap.Int64("offset", e.Offset), zap.Int("attempt", attempt), zap.Duration("backoff", wait), zap.Error(err))
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeDeadLetter rebuilds the entry of a message Send wrote.
+func decodeDeadLetter(m kafka.Message) (deadletter.Entry, error) {
+	h := make(map[string]string, 6)
+	for _, kv := range m.Headers {
+		if strings.HasPrefix(kv.Key, "dlq-") {
+			h[kv.Key] = string(kv.Value)
+		}
+	}
+	topic, reason := h[HeaderDLQTopic], h[HeaderDLQReason]
+	if topic == "" || reason == "" {
+		return deadletter.Entry{}, errors.New("missing dlq-source-topic or dlq-reason header")
+	}
+	partition, err := strconv.Atoi(h[HeaderDLQPartition])
+	if err != nil {
+		return deadletter.Entry{}, fmt.Errorf("%s header: %w", HeaderDLQPartition, err)
+	}
+	offset, err := strconv.ParseInt(h[HeaderDLQOffset], 10, 64)
+	if err != nil {
+		return deadletter.Entry{}, fmt.Errorf("%s header: %w", HeaderDLQOffset, err)
+	}
+	return entryOf(m, topic, partition, offset, reason, h[HeaderDLQError]), nil
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/example/trades-aggregator/internal/deadletter"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestDeadLetterRoundTrip(t *testing.T) {
+	m := kafka.Message{
+		Topic:     "trades",
+		Partition: 3,
+		Offset:    1234,
+		Key:       []byte{0xff, 0x00, 'k'},
+		Value:     []byte{'{', 0xc3, 0x28, '}'}, // not UTF-8
+		Headers:   []kafka.Header{{Key: "trace", Value: []byte{0xfe, 0xed}}},
+	}
+	e, err := decodeDeadLetter(deadLetterMessage(m, deadletter.ReasonDecode, errors.New("bad json")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Topic != "trades" || e.Partition != 3 || e.Offset != 1234 {
+		t.Errorf("source = %s/%d/%d", e.Topic, e.Partition, e.Offset)
+	}
+	if e.Reason != deadletter.ReasonDecode || e.Error != "bad json" {
+		t.Errorf("reason, error = %q, %q", e.Reason, e.Error)
+	}
+	if !bytes.Equal(e.Key, m.Key) || !bytes.Equal(e.Payload, m.Value) {
+		t.Errorf("key, payload = %x, %x", e.Key, e.Payload)
+	}
+	if len(e.Headers) != 1 || e.Headers[0].Key != "trace" || !bytes.Equal(e.Headers[0].Value, []byte{0xfe, 0xed}) {
+		t.Errorf("headers = %+v, want only the original one", e.Headers)
+	}
+
+	// JSON (the admin API, the headers column) keeps every byte.
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back deadletter.Entry
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.Payload, m.Value) || !bytes.Equal(back.Headers[0].Value, []byte{0xfe, 0xed}) {
+		t.Errorf("JSON round trip lost bytes: %s", b)
+	}
+}
+
+func TestDecodeDeadLetterRejectsForeignMessages(t *testing.T) {
+	for _, headers := range [][]kafka.Header{
+		nil,
+		{{Key: HeaderDLQTopic, Value: []byte("trades")}},
+		{{Key: HeaderDLQTopic, Value: []byte("trades")}, {Key: HeaderDLQReason, Value: []byte("x")},
+			{Key: HeaderDLQPartition, Value: []byte("one")}, {Key: HeaderDLQOffset, Value: []byte("1")}},
+	} {
+		if _, err := decodeDeadLetter(kafka.Message{Value: []byte("{}"), Headers: headers}); err == nil {
+			t.Errorf("headers %v accepted", headers)
+		}
+	}
+}
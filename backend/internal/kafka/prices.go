@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/prices"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// PriceConsumer feeds quotes from the prices topic into the price store.
+// Quotes are superseded by the next one for the same symbol, so malformed
+// messages are logged and skipped rather than dead-lettered.
+type PriceConsumer struct {
+	Reader *kafka.Reader
+	Prices *prices.Service
+	Logger *zap.Logger
+	Batch  BatchPolicy
+}
+
+func NewPriceConsumer(brokers, topic, groupID string, batch BatchPolicy, svc *prices.Service, logger *zap.Logger) *PriceConsumer {
+	if batch.Size < 1 {
+		batch.Size = 1
+	}
+	return &PriceConsumer{
+		Reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  []string{brokers},
+			Topic:    topic,
+			GroupID:  groupID,
+			MinBytes: 1e3,
+			MaxBytes: 1e6,
+			MaxWait:  500 * time.Millisecond,
+		}),
+		Prices: svc,
+		Logger: logger,
+		Batch:  batch,
+	}
+}
+
+func (c *PriceConsumer) Run(ctx context.Context) error {
+	defer c.Reader.Close()
+	for {
+		msgs, err := fetchBatch(ctx, c.Reader, c.Batch)
+		if err != nil {
+			return err
+		}
+		quotes := make([]prices.Quote, 0, len(msgs))
+		for _, m := range msgs {
+			q, err := prices.DecodeQuote(m.Value)
+			if err != nil {
+				c.Logger.Warn("bad quote", zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset), zap.Error(err))
+				continue
+			}
+			quotes = append(quotes, q)
+		}
+		if err := c.Prices.Upsert(ctx, prices.SourceKafka, quotes); err != nil {
+			return fmt.Errorf("store %d quotes: %w", len(quotes), err)
+		}
+		if err := c.Reader.CommitMessages(ctx, msgs...); err != nil {
+			return err
+		}
+	}
+}
+
+// RunPriceConsumer consumes prices until ctx is cancelled. A consumer that
+// fails (the database or the broker is down) is replaced by a new one after
+// a backoff that grows with consecutive failures, per retry; offsets are only
+// committed once quotes are stored, so none is lost in between.
+func RunPriceConsumer(ctx context.Context, brokers, topic, groupID string, batch BatchPolicy, retry RetryPolicy, svc *prices.Service, logger *zap.Logger) {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := NewPriceConsumer(brokers, topic, groupID, batch, svc, logger).Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			attempt = 1 // it had been healthy: start backing off afresh
+		}
+		wait := retry.delay(attempt)
+		logger.Error("kafka_price_consumer_error", zap.Error(err), zap.Int("attempt", attempt), zap.Duration("restart_in", wait))
+		if sleep(ctx, wait) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ExhaustedAction decides what happens to a message whose transient failures
+// outlast the retry budget.
+type ExhaustedAction string
+
+const (
+	// ExhaustedStop stops the consumer without committing the offset, so the
+	// message is redelivered once the process restarts. This is the default:
+	// no trade is ever lost, at the cost of halting ingestion.
+	ExhaustedStop ExhaustedAction = "stop"
+	// ExhaustedSkip logs the message, commits past it and keeps consuming.
+	ExhaustedSkip ExhaustedAction = "skip"
+	// ExhaustedDeadLetter routes the message to the dead-letter queue, commits
+	// past it and keeps consuming; it can be re-driven once the DB recovers.
+	ExhaustedDeadLetter ExhaustedAction = "dead_letter"
+)
+
+func ParseExhaustedAction(s string) (ExhaustedAction, error) {
+	switch a := ExhaustedAction(s); a {
+	case ExhaustedStop, ExhaustedSkip, ExhaustedDeadLetter:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown retry exhausted action %q (use 'stop', 'skip' or 'dead_letter')", s)
+	}
+}
+
+// RetryPolicy bounds how long a transient DB failure is retried before the
+// OnExhausted action kicks in. Backoff doubles after every attempt up to
+// MaxBackoff.
+type RetryPolicy struct {
+	MaxRetries  int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	OnExhausted ExhaustedAction
+}
+
+// delay returns the wait before retry number attempt (1-based).
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
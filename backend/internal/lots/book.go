@@ -0,0 +1,184 @@
+package lots
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+// Method decides which open lot a reducing trade closes first. Specific
+// identification is not offered: trades carry no reference to the lots they
+// close, so every method picks lots by rule.
+type Method string
+
+const (
+	FIFO Method = "fifo" // oldest lot first
+	LIFO Method = "lifo" // newest lot first
+	// HIFO closes the highest-cost long lot (or lowest-proceeds short lot)
+	// first, which minimizes the realized gain.
+	HIFO Method = "hifo"
+)
+
+func ParseMethod(s string) (Method, error) {
+	switch m := Method(s); m {
+	case FIFO, LIFO, HIFO:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown lot method %q (use 'fifo', 'lifo' or 'hifo')", s)
+	}
+}
+
+// Lot is a position opened by a single trade. Quantity and Remaining are
+// signed: short lots are negative.
+type Lot struct {
+	ID             int64            `json:"id"`
+	Entity         string           `json:"entity"`
+	InstrumentType string           `json:"instrument_type"`
+	Symbol         string           `json:"symbol"`
+	OpenTradeID    string           `json:"open_trade_id"`
+	OpenedAt       time.Time        `json:"opened_at"`
+	Quantity       decimal.Decimal  `json:"quantity"`
+	Remaining      decimal.Decimal  `json:"remaining"`
+	Price          *decimal.Decimal `json:"price,omitempty"`
+	ClosedAt       *time.Time       `json:"closed_at,omitempty"`
+
+	dirty bool // remaining/closed_at changed since loaded
+}
+
+// Realization records quantity of one lot closed by one trade. Gain, in
+// money (scaled by the contract size), is nil when either side was booked
+// without a price.
+type Realization struct {
+	ID             int64            `json:"id"`
+	LotID          int64            `json:"lot_id"`
+	Entity         string           `json:"entity"`
+	InstrumentType string           `json:"instrument_type"`
+	Symbol         string           `json:"symbol"`
+	CloseTradeID   string           `json:"close_trade_id"`
+	Quantity       decimal.Decimal  `json:"quantity"`
+	OpenPrice      *decimal.Decimal `json:"open_price,omitempty"`
+	ClosePrice     *decimal.Decimal `json:"close_price,omitempty"`
+	OpenedAt       time.Time        `json:"opened_at"`
+	ClosedAt       time.Time        `json:"closed_at"`
+	Gain           *decimal.Decimal `json:"gain,omitempty"`
+
+	lot *Lot
+}
+
+// book is the lot state of one holding while trades are being applied.
+type book struct {
+	method   Method
+	open     []*Lot // lots with Remaining != 0, all on the same side
+	opened   []*Lot // lots created by this run, to be inserted
+	closed   []*Lot // lots loaded from the DB that changed, to be updated
+	realized []*Realization
+}
+
+// apply books t: it closes open lots on the other side according to the
+// method and opens a new lot with whatever quantity is left over.
+func (b *book) apply(t models.Trade) {
+	qty := t.Quantity
+	size := domain.ContractSize(t.InstrumentType, t.Symbol)
+	for !qty.IsZero() {
+		i := b.next(qty.Sign())
+		if i < 0 {
+			break
+		}
+		l := b.open[i]
+
+		// Close min(|qty|, |remaining|), expressed on the lot's side.
+		closing := l.Remaining
+		if qty.Abs().Cmp(l.Remaining.Abs()) < 0 {
+			closing = qty.Neg()
+		}
+		l.Remaining = l.Remaining.Sub(closing)
+		qty = qty.Add(closing)
+
+		r := &Realization{
+			Entity:         l.Entity,
+			InstrumentType: l.InstrumentType,
+			Symbol:         l.Symbol,
+			CloseTradeID:   t.TradeID,
+			Quantity:       closing,
+			OpenPrice:      l.Price,
+			ClosePrice:     t.Price,
+			OpenedAt:       l.OpenedAt,
+			ClosedAt:       t.TS,
+			lot:            l,
+		}
+		if l.Price != nil && t.Price != nil {
+			g := closing.Mul(t.Price.Sub(*l.Price)).Mul(size)
+			r.Gain = &g
+		}
+		b.realized = append(b.realized, r)
+
+		if l.Remaining.IsZero() {
+			ts := t.TS
+			l.ClosedAt = &ts
+			b.open = append(b.open[:i], b.open[i+1:]...)
+		}
+		if l.ID != 0 && !l.dirty {
+			l.dirty = true
+			b.closed = append(b.closed, l)
+		}
+	}
+
+	if !qty.IsZero() {
+		l := &Lot{
+			Entity:         t.Entity,
+			InstrumentType: t.InstrumentType,
+			Symbol:         t.Symbol,
+			OpenTradeID:    t.TradeID,
+			OpenedAt:       t.TS,
+			Quantity:       qty,
+			Remaining:      qty,
+			Price:          t.Price,
+		}
+		b.open = append(b.open, l)
+		b.opened = append(b.opened, l)
+	}
+}
+
+// next returns the index of the open lot a trade of the given sign should
+// close, or -1 when there is none on the other side.
+func (b *book) next(sign int) int {
+	best := -1
+	for i, l := range b.open {
+		if l.Remaining.Sign() == sign {
+			continue
+		}
+		if best < 0 || b.before(l, b.open[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// before reports whether lot a should be closed ahead of lot b.
+func (b *book) before(a, c *Lot) bool {
+	switch b.method {
+	case LIFO:
+		return a.OpenedAt.After(c.OpenedAt)
+	case HIFO:
+		pa, pc := priceOrZero(a), priceOrZero(c)
+		if cmp := pa.Cmp(pc); cmp != 0 {
+			if a.Remaining.Sign() > 0 {
+				return cmp > 0 // long: highest cost first
+			}
+			return cmp < 0 // short: lowest proceeds first
+		}
+		return a.OpenedAt.Before(c.OpenedAt)
+	default:
+		return a.OpenedAt.Before(c.OpenedAt)
+	}
+}
+
+func priceOrZero(l *Lot) decimal.Decimal {
+	if l.Price == nil {
+		return decimal.Zero
+	}
+	return *l.Price
+}
@@ -0,0 +1,218 @@
+package lots
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+func day(n int) time.Time { return time.Date(2024, 3, n, 10, 0, 0, 0, time.UTC) }
+
+// trade books qty at price ("" for none) on day n.
+func trade(id string, n int, qty, price string) models.Trade {
+	t := models.Trade{
+		TradeID:        id,
+		Entity:         "zurich",
+		InstrumentType: "stock",
+		Symbol:         "AAPL",
+		Quantity:       decimal.MustParse(qty),
+		TS:             day(n),
+	}
+	if price != "" {
+		px := decimal.MustParse(price)
+		t.Price = &px
+	}
+	return t
+}
+
+// realized describes one realization: the trade that opened the lot, the
+// quantity closed on the lot's side, the gain ("" for none) and the days the
+// lot was opened and closed.
+type realized struct {
+	lot          string
+	qty, gain    string
+	opened, shut int
+}
+
+func (r realized) String() string {
+	return fmt.Sprintf("%s %s gain %s day %d-%d", r.lot, r.qty, r.gain, r.opened, r.shut)
+}
+
+// remaining describes an open lot after the run.
+type remaining struct{ lot, qty string }
+
+func run(method Method, trades []models.Trade) *book {
+	b := &book{method: method}
+	for _, t := range trades {
+		b.apply(t)
+	}
+	return b
+}
+
+func check(t *testing.T, b *book, wantRealized []realized, wantOpen []remaining) {
+	t.Helper()
+	var got []realized
+	for _, r := range b.realized {
+		gain := ""
+		if r.Gain != nil {
+			gain = r.Gain.String()
+		}
+		if !r.OpenedAt.Equal(r.lot.OpenedAt) {
+			t.Errorf("%s: opened_at %s differs from its lot's %s", r.CloseTradeID, r.OpenedAt, r.lot.OpenedAt)
+		}
+		got = append(got, realized{r.lot.OpenTradeID, r.Quantity.String(), gain, r.OpenedAt.Day(), r.ClosedAt.Day()})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(wantRealized) {
+		t.Errorf("realized\n got %v\nwant %v", got, wantRealized)
+	}
+
+	var open []remaining
+	for _, l := range b.open {
+		open = append(open, remaining{l.OpenTradeID, l.Remaining.String()})
+		if l.ClosedAt != nil {
+			t.Errorf("open lot %s has closed_at %s", l.OpenTradeID, l.ClosedAt)
+		}
+	}
+	if fmt.Sprint(open) != fmt.Sprint(wantOpen) {
+		t.Errorf("open lots %v, want %v", open, wantOpen)
+	}
+	for _, l := range b.opened {
+		if l.Remaining.IsZero() && (l.ClosedAt == nil || !l.ClosedAt.Equal(closedBy(b, l))) {
+			t.Errorf("closed lot %s has closed_at %v", l.OpenTradeID, l.ClosedAt)
+		}
+	}
+}
+
+// closedBy returns when the last realization of l happened.
+func closedBy(b *book, l *Lot) time.Time {
+	var at time.Time
+	for _, r := range b.realized {
+		if r.lot == l {
+			at = r.ClosedAt
+		}
+	}
+	return at
+}
+
+func TestReliefLong(t *testing.T) {
+	trades := []models.Trade{
+		trade("b1", 1, "10", "100"),
+		trade("b2", 2, "10", "120"),
+		trade("b3", 3, "10", "110"),
+		trade("s1", 4, "-15", "130"),
+	}
+	tests := []struct {
+		method   Method
+		realized []realized
+		open     []remaining
+	}{
+		{FIFO, []realized{{"b1", "10", "300", 1, 4}, {"b2", "5", "50", 2, 4}}, []remaining{{"b2", "5"}, {"b3", "10"}}},
+		{LIFO, []realized{{"b3", "10", "200", 3, 4}, {"b2", "5", "50", 2, 4}}, []remaining{{"b1", "10"}, {"b2", "5"}}},
+		{HIFO, []realized{{"b2", "10", "100", 2, 4}, {"b3", "5", "100", 3, 4}}, []remaining{{"b1", "10"}, {"b3", "5"}}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			check(t, run(tt.method, trades), tt.realized, tt.open)
+		})
+	}
+}
+
+func TestReliefShort(t *testing.T) {
+	trades := []models.Trade{
+		trade("s1", 1, "-10", "50"),
+		trade("s2", 2, "-10", "40"),
+		trade("s3", 3, "-10", "45"),
+		trade("b1", 4, "15", "45"),
+	}
+	tests := []struct {
+		method   Method
+		realized []realized
+		open     []remaining
+	}{
+		{FIFO, []realized{{"s1", "-10", "50", 1, 4}, {"s2", "-5", "-25", 2, 4}}, []remaining{{"s2", "-5"}, {"s3", "-10"}}},
+		{LIFO, []realized{{"s3", "-10", "0", 3, 4}, {"s2", "-5", "-25", 2, 4}}, []remaining{{"s1", "-10"}, {"s2", "-5"}}},
+		// Lowest proceeds first: the short sold at 40 is the costliest to keep.
+		{HIFO, []realized{{"s2", "-10", "-50", 2, 4}, {"s3", "-5", "0", 3, 4}}, []remaining{{"s1", "-10"}, {"s3", "-5"}}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			check(t, run(tt.method, trades), tt.realized, tt.open)
+		})
+	}
+}
+
+func TestReliefPartialCloses(t *testing.T) {
+	b := run(FIFO, []models.Trade{
+		trade("b1", 1, "10", "100"),
+		trade("s1", 2, "-3", "110"),
+		trade("s2", 3, "-3", "90"),
+		trade("s3", 4, "-4", "100"),
+	})
+	check(t, b, []realized{
+		{"b1", "3", "30", 1, 2},
+		{"b1", "3", "-30", 1, 3},
+		{"b1", "4", "0", 1, 4},
+	}, nil)
+	if l := b.opened[0]; l.ClosedAt == nil || !l.ClosedAt.Equal(day(4)) {
+		t.Errorf("b1 closed_at %v, want day 4", l.ClosedAt)
+	}
+}
+
+func TestReliefThroughZero(t *testing.T) {
+	trades := []models.Trade{
+		trade("b1", 1, "4", "100"),
+		trade("b2", 2, "6", "100"),
+		trade("s1", 3, "-15", "90"),
+		trade("b3", 4, "2", "80"),
+	}
+	b1 := realized{"b1", "4", "-40", 1, 3}
+	b2 := realized{"b2", "6", "-60", 2, 3}
+	short := realized{"s1", "-2", "20", 3, 4}
+	// Both long lots close, the rest of s1 opens a short lot and b3 partly
+	// covers it. HIFO breaks the tie on cost by date.
+	for m, order := range map[Method][]realized{
+		FIFO: {b1, b2, short},
+		LIFO: {b2, b1, short},
+		HIFO: {b1, b2, short},
+	} {
+		t.Run(string(m), func(t *testing.T) {
+			check(t, run(m, trades), order, []remaining{{"s1", "-3"}})
+		})
+	}
+}
+
+func TestReliefWithoutPrice(t *testing.T) {
+	b := run(FIFO, []models.Trade{
+		trade("b1", 1, "5", ""),
+		trade("b2", 2, "5", "10"),
+		trade("s1", 3, "-10", "12"),
+		trade("b3", 4, "1", "12"),
+		trade("s2", 5, "-1", ""),
+	})
+	check(t, b, []realized{
+		{"b1", "5", "", 1, 3},
+		{"b2", "5", "10", 2, 3},
+		{"b3", "1", "", 4, 5},
+	}, nil)
+}
+
+func TestReliefLoadedLots(t *testing.T) {
+	px := decimal.MustParse("100")
+	loaded := &Lot{ID: 7, OpenTradeID: "b0", OpenedAt: day(1), Quantity: decimal.MustParse("10"), Remaining: decimal.MustParse("10"), Price: &px}
+	b := &book{method: FIFO, open: []*Lot{loaded}}
+	b.apply(trade("s1", 2, "-4", "105"))
+	b.apply(trade("s2", 3, "-6", "95"))
+	if len(b.closed) != 1 || b.closed[0] != loaded {
+		t.Fatalf("updated lots %v, want only the loaded lot once", b.closed)
+	}
+	if len(b.opened) != 0 {
+		t.Errorf("opened %d lots", len(b.opened))
+	}
+	check(t, b, []realized{{"b0", "4", "20", 1, 2}, {"b0", "6", "-30", 1, 3}}, nil)
+	if loaded.ClosedAt == nil || !loaded.ClosedAt.Equal(day(3)) {
+		t.Errorf("closed_at %v, want day 3", loaded.ClosedAt)
+	}
+}
@@ -0,0 +1,332 @@
+// Package lots keeps tax-lot positions alongside holdings: every opening
+// trade creates a lot and every reducing trade closes lots according to the
+// entity's relief method, producing realized gain records.
+package lots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultMethod applies to entities without a configured method.
+const DefaultMethod = FIFO
+
+type Service struct{ DB *pgxpool.Pool }
+
+func New(db *pgxpool.Pool) *Service { return &Service{DB: db} }
+
+// NewHoldings returns the holdings service with the lot ledger hooked in,
+// which is how every process that books trades must build it, and the lots
+// service itself.
+func NewHoldings(db *pgxpool.Pool) (*holdings.Service, *Service) {
+	lotsSvc := New(db)
+	svc := holdings.New(db)
+	svc.Hooks = append(svc.Hooks, lotsSvc)
+	return svc, lotsSvc
+}
+
+// TradesApplied implements holdings.TxHook.
+func (s *Service) TradesApplied(ctx context.Context, tx pgx.Tx, trades []models.Trade) error {
+	if len(trades) == 0 {
+		return nil
+	}
+	byKey := make(map[holdings.Key][]models.Trade)
+	for _, t := range trades {
+		k := holdings.KeyOf(t)
+		byKey[k] = append(byKey[k], t)
+	}
+	keys := holdings.SortedKeys(byKey)
+
+	methods, err := loadMethods(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		open, err := loadOpenLots(ctx, tx, k)
+		if err != nil {
+			return err
+		}
+		b := &book{method: methodFor(methods, k.Entity), open: open}
+		for _, t := range byKey[k] {
+			b.apply(t)
+		}
+		if err := save(ctx, tx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild discards the lots of entity (every entity when empty) and replays
+// its booked trades in arrival order with the currently configured methods. It
+// returns the number of trades replayed.
+func (s *Service) Rebuild(ctx context.Context, entity string) (int, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	n, err := rebuild(ctx, tx, entity)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+func rebuild(ctx context.Context, tx pgx.Tx, entity string) (int, error) {
+	// Block live booking until the rebuilt lots are committed.
+	if _, err := tx.Exec(ctx, `LOCK TABLE tax_lots, lot_realizations IN EXCLUSIVE MODE`); err != nil {
+		return 0, err
+	}
+	return replay(ctx, tx, `$1 = '' OR entity::text = $1`, entity)
+}
+
+// HoldingsReplayed implements holdings.ReplayHook: the lots of each holding
+// an amend or cancel touched are booked again from its trades. The caller
+// holds the holdings' row locks, which keeps live booking of the same
+// holdings out.
+func (s *Service) HoldingsReplayed(ctx context.Context, tx pgx.Tx, keys []holdings.Key) error {
+	for _, k := range keys {
+		if _, err := replay(ctx, tx, `entity = $1 AND instrument_type = $2::instrument_type AND symbol = $3`,
+			k.Entity, k.InstrumentType, k.Symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay discards the lots matching cond and books the trades matching it
+// again, in arrival order. Cancelled trades are skipped.
+func replay(ctx context.Context, tx pgx.Tx, cond string, args ...any) (int, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM tax_lots WHERE `+cond, args...); err != nil {
+		return 0, err
+	}
+
+	methods, err := loadMethods(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT trade_id::text, entity::text, instrument_type::text, symbol, quantity, price, ts
+		FROM trades WHERE event_type <> 'cancel' AND (`+cond+`)
+		ORDER BY id
+	`, args...)
+	if err != nil {
+		return 0, err
+	}
+	books := make(map[holdings.Key]*book)
+	n := 0
+	for rows.Next() {
+		var t models.Trade
+		if err := rows.Scan(&t.TradeID, &t.Entity, &t.InstrumentType, &t.Symbol, &t.Quantity, &t.Price, &t.TS); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		k := holdings.KeyOf(t)
+		b := books[k]
+		if b == nil {
+			b = &book{method: methodFor(methods, k.Entity)}
+			books[k] = b
+		}
+		b.apply(t)
+		n++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, k := range holdings.SortedKeys(books) {
+		if err := save(ctx, tx, books[k]); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Methods returns the configured relief method per entity.
+func (s *Service) Methods(ctx context.Context) (map[string]Method, error) {
+	return loadMethods(ctx, s.DB)
+}
+
+// SetMethod changes entity's relief method and rebuilds its lots so that
+// history is consistent with the new method.
+func (s *Service) SetMethod(ctx context.Context, entity string, m Method) error {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO lot_methods (entity, method) VALUES ($1, $2::lot_method)
+		ON CONFLICT (entity) DO UPDATE SET method = EXCLUDED.method, updated_at = now()
+	`, entity, string(m)); err != nil {
+		return err
+	}
+	if _, err := rebuild(ctx, tx, entity); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// OpenLots returns entity's open lots in symbol, oldest first.
+func (s *Service) OpenLots(ctx context.Context, entity, symbol string) ([]Lot, error) {
+	rows, err := s.DB.Query(ctx, `
+		SELECT `+lotColumns+` FROM tax_lots
+		WHERE entity = $1 AND symbol = $2 AND remaining <> 0
+		ORDER BY opened_at, id
+	`, entity, symbol)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanLot)
+}
+
+// Realizations returns entity's closed-lot realizations in symbol, most
+// recent first.
+func (s *Service) Realizations(ctx context.Context, entity, symbol string, limit int) ([]Realization, error) {
+	rows, err := s.DB.Query(ctx, `
+		SELECT id, lot_id, entity::text, instrument_type::text, symbol, close_trade_id::text, quantity,
+		       open_price, close_price, opened_at, closed_at, gain
+		FROM lot_realizations
+		WHERE entity = $1 AND symbol = $2
+		ORDER BY closed_at DESC, id DESC
+		LIMIT $3
+	`, entity, symbol, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Realization, error) {
+		var r Realization
+		err := row.Scan(&r.ID, &r.LotID, &r.Entity, &r.InstrumentType, &r.Symbol, &r.CloseTradeID, &r.Quantity,
+			&r.OpenPrice, &r.ClosePrice, &r.OpenedAt, &r.ClosedAt, &r.Gain)
+		return r, err
+	})
+}
+
+// --- persistence ---
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+const lotColumns = `id, entity::text, instrument_type::text, symbol, open_trade_id::text, opened_at,
+	quantity, remaining, price, closed_at`
+
+func scanLot(row pgx.CollectableRow) (Lot, error) {
+	var l Lot
+	err := row.Scan(&l.ID, &l.Entity, &l.InstrumentType, &l.Symbol, &l.OpenTradeID, &l.OpenedAt,
+		&l.Quantity, &l.Remaining, &l.Price, &l.ClosedAt)
+	return l, err
+}
+
+func loadMethods(ctx context.Context, q querier) (map[string]Method, error) {
+	rows, err := q.Query(ctx, `SELECT entity::text, method::text FROM lot_methods`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]Method)
+	for rows.Next() {
+		var e, m string
+		if err := rows.Scan(&e, &m); err != nil {
+			return nil, err
+		}
+		out[e] = Method(m)
+	}
+	return out, rows.Err()
+}
+
+func methodFor(methods map[string]Method, entity string) Method {
+	if m, ok := methods[entity]; ok {
+		return m
+	}
+	return DefaultMethod
+}
+
+func loadOpenLots(ctx context.Context, tx pgx.Tx, k holdings.Key) ([]*Lot, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT `+lotColumns+` FROM tax_lots
+		WHERE entity = $1 AND instrument_type = $2::instrument_type AND symbol = $3 AND remaining <> 0
+		ORDER BY id
+		FOR UPDATE
+	`, k.Entity, k.InstrumentType, k.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Lot, error) {
+		l, err := scanLot(row)
+		return &l, err
+	})
+}
+
+// save writes the changes of b: updated lots, new lots (assigning their IDs)
+// and then the realizations that reference them.
+func save(ctx context.Context, tx pgx.Tx, b *book) error {
+	batch := &pgx.Batch{}
+	for _, l := range b.closed {
+		batch.Queue(`UPDATE tax_lots SET remaining = $2, closed_at = $3 WHERE id = $1`, l.ID, l.Remaining, l.ClosedAt)
+	}
+	for _, l := range b.opened {
+		batch.Queue(`
+			INSERT INTO tax_lots (entity, instrument_type, symbol, open_trade_id, opened_at, quantity, remaining, price, closed_at)
+			VALUES ($1, $2::instrument_type, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id
+		`, l.Entity, l.InstrumentType, l.Symbol, l.OpenTradeID, l.OpenedAt, l.Quantity, l.Remaining, l.Price, l.ClosedAt)
+	}
+	br := tx.SendBatch(ctx, batch)
+	for range b.closed {
+		if _, err := br.Exec(); err != nil {
+			_ = br.Close()
+			return err
+		}
+	}
+	for _, l := range b.opened {
+		if err := br.QueryRow().Scan(&l.ID); err != nil {
+			_ = br.Close()
+			return err
+		}
+	}
+	if err := br.Close(); err != nil {
+		return err
+	}
+
+	if len(b.realized) == 0 {
+		return nil
+	}
+	batch = &pgx.Batch{}
+	for _, r := range b.realized {
+		if r.lot.ID == 0 {
+			return errors.New("lots: realization references an unsaved lot")
+		}
+		batch.Queue(`
+			INSERT INTO lot_realizations (lot_id, entity, instrument_type, symbol, close_trade_id, quantity,
+			                              open_price, close_price, opened_at, closed_at, gain)
+			VALUES ($1, $2, $3::instrument_type, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, r.lot.ID, r.Entity, r.InstrumentType, r.Symbol, r.CloseTradeID, r.Quantity,
+			r.OpenPrice, r.ClosePrice, r.OpenedAt, r.ClosedAt, roundGain(r.Gain))
+	}
+	br = tx.SendBatch(ctx, batch)
+	for range b.realized {
+		if _, err := br.Exec(); err != nil {
+			_ = br.Close()
+			return fmt.Errorf("insert realization: %w", err)
+		}
+	}
+	return br.Close()
+}
+
+func roundGain(g *decimal.Decimal) *decimal.Decimal {
+	if g == nil {
+		return nil
+	}
+	r := g.Round(16)
+	return &r
+}
@@ -1,20 +1,98 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+)
 
 type Trade struct {
-	TradeID        string    `json:"trade_id"`
-	Entity         string    `json:"entity"`
-	InstrumentType string    `json:"instrument_type"`
-	Symbol         string    `json:"symbol"`
-	Quantity       float64   `json:"quantity"`
-	Price          *float64  `json:"price,omitempty"`
-	TS             time.Time `json:"ts"`
+	TradeID        string           `json:"trade_id"`
+	Entity         string           `json:"entity"`
+	InstrumentType string           `json:"instrument_type"`
+	Symbol         string           `json:"symbol"`
+	Quantity       decimal.Decimal  `json:"quantity"`
+	Price          *decimal.Decimal `json:"price,omitempty"`
+	TS             time.Time        `json:"ts"`
+
+	// Event is what the message does to trade_id: book it (new, the
+	// default), replace its fields (amend) or void it (cancel). Version
+	// numbers a trade's versions from 1; an amend or cancel carries the
+	// version it creates.
+	Event   string `json:"event_type,omitempty"`
+	Version int    `json:"version,omitempty"`
+
+	// Origin is where the trade came from; set by the ingest path, not
+	// part of the message.
+	Origin *Origin `json:"-"`
 }
 
+// Trade events.
+const (
+	EventNew    = "new"
+	EventAmend  = "amend"
+	EventCancel = "cancel"
+)
+
+// IsCorrection reports whether t amends or cancels an existing trade.
+func (t Trade) IsCorrection() bool { return t.Event == EventAmend || t.Event == EventCancel }
+
 type Holding struct {
-	Entity         string  `json:"entity"`
-	InstrumentType string  `json:"instrument_type"`
-	Symbol         string  `json:"symbol"`
-	Quantity       float64 `json:"quantity"`
+	Entity         string          `json:"entity"`
+	InstrumentType string          `json:"instrument_type"`
+	Symbol         string          `json:"symbol"`
+	Quantity       decimal.Decimal `json:"quantity"`
+	AvgCost        decimal.Decimal `json:"avg_cost"`
+	CostBasis      decimal.Decimal `json:"cost_basis"`
+	RealizedPnL    decimal.Decimal `json:"realized_pnl"`
+	Valuation      *Valuation      `json:"valuation,omitempty"`
+}
+
+// Valuation marks a holding to the last known market price. It is absent
+// when no price has been seen for the symbol. MarketValue is the notional,
+// quantity × price × contract size (see domain.ContractSize).
+type Valuation struct {
+	Price         decimal.Decimal `json:"price"`
+	PriceTS       time.Time       `json:"price_ts"`
+	Stale         bool            `json:"stale"`
+	MarketValue   decimal.Decimal `json:"market_value"`
+	UnrealizedPnL decimal.Decimal `json:"unrealized_pnl"`
+}
+
+// Trade sources.
+const (
+	SourceKafka   = "kafka"
+	SourceRedrive = "dlq_redrive"
+	SourceHTTP    = "http"
+	SourceCSV     = "csv_import"
+)
+
+// Origin records how a trade reached the backend. Topic, Partition and
+// Offset are set for trades consumed from (or re-driven from) Kafka.
+type Origin struct {
+	Source     string    `json:"source"`
+	Topic      string    `json:"topic,omitempty"`
+	Partition  *int      `json:"partition,omitempty"`
+	Offset     *int64    `json:"offset,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// TradeVersion is one recorded version of a trade and how it arrived.
+type TradeVersion struct {
+	Trade      Trade      `json:"trade"`
+	Processing Processing `json:"processing"`
+}
+
+// Processing is the ingest record of a stored trade. Trades recorded before
+// it was kept only have the duplicate counters.
+type Processing struct {
+	Source          string     `json:"source,omitempty"`
+	Topic           string     `json:"topic,omitempty"`
+	Partition       *int       `json:"partition,omitempty"`
+	Offset          *int64     `json:"offset,omitempty"`
+	ReceivedAt      *time.Time `json:"received_at,omitempty"`
+	AppliedAt       *time.Time `json:"applied_at,omitempty"`
+	Duplicate       bool       `json:"duplicate"`
+	DuplicateCount  int        `json:"duplicate_count"`
+	LastDuplicateAt *time.Time `json:"last_duplicate_at,omitempty"`
 }
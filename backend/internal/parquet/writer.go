@@ -0,0 +1,225 @@
+// Package parquet writes the flat Parquet files of the exports with
+// github.com/xitongsys/parquet-go. It maps export columns (strings,
+// integers, booleans, timestamps and decimals) to a Parquet schema and row
+// values to what the library expects, and cuts row groups by row count so
+// memory stays bounded however large the export.
+package parquet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+)
+
+// Kind is the type of a column.
+type Kind int
+
+const (
+	String    Kind = iota // UTF-8 BYTE_ARRAY
+	Int64                 // INT64
+	Bool                  // BOOLEAN
+	Timestamp             // INT64 microseconds since the epoch, UTC
+	Decimal               // BYTE_ARRAY DECIMAL(Precision, Scale)
+)
+
+// Column describes one column. Only Optional columns accept nulls.
+type Column struct {
+	Name      string
+	Kind      Kind
+	Optional  bool
+	Precision int // Decimal only
+	Scale     int // Decimal only
+}
+
+// metadata is the library's schema tag for the column.
+func (c Column) metadata() string {
+	rep := "REQUIRED"
+	if c.Optional {
+		rep = "OPTIONAL"
+	}
+	var typ string
+	switch c.Kind {
+	case String:
+		typ = "type=BYTE_ARRAY, convertedtype=UTF8"
+	case Int64:
+		typ = "type=INT64"
+	case Bool:
+		typ = "type=BOOLEAN"
+	case Timestamp:
+		typ = "type=INT64, convertedtype=TIMESTAMP_MICROS"
+	case Decimal:
+		typ = fmt.Sprintf("type=BYTE_ARRAY, convertedtype=DECIMAL, precision=%d, scale=%d", c.Precision, c.Scale)
+	}
+	return fmt.Sprintf("name=%s, %s, repetitiontype=%s", c.Name, typ, rep)
+}
+
+// DefaultRowGroupRows is how many rows are buffered before a row group is
+// written out.
+const DefaultRowGroupRows = 65536
+
+// Writer streams rows into a Parquet file. Rows are buffered per row group,
+// so memory is bounded by RowGroupRows regardless of the file size.
+type Writer struct {
+	RowGroupRows int
+
+	pw   *writer.CSVWriter
+	cols []Column
+	rows int // rows in the current row group
+	err  error
+}
+
+// NewWriter returns a writer of rows with the given columns to w. Close
+// must be called to complete the file.
+func NewWriter(w io.Writer, cols []Column) *Writer {
+	md := make([]string, len(cols))
+	for i, c := range cols {
+		if strings.ContainsAny(c.Name, ",=") {
+			return &Writer{err: fmt.Errorf("parquet: invalid column name %q", c.Name)}
+		}
+		md[i] = c.metadata()
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		err = fmt.Errorf("parquet: %w", err)
+	}
+	return &Writer{RowGroupRows: DefaultRowGroupRows, pw: pw, cols: cols, err: err}
+}
+
+// Write appends a row: one value per column, in order. Values are string,
+// int64, int, bool, time.Time, decimal.Decimal, pointers to those, or nil
+// for null.
+func (w *Writer) Write(row ...any) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(row) != len(w.cols) {
+		return fmt.Errorf("parquet: row has %d values, want %d", len(row), len(w.cols))
+	}
+	// The library keeps the row until its row group is written, so it
+	// gets a slice of its own.
+	rec := make([]any, len(row))
+	for i, v := range row {
+		var err error
+		if rec[i], err = value(w.cols[i], deref(v)); err != nil {
+			return fmt.Errorf("parquet: column %s: %w", w.cols[i].Name, err)
+		}
+	}
+	if w.err = w.pw.Write(rec); w.err != nil {
+		return w.err
+	}
+	w.rows++
+	if w.rows >= w.RowGroupRows {
+		w.rows = 0
+		w.err = w.pw.Flush(true)
+	}
+	return w.err
+}
+
+func deref(v any) any {
+	switch p := v.(type) {
+	case *string:
+		if p != nil {
+			return *p
+		}
+	case *int64:
+		if p != nil {
+			return *p
+		}
+	case *bool:
+		if p != nil {
+			return *p
+		}
+	case *time.Time:
+		if p != nil {
+			return *p
+		}
+	case *decimal.Decimal:
+		if p != nil {
+			return *p
+		}
+	default:
+		return v
+	}
+	return nil
+}
+
+// value converts v to the library's value for col: string for BYTE_ARRAY,
+// int64 for INT64 and bool for BOOLEAN.
+func value(col Column, v any) (any, error) {
+	if v == nil {
+		if !col.Optional {
+			return nil, errors.New("null in a required column")
+		}
+		return nil, nil
+	}
+	switch col.Kind {
+	case String:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("want string, got %T", v)
+	case Int64:
+		switch x := v.(type) {
+		case int64:
+			return x, nil
+		case int:
+			return int64(x), nil
+		}
+		return nil, fmt.Errorf("want integer, got %T", v)
+	case Bool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("want bool, got %T", v)
+	case Timestamp:
+		if t, ok := v.(time.Time); ok {
+			return t.UnixMicro(), nil
+		}
+		return nil, fmt.Errorf("want time.Time, got %T", v)
+	case Decimal:
+		if d, ok := v.(decimal.Decimal); ok {
+			return string(twosComplement(d.Unscaled(int32(col.Scale)))), nil
+		}
+		return nil, fmt.Errorf("want decimal, got %T", v)
+	}
+	return nil, fmt.Errorf("unknown column kind %d", col.Kind)
+}
+
+// twosComplement returns the minimal big-endian two's complement bytes of v,
+// the encoding of a BYTE_ARRAY decimal.
+func twosComplement(v *big.Int) []byte {
+	if v.Sign() >= 0 {
+		b := v.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+	// -v fits in n bytes; 2^(8n) + v is its n-byte two's complement.
+	n := (new(big.Int).Not(v).BitLen())/8 + 1
+	m := new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+	b := m.Add(m, v).Bytes()
+	for len(b) < n {
+		b = append([]byte{0xff}, b...)
+	}
+	return b
+}
+
+// Close writes the remaining rows and the file footer. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.pw.WriteStop(); err != nil {
+		w.err = fmt.Errorf("parquet: %w", err)
+	}
+	return w.err
+}
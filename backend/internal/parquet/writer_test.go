@@ -0,0 +1,263 @@
+package parquet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+)
+
+// memFile serves a written file to the reader.
+type memFile struct{ *bytes.Reader }
+
+func (f memFile) Write([]byte) (int, error) { return 0, errors.New("read only") }
+func (f memFile) Close() error              { return nil }
+func (f memFile) Open(string) (source.ParquetFile, error) {
+	return memFile{bytes.NewReader(readAll(f.Reader))}, nil
+}
+func (f memFile) Create(string) (source.ParquetFile, error) { return nil, errors.New("read only") }
+
+func readAll(r *bytes.Reader) []byte {
+	b := make([]byte, r.Size())
+	_, _ = r.ReadAt(b, 0)
+	return b
+}
+
+var testColumns = []Column{
+	{Name: "trade_id", Kind: String},
+	{Name: "note", Kind: String, Optional: true},
+	{Name: "version", Kind: Int64},
+	{Name: "amended", Kind: Bool},
+	{Name: "settled", Kind: Bool, Optional: true},
+	{Name: "ts", Kind: Timestamp},
+	{Name: "quantity", Kind: Decimal, Precision: 20, Scale: 8},
+	{Name: "price", Kind: Decimal, Precision: 20, Scale: 8, Optional: true},
+}
+
+type testRow struct {
+	id       string
+	note     *string
+	version  int64
+	amended  bool
+	settled  *bool
+	ts       time.Time
+	quantity string
+	price    *string
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func testRows(n int) []testRow {
+	base := time.Date(2024, 3, 1, 9, 30, 0, 123456000, time.UTC)
+	quantities := []string{"10", "-0.5", "0", "-123456789012.12345678", "0.00000001", "-1", "127", "-128", "255", "-256"}
+	rows := make([]testRow, n)
+	for i := range rows {
+		r := testRow{
+			id:       "t" + string(rune('a'+i%26)),
+			version:  int64(i + 1),
+			amended:  i%3 == 0,
+			ts:       base.Add(time.Duration(i) * time.Minute),
+			quantity: quantities[i%len(quantities)],
+		}
+		if i%4 != 1 {
+			r.note = ptr("row ✓ " + quantities[i%len(quantities)])
+		}
+		if i%5 != 2 {
+			r.settled = ptr(i%2 == 0)
+		}
+		if i%7 != 3 {
+			r.price = ptr([]string{"189.25", "-0.01", "99999999999.99999999"}[i%3])
+		}
+		rows[i] = r
+	}
+	return rows
+}
+
+func write(t *testing.T, rows []testRow, groupRows int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, testColumns)
+	w.RowGroupRows = groupRows
+	for _, r := range rows {
+		var price *decimal.Decimal
+		if r.price != nil {
+			price = ptr(decimal.MustParse(*r.price))
+		}
+		if err := w.Write(r.id, r.note, r.version, r.amended, r.settled, r.ts, decimal.MustParse(r.quantity), price); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestRoundTrip writes files and reads them back column by column.
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		rows      int
+		groupRows int
+		groups    int
+	}{
+		{"empty", 0, DefaultRowGroupRows, 0},
+		{"one row", 1, DefaultRowGroupRows, 1},
+		{"one group", 50, DefaultRowGroupRows, 1},
+		{"several groups", 50, 8, 7},
+		{"full groups", 48, 8, 6},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := testRows(tt.rows)
+			data := write(t, rows, tt.groupRows)
+			pr, err := reader.NewParquetColumnReader(memFile{bytes.NewReader(data)}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pr.ReadStop()
+
+			if got := pr.GetNumRows(); got != int64(tt.rows) {
+				t.Fatalf("%d rows, want %d", got, tt.rows)
+			}
+			if got := len(pr.Footer.RowGroups); got != tt.groups {
+				t.Errorf("%d row groups, want %d", got, tt.groups)
+			}
+			checkSchema(t, pr)
+			if tt.rows == 0 {
+				return
+			}
+
+			n := int64(tt.rows)
+			col := func(name string) []any {
+				t.Helper()
+				values, _, _, err := pr.ReadColumnByPath(pr.SchemaHandler.GetRootExName()+common.PAR_GO_PATH_DELIMITER+name, n)
+				if err != nil {
+					t.Fatalf("column %s: %v", name, err)
+				}
+				if len(values) != tt.rows {
+					t.Fatalf("column %s: %d values, want %d", name, len(values), tt.rows)
+				}
+				return values
+			}
+			ids, notes, versions := col("trade_id"), col("note"), col("version")
+			amended, settled, ts := col("amended"), col("settled"), col("ts")
+			quantities, prices := col("quantity"), col("price")
+
+			for i, r := range rows {
+				if ids[i] != r.id {
+					t.Errorf("row %d trade_id = %v, want %s", i, ids[i], r.id)
+				}
+				if !sameOptional(notes[i], r.note) {
+					t.Errorf("row %d note = %v, want %v", i, notes[i], deref(r.note))
+				}
+				if versions[i] != r.version {
+					t.Errorf("row %d version = %v, want %d", i, versions[i], r.version)
+				}
+				if amended[i] != r.amended {
+					t.Errorf("row %d amended = %v, want %v", i, amended[i], r.amended)
+				}
+				if !sameOptional(settled[i], r.settled) {
+					t.Errorf("row %d settled = %v, want %v", i, settled[i], deref(r.settled))
+				}
+				if ts[i] != r.ts.UnixMicro() {
+					t.Errorf("row %d ts = %v, want %d", i, ts[i], r.ts.UnixMicro())
+				}
+				if got := decimalValue(t, quantities[i]); got == nil || !got.Equal(decimal.MustParse(r.quantity)) {
+					t.Errorf("row %d quantity = %v, want %s", i, got, r.quantity)
+				}
+				got := decimalValue(t, prices[i])
+				switch {
+				case r.price == nil && got != nil:
+					t.Errorf("row %d price = %s, want null", i, got)
+				case r.price != nil && (got == nil || !got.Equal(decimal.MustParse(*r.price))):
+					t.Errorf("row %d price = %v, want %s", i, got, *r.price)
+				}
+			}
+		})
+	}
+}
+
+func checkSchema(t *testing.T, pr *reader.ParquetReader) {
+	t.Helper()
+	schema := pr.Footer.Schema
+	if len(schema) != len(testColumns)+1 {
+		t.Fatalf("%d schema elements, want %d", len(schema), len(testColumns)+1)
+	}
+	for i, col := range testColumns {
+		el := schema[i+1]
+		if name := pr.SchemaHandler.Infos[i+1].ExName; name != col.Name {
+			t.Errorf("column %d is %s, want %s", i, name, col.Name)
+		}
+		optional := el.RepetitionType != nil && el.RepetitionType.String() == "OPTIONAL"
+		if optional != col.Optional {
+			t.Errorf("column %s: repetition %v", col.Name, el.RepetitionType)
+		}
+		if col.Kind == Decimal && (el.GetPrecision() != int32(col.Precision) || el.GetScale() != int32(col.Scale) || el.ConvertedType.String() != "DECIMAL") {
+			t.Errorf("column %s: %v(%d, %d)", col.Name, el.ConvertedType, el.GetPrecision(), el.GetScale())
+		}
+	}
+}
+
+func sameOptional[T comparable](got any, want *T) bool {
+	if want == nil {
+		return got == nil
+	}
+	return got == *want
+}
+
+// decimalValue decodes a DECIMAL BYTE_ARRAY value read back at scale 8.
+// The reader returns the raw bytes (its own conversion helper ignores the
+// sign), so they are decoded as the format defines them.
+func decimalValue(t *testing.T, v any) *decimal.Decimal {
+	t.Helper()
+	if v == nil {
+		return nil
+	}
+	d, err := decimal.Parse(fromTwosComplement([]byte(v.(string))).String() + "e-8")
+	if err != nil {
+		t.Fatalf("decimal %x: %v", v, err)
+	}
+	return &d
+}
+
+// fromTwosComplement decodes big-endian two's complement bytes.
+func fromTwosComplement(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return n
+}
+
+func TestTwosComplement(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 127, 128, -128, -129, 255, -255, -256, 1 << 40, -(1 << 40)} {
+		b := twosComplement(big.NewInt(n))
+		if got := fromTwosComplement(b); got.Int64() != n {
+			t.Errorf("twosComplement(%d) = %x, decodes to %s", n, b, got)
+		}
+		if len(b) > 1 && (b[0] == 0 && b[1]&0x80 == 0 || b[0] == 0xff && b[1]&0x80 != 0) {
+			t.Errorf("twosComplement(%d) = %x is not minimal", n, b)
+		}
+	}
+}
+
+func TestWriteRejects(t *testing.T) {
+	w := NewWriter(io.Discard, testColumns[:2])
+	if err := w.Write(nil, "x"); err == nil {
+		t.Error("null in a required column accepted")
+	}
+	if err := w.Write(1, nil); err == nil {
+		t.Error("int in a string column accepted")
+	}
+	if err := w.Write("x"); err == nil {
+		t.Error("short row accepted")
+	}
+}
@@ -0,0 +1,178 @@
+// Package prices keeps the last market price per symbol and marks holdings
+// to market with it.
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/example/trades-aggregator/internal/validation"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Quote sources.
+const (
+	SourceKafka  = "kafka"
+	SourceManual = "manual"
+)
+
+// Quote is a price observation for a symbol.
+type Quote struct {
+	Symbol string          `json:"symbol"`
+	Price  decimal.Decimal `json:"price"`
+	TS     time.Time       `json:"ts"`
+	Source string          `json:"source,omitempty"`
+	Stale  bool            `json:"stale"`
+}
+
+// Service persists quotes and serves the latest one per symbol from memory.
+type Service struct {
+	DB         *pgxpool.Pool
+	StaleAfter time.Duration
+
+	mu     sync.RWMutex
+	latest map[string]Quote
+}
+
+func New(db *pgxpool.Pool, staleAfter time.Duration) *Service {
+	return &Service{DB: db, StaleAfter: staleAfter, latest: make(map[string]Quote)}
+}
+
+// DecodeQuote parses and checks a quote message. Quotes without a timestamp
+// are stamped with the current time.
+func DecodeQuote(b []byte) (Quote, error) {
+	var q Quote
+	if err := json.Unmarshal(b, &q); err != nil {
+		return Quote{}, err
+	}
+	return q, Check(&q)
+}
+
+// Check normalizes q and rejects quotes that cannot be a market price.
+func Check(q *Quote) error {
+	q.Symbol = strings.TrimSpace(q.Symbol)
+	if q.Symbol == "" {
+		return fmt.Errorf("quote: symbol is required")
+	}
+	if q.Price.Sign() <= 0 {
+		return fmt.Errorf("quote %s: price must be greater than zero", q.Symbol)
+	}
+	if fe := validation.Numeric("price", q.Price); len(fe) > 0 {
+		return fmt.Errorf("quote %s: %s", q.Symbol, fe[0].Message)
+	}
+	if q.TS.IsZero() {
+		q.TS = time.Now().UTC()
+	}
+	return nil
+}
+
+// Load primes the in-memory view from the database.
+func (s *Service) Load(ctx context.Context) error {
+	rows, err := s.DB.Query(ctx, `SELECT symbol, price, ts, source FROM prices`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	latest := make(map[string]Quote)
+	for rows.Next() {
+		var q Quote
+		if err := rows.Scan(&q.Symbol, &q.Price, &q.TS, &q.Source); err != nil {
+			return err
+		}
+		latest[q.Symbol] = q
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.latest = latest
+	s.mu.Unlock()
+	return nil
+}
+
+// Upsert stores quotes (already checked) from source. A quote older than the
+// one on record for its symbol is ignored.
+func (s *Service) Upsert(ctx context.Context, source string, quotes []Quote) error {
+	if len(quotes) == 0 {
+		return nil
+	}
+	batch := &pgx.Batch{}
+	for _, q := range quotes {
+		batch.Queue(`
+			INSERT INTO prices (symbol, price, ts, source) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (symbol) DO UPDATE
+			SET price = EXCLUDED.price, ts = EXCLUDED.ts, source = EXCLUDED.source, received_at = now()
+			WHERE prices.ts <= EXCLUDED.ts
+		`, q.Symbol, q.Price, q.TS, source)
+	}
+	if err := s.DB.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range quotes {
+		q.Source = source
+		if cur, ok := s.latest[q.Symbol]; !ok || !q.TS.Before(cur.TS) {
+			s.latest[q.Symbol] = q
+		}
+	}
+	return nil
+}
+
+// Latest returns the last price for symbol, flagged stale when older than
+// StaleAfter.
+func (s *Service) Latest(symbol string) (Quote, bool) {
+	s.mu.RLock()
+	q, ok := s.latest[symbol]
+	s.mu.RUnlock()
+	if ok {
+		q.Stale = s.isStale(q.TS)
+	}
+	return q, ok
+}
+
+// All returns the last price of every known symbol.
+func (s *Service) All() []Quote {
+	s.mu.RLock()
+	out := make([]Quote, 0, len(s.latest))
+	for _, q := range s.latest {
+		q.Stale = s.isStale(q.TS)
+		out = append(out, q)
+	}
+	s.mu.RUnlock()
+	return out
+}
+
+func (s *Service) isStale(ts time.Time) bool {
+	return s.StaleAfter > 0 && time.Since(ts) > s.StaleAfter
+}
+
+// Enrich returns a copy of hs with a Valuation for every holding whose
+// symbol has a price. The input slice (often shared through a cache) is not
+// modified.
+func (s *Service) Enrich(hs []models.Holding) []models.Holding {
+	out := make([]models.Holding, len(hs))
+	for i, h := range hs {
+		if q, ok := s.Latest(h.Symbol); ok {
+			size := domain.ContractSize(h.InstrumentType, h.Symbol)
+			h.Valuation = &models.Valuation{
+				Price:         q.Price,
+				PriceTS:       q.TS,
+				Stale:         q.Stale,
+				MarketValue:   h.Quantity.Mul(q.Price).Mul(size),
+				UnrealizedPnL: q.Price.Sub(h.AvgCost).Mul(h.Quantity).Mul(size).Round(8),
+			}
+		}
+		out[i] = h
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package prices
+
+import "testing"
+
+func TestDecodeQuote(t *testing.T) {
+	tests := []struct {
+		msg string
+		ok  bool
+	}{
+		{`{"symbol": "AAPL", "price": 189.25, "ts": "2024-05-01T12:00:00Z"}`, true},
+		{`{"symbol": " BTC ", "price": "64000.12345678"}`, true},
+		{`{"symbol": "", "price": 1}`, false},
+		{`{"symbol": "AAPL", "price": 0}`, false},
+		{`{"symbol": "AAPL", "price": -1}`, false},
+		{`{"symbol": "AAPL", "price": 1.123456789}`, false},   // Postgres would round it
+		{`{"symbol": "AAPL", "price": 1234567890123}`, false}, // Postgres would reject it
+		{`{"symbol": "AAPL", "price": 1e50000000}`, false},
+	}
+	for _, tt := range tests {
+		q, err := DecodeQuote([]byte(tt.msg))
+		if (err == nil) != tt.ok {
+			t.Errorf("DecodeQuote(%s): err = %v", tt.msg, err)
+			continue
+		}
+		if err == nil && (q.TS.IsZero() || q.Symbol == "" || q.Symbol[0] == ' ') {
+			t.Errorf("DecodeQuote(%s) = %+v, not normalized", tt.msg, q)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package redis is a minimal client for the Redis protocol (RESP2): enough
+// for caching (GET/SET/MGET/INCR/DEL) and pub/sub. It works against Redis,
+// Valkey, KeyDB or any in-process server that speaks the same protocol.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	maxIdle     = 8
+	dialTimeout = 5 * time.Second
+)
+
+// Client is safe for concurrent use; it keeps a small pool of idle
+// connections and dials more on demand.
+type Client struct {
+	Addr     string
+	Password string
+	DB       int
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// New parses a redis://[:password@]host[:port][/db] URL.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("redis: unsupported scheme %q", u.Scheme)
+	}
+	c := &Client{Addr: u.Host}
+	if u.Port() == "" {
+		c.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.Password, _ = u.User.Password()
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		if c.DB, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("redis: bad database %q", p)
+		}
+	}
+	return c, nil
+}
+
+// Do sends one command and returns its reply (see readReply for the types).
+// An error reply is returned as an Error. A pooled connection the server has
+// since closed is retried once on a new one.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	cn, pooled, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := cn.do(ctx, args)
+	if err != nil && pooled && isClosed(err) && ctx.Err() == nil {
+		_ = cn.nc.Close()
+		if cn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+		v, err = cn.do(ctx, args)
+	}
+	if err != nil {
+		_ = cn.nc.Close()
+		return nil, err
+	}
+	c.put(cn)
+	if e, ok := v.(Error); ok {
+		return nil, e
+	}
+	return v, nil
+}
+
+// Publish posts msg on channel.
+func (c *Client) Publish(ctx context.Context, channel string, msg []byte) error {
+	_, err := c.Do(ctx, "PUBLISH", channel, string(msg))
+	return err
+}
+
+// Subscribe listens on channel on a dedicated connection and calls fn for
+// every message until ctx is cancelled or the connection fails; it always
+// returns a non-nil error.
+func (c *Client) Subscribe(ctx context.Context, channel string, fn func(msg []byte)) error {
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer cn.nc.Close()
+	stop := context.AfterFunc(ctx, func() { _ = cn.nc.Close() })
+	defer stop()
+
+	if err := writeCommand(cn.w, []string{"SUBSCRIBE", channel}); err != nil {
+		return err
+	}
+	for {
+		v, err := readReply(cn.r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if e, ok := v.(Error); ok {
+			return e
+		}
+		// ["message", channel, payload]; subscribe confirmations are skipped.
+		if msg, ok := v.([]any); ok && len(msg) == 3 && string(asBytes(msg[0])) == "message" {
+			fn(asBytes(msg[2]))
+		}
+	}
+}
+
+func asBytes(v any) []byte {
+	b, _ := v.([]byte)
+	return b
+}
+
+// get takes an idle connection (pooled is true) or dials a new one.
+func (c *Client) get(ctx context.Context) (cn *conn, pooled bool, err error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, true, nil
+	}
+	c.mu.Unlock()
+	cn, err = c.dial(ctx)
+	return cn, false, err
+}
+
+// isClosed reports whether err means the peer had closed the connection, so
+// the command cannot have been run.
+func isClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdle {
+		_ = cn.nc.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// Close closes the idle connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		_ = cn.nc.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.Password != "" {
+		if err := cn.expectOK(ctx, "AUTH", c.Password); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+	if c.DB != 0 {
+		if err := cn.expectOK(ctx, "SELECT", strconv.Itoa(c.DB)); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (cn *conn) do(ctx context.Context, args []string) (any, error) {
+	deadline, _ := ctx.Deadline() // zero: no deadline
+	if err := cn.nc.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := writeCommand(cn.w, args); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+func (cn *conn) expectOK(ctx context.Context, args ...string) error {
+	v, err := cn.do(ctx, args)
+	if err != nil {
+		return err
+	}
+	if e, ok := v.(Error); ok {
+		return fmt.Errorf("redis %s: %w", args[0], e)
+	}
+	return nil
+}
+
+var errUnexpected = errors.New("redis: unexpected reply type")
+
+// Bytes converts a bulk or simple string reply.
+func Bytes(v any) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	return nil, errUnexpected
+}
+
+// Int converts an integer reply.
+func Int(v any) (int64, error) {
+	if n, ok := v.(int64); ok {
+		return n, nil
+	}
+	return 0, errUnexpected
+}
@@ -0,0 +1,372 @@
+// Package redistest runs an in-process server that speaks enough of the
+// Redis protocol (GET/SET/MGET/INCR/DEL, AUTH/SELECT and pub/sub) to test
+// the redis client and the caches built on it.
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a fake Redis. Its zero value is not usable; see NewServer.
+type Server struct {
+	// Addr is the host:port the server listens on, URL the same as a
+	// redis:// URL for redis.New.
+	Addr string
+	URL  string
+
+	ln net.Listener
+
+	mu       sync.Mutex
+	password string
+	data     map[string]entry
+	conns    map[*client]bool
+	calls    map[string]int
+}
+
+type entry struct {
+	value   string
+	expires time.Time // zero: never
+}
+
+type client struct {
+	nc     net.Conn
+	wmu    sync.Mutex // serializes replies with published messages
+	w      *bufio.Writer
+	authed bool
+	subs   map[string]bool
+}
+
+// NewServer starts a server on a loopback port and stops it when the test
+// ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Addr:  ln.Addr().String(),
+		URL:   "redis://" + ln.Addr().String(),
+		ln:    ln,
+		data:  make(map[string]entry),
+		conns: make(map[*client]bool),
+		calls: make(map[string]int),
+	}
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// RequirePass makes new connections send AUTH password before any other
+// command.
+func (s *Server) RequirePass(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// Close stops accepting connections and closes the open ones.
+func (s *Server) Close() {
+	_ = s.ln.Close()
+	s.DropConnections()
+}
+
+// DropConnections closes every open connection, as a server restart or a
+// network failure would.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for cl := range s.conns {
+		_ = cl.nc.Close()
+	}
+}
+
+// Calls reports how many times the command (e.g. "INCR") was received.
+func (s *Server) Calls(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[strings.ToUpper(command)]
+}
+
+// Get returns the value stored at key.
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(key)
+	return e.value, ok
+}
+
+// Set stores value at key without expiry.
+func (s *Server) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = entry{value: value}
+}
+
+// TTL is the time key has left to live; zero when it has no expiry or does
+// not exist.
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(key)
+	if !ok || e.expires.IsZero() {
+		return 0
+	}
+	return time.Until(e.expires)
+}
+
+// Subscribers reports how many connections are subscribed to channel.
+func (s *Server) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for cl := range s.conns {
+		if cl.subs[channel] {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) lookup(key string) (entry, bool) {
+	e, ok := s.data[key]
+	if ok && !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(s.data, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		cl := &client{nc: nc, w: bufio.NewWriter(nc), subs: make(map[string]bool)}
+		s.mu.Lock()
+		cl.authed = s.password == ""
+		s.conns[cl] = true
+		s.mu.Unlock()
+		go s.handle(cl)
+	}
+}
+
+func (s *Server) handle(cl *client) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, cl)
+		s.mu.Unlock()
+		_ = cl.nc.Close()
+	}()
+	r := bufio.NewReader(cl.nc)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				cl.reply("-ERR Protocol error: " + err.Error() + "\r\n")
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cl.reply(s.exec(cl, args))
+	}
+}
+
+func (cl *client) reply(resp string) {
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	_, _ = cl.w.WriteString(resp)
+	_ = cl.w.Flush()
+}
+
+// exec runs one command and returns its encoded reply.
+func (s *Server) exec(cl *client, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd := strings.ToUpper(args[0])
+	s.calls[cmd]++
+
+	if cmd == "AUTH" {
+		if len(args) != 2 {
+			return wrongArgs(cmd)
+		}
+		if args[1] != s.password {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		cl.authed = true
+		return "+OK\r\n"
+	}
+	if !cl.authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	if len(cl.subs) > 0 && cmd != "SUBSCRIBE" && cmd != "PING" {
+		return "-ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n"
+	}
+
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		if len(args) != 2 {
+			return wrongArgs(cmd)
+		}
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		return "+OK\r\n"
+	case "GET":
+		if len(args) != 2 {
+			return wrongArgs(cmd)
+		}
+		e, ok := s.lookup(args[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(e.value)
+	case "MGET":
+		if len(args) < 2 {
+			return wrongArgs(cmd)
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args)-1)
+		for _, k := range args[1:] {
+			if e, ok := s.lookup(k); ok {
+				b.WriteString(bulk(e.value))
+			} else {
+				b.WriteString("$-1\r\n")
+			}
+		}
+		return b.String()
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			return "-ERR syntax error\r\n"
+		}
+		e := entry{value: args[2]}
+		if len(args) == 5 {
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			switch {
+			case strings.ToUpper(args[3]) != "PX":
+				return "-ERR syntax error\r\n"
+			case err != nil || ms <= 0:
+				return "-ERR invalid expire time in 'set' command\r\n"
+			}
+			e.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		s.data[args[1]] = e
+		return "+OK\r\n"
+	case "INCR":
+		if len(args) != 2 {
+			return wrongArgs(cmd)
+		}
+		e, _ := s.lookup(args[1])
+		n := int64(0)
+		if e.value != "" {
+			var err error
+			if n, err = strconv.ParseInt(e.value, 10, 64); err != nil {
+				return "-ERR value is not an integer or out of range\r\n"
+			}
+		}
+		n++
+		e.value = strconv.FormatInt(n, 10)
+		s.data[args[1]] = e
+		return ":" + e.value + "\r\n"
+	case "DEL":
+		if len(args) < 2 {
+			return wrongArgs(cmd)
+		}
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.lookup(k); ok {
+				delete(s.data, k)
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "PUBLISH":
+		if len(args) != 3 {
+			return wrongArgs(cmd)
+		}
+		msg := "*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2])
+		n := 0
+		for sub := range s.conns {
+			if sub.subs[args[1]] {
+				sub.reply(msg)
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "SUBSCRIBE":
+		if len(args) < 2 {
+			return wrongArgs(cmd)
+		}
+		var b strings.Builder
+		for _, ch := range args[1:] {
+			cl.subs[ch] = true
+			fmt.Fprintf(&b, "*3\r\n%s%s:%d\r\n", bulk("subscribe"), bulk(ch), len(cl.subs))
+		}
+		return b.String()
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func bulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+
+func wrongArgs(cmd string) string {
+	return fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(cmd))
+}
+
+// readCommand decodes a RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected '*', got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid multibulk length %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeCommand(bufio.NewWriter(&buf), []string{"SET", "k", "a\r\nb", ""}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "*4\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n$0\r\n\r\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"+OK\r\n", []byte("OK")},
+		{"-ERR unknown command 'FOO'\r\n", Error("ERR unknown command 'FOO'")},
+		{":42\r\n", int64(42)},
+		{":-1\r\n", int64(-1)},
+		{"$5\r\nhello\r\n", []byte("hello")},
+		{"$0\r\n\r\n", []byte{}},
+		{"$4\r\na\r\nb\r\n", []byte("a\r\nb")},
+		{"$-1\r\n", nil},
+		{"*-1\r\n", nil},
+		{"*0\r\n", []any{}},
+		{"*3\r\n$1\r\na\r\n$-1\r\n:7\r\n", []any{[]byte("a"), nil, int64(7)}},
+		{"*2\r\n*1\r\n+x\r\n-ERR y\r\n", []any{[]any{[]byte("x")}, Error("ERR y")}},
+	}
+	for _, tt := range tests {
+		got, err := readReply(bufio.NewReader(strings.NewReader(tt.in)))
+		if err != nil {
+			t.Errorf("readReply(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readReply(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadReplyRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"\r\n",
+		"+OK\n",
+		"?what\r\n",
+		":x\r\n",
+		"$x\r\n",
+		"$5\r\nhel",
+		"*x\r\n",
+		"*2\r\n+a\r\n",
+	} {
+		if v, err := readReply(bufio.NewReader(strings.NewReader(in))); err == nil {
+			t.Errorf("readReply(%q) = %#v, want an error", in, v)
+		}
+	}
+}
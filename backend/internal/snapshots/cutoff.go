@@ -0,0 +1,164 @@
+package snapshots
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/entities"
+)
+
+const dateLayout = "2006-01-02"
+
+// Cutoff is the local time at which an entity closes its books. Only
+// weekdays are business dates.
+type Cutoff struct {
+	Entity   string
+	Location *time.Location
+	Hour     int
+	Minute   int
+}
+
+// Schedule says when entities close their books: every active entity of
+// the registry at Close, local time in its registered time zone, unless an
+// override gives it another time or zone. Without a Close, only the
+// overridden entities are closed.
+type Schedule struct {
+	Close     *Clock // nil: no default close
+	Overrides map[string]Override
+}
+
+// Clock is a time of day.
+type Clock struct{ Hour, Minute int }
+
+// Override is an entity's own cut-off; Zone replaces its registered zone
+// when set.
+type Override struct {
+	Zone string
+	At   Clock
+}
+
+// ParseSchedule parses the default close time (HH:MM, empty for none) and
+// a comma-separated list of overrides, entity@HH:MM or entity=Zone@HH:MM,
+// e.g. "zurich@17:30,new_york=America/New_York@16:00". The entities need
+// not be registered yet.
+func ParseSchedule(close, overrides string) (Schedule, error) {
+	var sc Schedule
+	if close = strings.TrimSpace(close); close != "" {
+		clock, err := parseClock(close)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("close %q: %w", close, err)
+		}
+		sc.Close = &clock
+	}
+	sc.Overrides = make(map[string]Override)
+	for _, part := range strings.Split(overrides, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		head, hhmm, ok := strings.Cut(part, "@")
+		if !ok {
+			return Schedule{}, fmt.Errorf("cutoff %q: use entity@HH:MM or entity=Zone@HH:MM", part)
+		}
+		name, zone, hasZone := strings.Cut(head, "=")
+		code := strings.ToLower(strings.TrimSpace(name))
+		if !domain.ValidEntityCode(code) {
+			return Schedule{}, fmt.Errorf("cutoff %q: invalid entity %q", part, name)
+		}
+		if _, dup := sc.Overrides[code]; dup {
+			return Schedule{}, fmt.Errorf("cutoff %q: entity %s listed twice", part, code)
+		}
+		o := Override{}
+		if hasZone {
+			o.Zone = strings.TrimSpace(zone)
+			if _, err := time.LoadLocation(o.Zone); err != nil || o.Zone == "" {
+				return Schedule{}, fmt.Errorf("cutoff %q: unknown time zone %q", part, o.Zone)
+			}
+		}
+		var err error
+		if o.At, err = parseClock(hhmm); err != nil {
+			return Schedule{}, fmt.Errorf("cutoff %q: %w", part, err)
+		}
+		sc.Overrides[code] = o
+	}
+	return sc, nil
+}
+
+func parseClock(s string) (Clock, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return Clock{}, errors.New("time must be HH:MM")
+	}
+	return Clock{Hour: t.Hour(), Minute: t.Minute()}, nil
+}
+
+// Cutoffs resolves the schedule for the registered entities. Inactive
+// entities do not close; one whose time zone cannot be loaded is reported
+// in errs and left out.
+func (sc Schedule) Cutoffs(ents []entities.Entity) (cutoffs []Cutoff, errs []error) {
+	for _, e := range ents {
+		if !e.Active {
+			continue
+		}
+		o, ok := sc.Overrides[e.Code]
+		switch {
+		case ok:
+		case sc.Close != nil:
+			o = Override{At: *sc.Close}
+		default:
+			continue
+		}
+		zone := o.Zone
+		if zone == "" {
+			zone = e.Timezone
+		}
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("entity %s: %w", e.Code, err))
+			continue
+		}
+		cutoffs = append(cutoffs, Cutoff{Entity: e.Code, Location: loc, Hour: o.At.Hour, Minute: o.At.Minute})
+	}
+	return cutoffs, errs
+}
+
+func (c Cutoff) String() string {
+	return fmt.Sprintf("%s=%s@%02d:%02d", c.Entity, c.Location, c.Hour, c.Minute)
+}
+
+// on returns the cut-off on the local calendar day of t.
+func (c Cutoff) on(t time.Time) time.Time {
+	l := t.In(c.Location)
+	return time.Date(l.Year(), l.Month(), l.Day(), c.Hour, c.Minute, 0, 0, c.Location)
+}
+
+// Next returns the first cut-off on a business day strictly after t.
+func (c Cutoff) Next(t time.Time) time.Time {
+	at := c.on(t)
+	for !at.After(t) || !isBusinessDay(at) {
+		at = c.on(at.AddDate(0, 0, 1))
+	}
+	return at
+}
+
+// Previous returns the last cut-off on a business day at or before t.
+func (c Cutoff) Previous(t time.Time) time.Time {
+	at := c.on(t)
+	for at.After(t) || !isBusinessDay(at) {
+		at = c.on(at.AddDate(0, 0, -1))
+	}
+	return at
+}
+
+// BusinessDate is the local calendar date of a cut-off.
+func (c Cutoff) BusinessDate(at time.Time) string {
+	return at.In(c.Location).Format(dateLayout)
+}
+
+func isBusinessDay(t time.Time) bool {
+	wd := t.Weekday()
+	return wd != time.Saturday && wd != time.Sunday
+}
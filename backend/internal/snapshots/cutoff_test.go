@@ -0,0 +1,131 @@
+package snapshots
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/entities"
+)
+
+var registry = []entities.Entity{
+	{Code: "new_york", Timezone: "America/New_York", Active: true},
+	{Code: "tokyo", Timezone: "Asia/Tokyo", Active: false},
+	{Code: "zurich", Timezone: "Europe/Zurich", Active: true},
+	{Code: "singapore", Timezone: "Asia/Singapore", Active: true},
+}
+
+func cutoffStrings(t *testing.T, sc Schedule, ents []entities.Entity) []string {
+	t.Helper()
+	cutoffs, errs := sc.Cutoffs(ents)
+	if len(errs) > 0 {
+		t.Fatalf("Cutoffs: %v", errs)
+	}
+	out := make([]string, len(cutoffs))
+	for i, c := range cutoffs {
+		out[i] = c.String()
+	}
+	return out
+}
+
+func TestScheduleCutoffs(t *testing.T) {
+	tests := []struct {
+		close, overrides string
+		want             []string
+	}{
+		{"17:00", "", []string{
+			"new_york=America/New_York@17:00", "zurich=Europe/Zurich@17:00", "singapore=Asia/Singapore@17:00",
+		}},
+		{"17:00", "zurich@17:30, new_york=UTC@21:05", []string{
+			"new_york=UTC@21:05", "zurich=Europe/Zurich@17:30", "singapore=Asia/Singapore@17:00",
+		}},
+		// Only the overridden entities close without a default, and
+		// overrides of inactive or unregistered entities are ignored.
+		{"", "zurich@17:30,tokyo@15:00,paris@17:30", []string{"zurich=Europe/Zurich@17:30"}},
+		{"", "", []string{}},
+	}
+	for _, tt := range tests {
+		sc, err := ParseSchedule(tt.close, tt.overrides)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q, %q): %v", tt.close, tt.overrides, err)
+		}
+		if got := cutoffStrings(t, sc, registry); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseSchedule(%q, %q) cut-offs = %v, want %v", tt.close, tt.overrides, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleFollowsRegistry(t *testing.T) {
+	sc, err := ParseSchedule("17:00", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := slices.Clone(registry)
+	ents[1].Active = true                  // tokyo reactivated
+	ents[2].Timezone = "Europe/London"     // zurich moved
+	ents[3].Timezone = "Nowhere/Somewhere" // broken registration
+	cutoffs, errs := sc.Cutoffs(ents)
+	if len(errs) != 1 {
+		t.Errorf("errors %v, want one for singapore", errs)
+	}
+	var got []string
+	for _, c := range cutoffs {
+		got = append(got, c.String())
+	}
+	want := []string{"new_york=America/New_York@17:00", "tokyo=Asia/Tokyo@17:00", "zurich=Europe/London@17:00"}
+	if !slices.Equal(got, want) {
+		t.Errorf("cut-offs = %v, want %v", got, want)
+	}
+}
+
+func TestParseScheduleRejects(t *testing.T) {
+	for _, tt := range []struct{ close, overrides string }{
+		{"5pm", ""},
+		{"25:00", ""},
+		{"17:00", "zurich"},
+		{"17:00", "zurich@"},
+		{"17:00", "zurich@17:60"},
+		{"17:00", "Zürich@17:00"},
+		{"17:00", "all@17:00"},
+		{"17:00", "zurich@17:00,zurich@18:00"},
+		{"17:00", "zurich=Mars/Olympus@17:00"},
+		{"17:00", "zurich=@17:00"},
+	} {
+		if _, err := ParseSchedule(tt.close, tt.overrides); err == nil {
+			t.Errorf("ParseSchedule(%q, %q) succeeded", tt.close, tt.overrides)
+		}
+	}
+}
+
+func TestCutoffNextPrevious(t *testing.T) {
+	zurich, _ := time.LoadLocation("Europe/Zurich")
+	c := Cutoff{Entity: "zurich", Location: zurich, Hour: 17, Minute: 30}
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, zurich)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		now, next, previous string
+	}{
+		{"2024-03-06 12:00", "2024-03-06 17:30", "2024-03-05 17:30"}, // Wednesday
+		{"2024-03-06 17:30", "2024-03-07 17:30", "2024-03-06 17:30"},
+		{"2024-03-08 18:00", "2024-03-11 17:30", "2024-03-08 17:30"}, // Friday evening
+		{"2024-03-10 12:00", "2024-03-11 17:30", "2024-03-08 17:30"}, // Sunday
+		{"2024-03-29 18:00", "2024-04-01 17:30", "2024-03-29 17:30"}, // over the DST change
+	}
+	for _, tt := range tests {
+		now := at(tt.now)
+		if got := c.Next(now); !got.Equal(at(tt.next)) {
+			t.Errorf("Next(%s) = %s, want %s", tt.now, got.In(zurich), tt.next)
+		}
+		if got := c.Previous(now); !got.Equal(at(tt.previous)) {
+			t.Errorf("Previous(%s) = %s, want %s", tt.now, got.In(zurich), tt.previous)
+		}
+	}
+	if got := c.BusinessDate(at("2024-03-06 17:30").UTC()); got != "2024-03-06" {
+		t.Errorf("BusinessDate = %s", got)
+	}
+}
@@ -0,0 +1,422 @@
+// Package snapshots freezes each entity's holdings at its daily close into a
+// position book that can be listed, retrieved and compared.
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/entities"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Snapshot sources.
+const (
+	SourceClose  = "close"  // copied from holdings at the cut-off
+	SourceLedger = "ledger" // taken late, folded from trades up to the cut-off
+)
+
+// Snapshot describes one entity's book for one business date.
+type Snapshot struct {
+	ID           int64     `json:"id"`
+	Entity       string    `json:"entity"`
+	BusinessDate string    `json:"business_date"`
+	CutoffAt     time.Time `json:"cutoff_at"`
+	Source       string    `json:"source"`
+	TakenAt      time.Time `json:"taken_at"`
+	Positions    int       `json:"positions"`
+}
+
+// Detail is a snapshot with its frozen holdings.
+type Detail struct {
+	Snapshot
+	Holdings []models.Holding `json:"holdings"`
+}
+
+// Change statuses.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Change is how one holding moved between two snapshots. Holdings that did
+// not change are left out of a diff.
+type Change struct {
+	InstrumentType    string          `json:"instrument_type"`
+	Symbol            string          `json:"symbol"`
+	Status            string          `json:"status"`
+	FromQuantity      decimal.Decimal `json:"from_quantity"`
+	ToQuantity        decimal.Decimal `json:"to_quantity"`
+	QuantityChange    decimal.Decimal `json:"quantity_change"`
+	FromAvgCost       decimal.Decimal `json:"from_avg_cost"`
+	ToAvgCost         decimal.Decimal `json:"to_avg_cost"`
+	RealizedPnLChange decimal.Decimal `json:"realized_pnl_change"`
+}
+
+type Service struct {
+	DB       *pgxpool.Pool
+	Holdings *holdings.Service
+
+	// MaxCatchUp bounds how many missed closes per entity are recovered
+	// from the ledger at startup.
+	MaxCatchUp int
+}
+
+func New(db *pgxpool.Pool, h *holdings.Service) *Service { return &Service{DB: db, Holdings: h} }
+
+// Take freezes the entity's current holdings as its book for date. It
+// reports false when a snapshot for that date already exists.
+func (s *Service) Take(ctx context.Context, entity, date string, cutoffAt time.Time) (bool, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	id, ok, err := insertSnapshot(ctx, tx, entity, date, cutoffAt, SourceClose)
+	if err != nil || !ok {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO holdings_snapshot_positions
+		  (snapshot_id, instrument_type, symbol, quantity, avg_cost, cost_basis, realized_pnl)
+		SELECT $1, instrument_type, symbol, quantity, avg_cost, cost_basis, realized_pnl
+		FROM holdings WHERE entity = $2
+	`, id, entity); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// TakeFromLedger records the book for date from the trades ledger as of the
+// cut-off, for closes that passed while the service was down. It reports
+// false when a snapshot for that date already exists.
+func (s *Service) TakeFromLedger(ctx context.Context, entity, date string, cutoffAt time.Time) (bool, error) {
+	rows, err := s.Holdings.AsOf(ctx, cutoffAt, entity)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	id, ok, err := insertSnapshot(ctx, tx, entity, date, cutoffAt, SourceLedger)
+	if err != nil || !ok {
+		return false, err
+	}
+	batch := &pgx.Batch{}
+	for _, h := range rows {
+		batch.Queue(`
+			INSERT INTO holdings_snapshot_positions
+			  (snapshot_id, instrument_type, symbol, quantity, avg_cost, cost_basis, realized_pnl)
+			VALUES ($1, $2::instrument_type, $3, $4, $5, $6, $7)
+		`, id, h.InstrumentType, h.Symbol, h.Quantity, h.AvgCost, h.CostBasis, h.RealizedPnL)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+func insertSnapshot(ctx context.Context, tx pgx.Tx, entity, date string, cutoffAt time.Time, source string) (int64, bool, error) {
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO holdings_snapshots (entity, business_date, cutoff_at, source)
+		VALUES ($1, $2::date, $3, $4)
+		ON CONFLICT (entity, business_date) DO NOTHING
+		RETURNING id
+	`, entity, date, cutoffAt, source).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// Latest returns the business date of entity's most recent snapshot, or ""
+// when it has none.
+func (s *Service) Latest(ctx context.Context, entity string) (string, error) {
+	var date string
+	err := s.DB.QueryRow(ctx, `
+		SELECT COALESCE(MAX(business_date)::text, '') FROM holdings_snapshots WHERE entity = $1
+	`, entity).Scan(&date)
+	return date, err
+}
+
+// List returns snapshots newest first, optionally restricted to one entity
+// (empty for all) and to business dates within [from, to] (empty for open).
+func (s *Service) List(ctx context.Context, entity, from, to string, limit int) ([]Snapshot, error) {
+	rows, err := s.DB.Query(ctx, `
+		SELECT s.id, s.entity::text, s.business_date::text, s.cutoff_at, s.source, s.taken_at,
+		       (SELECT COUNT(*) FROM holdings_snapshot_positions p WHERE p.snapshot_id = s.id)
+		FROM holdings_snapshots s
+		WHERE ($1 = '' OR s.entity::text = $1)
+		  AND ($2 = '' OR s.business_date >= $2::date)
+		  AND ($3 = '' OR s.business_date <= $3::date)
+		ORDER BY s.business_date DESC, s.entity
+		LIMIT $4
+	`, entity, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Snapshot, error) {
+		var sn Snapshot
+		err := row.Scan(&sn.ID, &sn.Entity, &sn.BusinessDate, &sn.CutoffAt, &sn.Source, &sn.TakenAt, &sn.Positions)
+		return sn, err
+	})
+}
+
+// Get returns entity's snapshot for date with its holdings, or pgx.ErrNoRows.
+func (s *Service) Get(ctx context.Context, entity, date string) (Detail, error) {
+	var d Detail
+	err := s.DB.QueryRow(ctx, `
+		SELECT id, entity::text, business_date::text, cutoff_at, source, taken_at
+		FROM holdings_snapshots WHERE entity = $1 AND business_date = $2::date
+	`, entity, date).Scan(&d.ID, &d.Entity, &d.BusinessDate, &d.CutoffAt, &d.Source, &d.TakenAt)
+	if err != nil {
+		return Detail{}, err
+	}
+	rows, err := s.DB.Query(ctx, `
+		SELECT instrument_type::text, symbol, quantity, avg_cost, cost_basis, realized_pnl
+		FROM holdings_snapshot_positions WHERE snapshot_id = $1
+		ORDER BY instrument_type, symbol
+	`, d.ID)
+	if err != nil {
+		return Detail{}, err
+	}
+	d.Holdings, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Holding, error) {
+		h := models.Holding{Entity: d.Entity}
+		err := row.Scan(&h.InstrumentType, &h.Symbol, &h.Quantity, &h.AvgCost, &h.CostBasis, &h.RealizedPnL)
+		return h, err
+	})
+	d.Positions = len(d.Holdings)
+	return d, err
+}
+
+// Diff compares entity's books for two business dates. Either snapshot
+// missing yields pgx.ErrNoRows.
+func (s *Service) Diff(ctx context.Context, entity, from, to string) ([]Change, error) {
+	a, err := s.Get(ctx, entity, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.Get(ctx, entity, to)
+	if err != nil {
+		return nil, err
+	}
+	return diff(a.Holdings, b.Holdings), nil
+}
+
+type positionKey struct{ instrumentType, symbol string }
+
+// diff lists the holdings that differ between a and b, both sorted by
+// (instrument_type, symbol), in that same order.
+func diff(a, b []models.Holding) []Change {
+	before := make(map[positionKey]models.Holding, len(a))
+	for _, h := range a {
+		before[positionKey{h.InstrumentType, h.Symbol}] = h
+	}
+	out := make([]Change, 0)
+	seen := make(map[positionKey]bool, len(b))
+	for _, h := range b {
+		k := positionKey{h.InstrumentType, h.Symbol}
+		seen[k] = true
+		prev, ok := before[k]
+		c := change(prev, h)
+		switch {
+		case !ok:
+			c.Status = ChangeAdded
+		case !prev.Quantity.Equal(h.Quantity) || !prev.AvgCost.Equal(h.AvgCost) || !prev.RealizedPnL.Equal(h.RealizedPnL):
+			c.Status = ChangeChanged
+		default:
+			continue
+		}
+		out = append(out, c)
+	}
+	for _, h := range a {
+		if !seen[positionKey{h.InstrumentType, h.Symbol}] {
+			c := change(h, models.Holding{InstrumentType: h.InstrumentType, Symbol: h.Symbol})
+			c.Status = ChangeRemoved
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func change(from, to models.Holding) Change {
+	return Change{
+		InstrumentType:    to.InstrumentType,
+		Symbol:            to.Symbol,
+		FromQuantity:      from.Quantity,
+		ToQuantity:        to.Quantity,
+		QuantityChange:    to.Quantity.Sub(from.Quantity),
+		FromAvgCost:       from.AvgCost,
+		ToAvgCost:         to.AvgCost,
+		RealizedPnLChange: to.RealizedPnL.Sub(from.RealizedPnL),
+	}
+}
+
+// Run takes each entity's snapshot at its cut-off until ctx is cancelled.
+// The cut-offs follow the entity registry, which registered returns: it is
+// checked every interval, so entities added, deactivated or moved to
+// another time zone are picked up. Closes missed while the service was
+// down are recovered from the ledger at startup, and one that fails is
+// retried from the ledger.
+func (s *Service) Run(ctx context.Context, sched Schedule, registered func() []entities.Entity, every time.Duration, logger *zap.Logger) {
+	type job struct {
+		cutoff Cutoff
+		stop   context.CancelFunc
+	}
+	jobs := make(map[string]job)
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		cutoffs, errs := sched.Cutoffs(registered())
+		for _, err := range errs {
+			logger.Error("snapshot_cutoff_invalid", zap.Error(err))
+		}
+		current := make(map[string]bool, len(cutoffs))
+		for _, c := range cutoffs {
+			current[c.Entity] = true
+			if j, ok := jobs[c.Entity]; ok {
+				if j.cutoff.String() == c.String() {
+					continue
+				}
+				j.stop()
+			}
+			jctx, stop := context.WithCancel(ctx)
+			jobs[c.Entity] = job{cutoff: c, stop: stop}
+			go s.runEntity(jctx, c, logger.With(zap.String("entity", c.Entity)))
+		}
+		for entity, j := range jobs {
+			if !current[entity] {
+				j.stop()
+				delete(jobs, entity)
+				logger.Info("snapshot_unscheduled", zap.String("entity", entity))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Retry backoff of a failed close, doubling up to the maximum.
+const (
+	retryBackoff    = time.Second
+	maxRetryBackoff = 5 * time.Minute
+	// A cut-off this far in the past is taken from the ledger: the live
+	// holdings may have moved on.
+	lateAfter = time.Minute
+)
+
+func (s *Service) runEntity(ctx context.Context, c Cutoff, logger *zap.Logger) {
+	s.catchUp(ctx, c, logger)
+
+	last := c.Previous(time.Now())
+	for {
+		next := c.Next(last)
+		logger.Info("snapshot_scheduled", zap.String("cutoff", c.String()), zap.Time("at", next))
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		if !s.close(ctx, c, next, logger) {
+			return
+		}
+		last = next
+	}
+}
+
+// close takes the book of the cut-off at, retrying with backoff until it
+// succeeds, so a failure does not lose the business date. Retries, like a
+// close taken late, replay the ledger as of the cut-off, since trading
+// carries on meanwhile. It reports false once ctx is cancelled.
+func (s *Service) close(ctx context.Context, c Cutoff, at time.Time, logger *zap.Logger) bool {
+	date := c.BusinessDate(at)
+	wait := retryBackoff
+	for attempt := 1; ; attempt++ {
+		var taken bool
+		var err error
+		if attempt == 1 && time.Since(at) < lateAfter {
+			taken, err = s.Take(ctx, c.Entity, date, at)
+		} else {
+			taken, err = s.TakeFromLedger(ctx, c.Entity, date, at)
+		}
+		if err == nil {
+			logger.Info("snapshot_taken", zap.String("business_date", date), zap.Bool("created", taken), zap.Int("attempt", attempt))
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		logger.Error("snapshot_failed", zap.String("business_date", date), zap.Int("attempt", attempt),
+			zap.Duration("retry_in", wait), zap.Error(err))
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		case <-t.C:
+		}
+		wait = min(2*wait, maxRetryBackoff)
+	}
+}
+
+// catchUp recovers the closes missed since the entity's latest snapshot,
+// oldest first, from the ledger. It goes back at most MaxCatchUp closes; an
+// entity without any snapshot only gets the most recent one.
+func (s *Service) catchUp(ctx context.Context, c Cutoff, logger *zap.Logger) {
+	latest, err := s.Latest(ctx, c.Entity)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("snapshot_catch_up_failed", zap.Error(err))
+		}
+		return
+	}
+	missed, complete := missedCloses(c, time.Now(), latest, s.MaxCatchUp)
+	if !complete {
+		logger.Warn("snapshot_catch_up_truncated", zap.String("latest", latest),
+			zap.String("from", c.BusinessDate(missed[0])), zap.Int("max", s.MaxCatchUp))
+	}
+	for _, at := range missed {
+		if !s.close(ctx, c, at, logger) {
+			return
+		}
+	}
+}
+
+// missedCloses returns the cut-offs at or before now whose business date is
+// after latest (empty for none), oldest first, keeping the limit most recent
+// (at least one). It reports whether that covers the whole gap.
+func missedCloses(c Cutoff, now time.Time, latest string, limit int) (missed []time.Time, complete bool) {
+	limit = max(limit, 1)
+	if latest == "" {
+		return []time.Time{c.Previous(now)}, true
+	}
+	for at := c.Previous(now); c.BusinessDate(at) > latest; at = c.Previous(at.Add(-time.Nanosecond)) {
+		if len(missed) == limit {
+			slices.Reverse(missed)
+			return missed, false
+		}
+		missed = append(missed, at)
+	}
+	slices.Reverse(missed)
+	return missed, true
+}
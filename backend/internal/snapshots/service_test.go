@@ -0,0 +1,51 @@
+package snapshots
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMissedCloses(t *testing.T) {
+	zurich, _ := time.LoadLocation("Europe/Zurich")
+	c := Cutoff{Entity: "zurich", Location: zurich, Hour: 17, Minute: 30}
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, zurich)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name     string
+		now      string
+		latest   string
+		limit    int
+		want     []string
+		complete bool
+	}{
+		{"up to date", "2024-03-14 09:00", "2024-03-13", 20, nil, true},
+		{"last close missed", "2024-03-14 09:00", "2024-03-12", 20, []string{"2024-03-13"}, true},
+		{"several days down", "2024-03-14 09:00", "2024-03-08", 20, []string{"2024-03-11", "2024-03-12", "2024-03-13"}, true},
+		{"down over a weekend", "2024-03-11 18:00", "2024-03-07", 20, []string{"2024-03-08", "2024-03-11"}, true},
+		{"gap longer than the limit", "2024-03-14 09:00", "2024-03-01", 3, []string{"2024-03-11", "2024-03-12", "2024-03-13"}, false},
+		{"gap exactly the limit", "2024-03-14 09:00", "2024-03-08", 3, []string{"2024-03-11", "2024-03-12", "2024-03-13"}, true},
+		{"no limit still takes the last close", "2024-03-14 09:00", "2024-03-01", 0, []string{"2024-03-13"}, false},
+		{"never snapshotted", "2024-03-14 09:00", "", 20, []string{"2024-03-13"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missed, complete := missedCloses(c, at(tt.now), tt.latest, tt.limit)
+			var got []string
+			for _, m := range missed {
+				if m.In(zurich).Hour() != 17 || m.In(zurich).Minute() != 30 {
+					t.Errorf("close at %s", m.In(zurich))
+				}
+				got = append(got, c.BusinessDate(m))
+			}
+			if !slices.Equal(got, tt.want) || complete != tt.complete {
+				t.Errorf("missedCloses = %v, %v, want %v, %v", got, complete, tt.want, tt.complete)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// Package stream fans applied trades and the holding changes they cause out
+// to live subscribers (SSE and WebSocket clients), keeping a bounded history
+// so that reconnecting clients can resume where they left off.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/deadletter"
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/holdings"
+	"github.com/example/trades-aggregator/internal/models"
+	"go.uber.org/zap"
+)
+
+// Event types.
+const (
+	TypeTrade   = "trade"
+	TypeHolding = "holding"
+	TypeAlert   = "alert"
+)
+
+// Event is one message of the stream. ID is "<epoch>-<seq>": seq increases
+// by one per event and epoch changes whenever the process restarts, so an ID
+// from before a restart is recognised as unknown rather than misread.
+type Event struct {
+	ID     string
+	Type   string
+	Entity string
+	Symbol string
+	Data   json.RawMessage
+}
+
+// HoldingChange is the payload of a holding event: the holding after the
+// trade and how much the trade moved it.
+type HoldingChange struct {
+	models.Holding
+	TradeID           string          `json:"trade_id"`
+	QuantityChange    decimal.Decimal `json:"quantity_change"`
+	RealizedPnLChange decimal.Decimal `json:"realized_pnl_change"`
+}
+
+// Alert is the payload of an alert event: something operators should look
+// at, such as a message that could not be applied.
+type Alert struct {
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+	TS      time.Time `json:"ts"`
+	Details any       `json:"details,omitempty"`
+}
+
+// Alert kinds.
+const AlertDeadLetter = "dead_letter"
+
+// Subscriber drop reasons.
+const (
+	ReasonSlow     = "slow_consumer"
+	ReasonShutdown = "shutdown"
+)
+
+// Subscription receives the matching events published after it was made.
+// When the subscriber falls too far behind it is dropped: Done is
+// closed and Reason says why. Publishing never waits for a subscriber.
+type Subscription struct {
+	C <-chan Event
+
+	c      chan Event
+	match  func(Event) bool
+	done   chan struct{}
+	reason string
+}
+
+// Done is closed when the hub drops the subscription.
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+// Reason is why the subscription was dropped; valid once Done is closed.
+func (s *Subscription) Reason() string { return s.reason }
+
+// Hub implements holdings.Observer and distributes events to subscribers.
+type Hub struct {
+	Logger *zap.Logger
+	Relay  *Relay // nil: only trades applied by this replica are streamed
+
+	buffer int
+	epoch  string
+
+	mu      sync.Mutex
+	seq     uint64
+	history []Event // ring of the last len(history) events
+	subs    map[*Subscription]struct{}
+}
+
+// NewHub keeps the last history events for resumption and lets each
+// subscriber fall at most buffer events behind.
+func NewHub(history, buffer int, logger *zap.Logger) *Hub {
+	if history < 1 {
+		history = 1
+	}
+	if buffer < 1 {
+		buffer = 1
+	}
+	return &Hub{
+		Logger:  logger,
+		buffer:  buffer,
+		epoch:   strconv.FormatInt(time.Now().UnixMilli(), 36),
+		history: make([]Event, history),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// TradesCommitted implements holdings.Observer: every change becomes a trade
+// event followed by a holding event.
+func (h *Hub) TradesCommitted(changes []holdings.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	events := make([]Event, 0, 2*len(changes))
+	for _, ch := range changes {
+		events = append(events, EventsOf(ch)...)
+	}
+	h.share(events)
+}
+
+// DeadLettered implements deadletter.Observer.
+func (h *Hub) DeadLettered(e deadletter.Entry) {
+	h.Alert(Alert{
+		Kind:    AlertDeadLetter,
+		Message: fmt.Sprintf("message %s/%d/%d dead-lettered (%s): %s", e.Topic, e.Partition, e.Offset, e.Reason, e.Error),
+		TS:      e.CreatedAt,
+		Details: e,
+	})
+}
+
+// Alert publishes a to alert subscribers, stamped with the current time
+// unless it has one.
+func (h *Hub) Alert(a Alert) {
+	if a.TS.IsZero() {
+		a.TS = time.Now().UTC()
+	}
+	data, _ := json.Marshal(a)
+	h.share([]Event{{Type: TypeAlert, Data: data}})
+}
+
+// share publishes events that originate on this replica, here and on the
+// other replicas.
+func (h *Hub) share(events []Event) { h.publish(events, true) }
+
+// EventsOf converts an applied trade into its trade and holding events. An
+// amend that moved the trade to another holding also yields a holding event
+// for the one it left.
+func EventsOf(ch holdings.Change) []Event {
+	t := ch.Trade
+	trade, _ := json.Marshal(t)
+	events := []Event{
+		{Type: TypeTrade, Entity: t.Entity, Symbol: t.Symbol, Data: trade},
+		holdingEvent(ch),
+	}
+	if ch.Reversed != nil {
+		events = append(events, holdingEvent(*ch.Reversed))
+	}
+	return events
+}
+
+func holdingEvent(ch holdings.Change) Event {
+	t := ch.Trade
+	holding, _ := json.Marshal(HoldingChange{
+		Holding:           ch.Holding,
+		TradeID:           t.TradeID,
+		QuantityChange:    ch.QuantityChange,
+		RealizedPnLChange: ch.RealizedPnLChange,
+	})
+	return Event{Type: TypeHolding, Entity: t.Entity, Symbol: t.Symbol, Data: holding}
+}
+
+// Publish assigns IDs to events, records them and hands them to the
+// matching subscribers, dropping those whose buffer is full.
+func (h *Hub) Publish(events []Event) { h.publish(events, false) }
+
+// publish implements Publish. With relay set the events are also queued for
+// the other replicas, under the same lock, so they leave in the order they
+// were published here.
+func (h *Hub) publish(events []Event, relay bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if relay && h.Relay != nil {
+		h.Relay.Publish(events)
+	}
+	for _, e := range events {
+		h.seq++
+		e.ID = h.epoch + "-" + strconv.FormatUint(h.seq, 10)
+		h.history[h.seq%uint64(len(h.history))] = e
+		for s := range h.subs {
+			if !s.match(e) {
+				continue
+			}
+			select {
+			case s.c <- e:
+			default:
+				h.drop(s, ReasonSlow)
+			}
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the events match accepts. With
+// lastID set, the matching events after it that are still in the history are
+// returned as backlog; resumed is false when lastID is unknown (too old, or
+// from before a restart), in which case the client should reload its state.
+func (h *Hub) Subscribe(match func(Event) bool, lastID string) (sub *Subscription, backlog []Event, resumed bool) {
+	c := make(chan Event, h.buffer)
+	sub = &Subscription{C: c, c: c, match: match, done: make(chan struct{})}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	if lastID == "" {
+		return sub, nil, true
+	}
+	seq, ok := h.parseID(lastID)
+	if !ok || seq > h.seq {
+		return sub, nil, false
+	}
+	oldest := uint64(1)
+	if n := uint64(len(h.history)); h.seq > n {
+		oldest = h.seq - n + 1
+	}
+	if seq+1 < oldest {
+		return sub, nil, false
+	}
+	for i := seq + 1; i <= h.seq; i++ {
+		if e := h.history[i%uint64(len(h.history))]; match(e) {
+			backlog = append(backlog, e)
+		}
+	}
+	return sub, backlog, true
+}
+
+// Recent returns up to n of the latest recorded events that match, oldest
+// first.
+func (h *Hub) Recent(match func(Event) bool, n int) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Event
+	size := uint64(len(h.history))
+	for i := h.seq; i > 0 && h.seq-i < size && len(out) < n; i-- {
+		if e := h.history[i%size]; match(e) {
+			out = append(out, e)
+		}
+	}
+	slices.Reverse(out)
+	return out
+}
+
+// Unsubscribe removes sub; it is a no-op for a dropped subscription.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.done)
+	}
+}
+
+// Close drops every subscriber, e.g. on shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		h.drop(s, ReasonShutdown)
+	}
+}
+
+// drop must be called with h.mu held.
+func (h *Hub) drop(s *Subscription, reason string) {
+	delete(h.subs, s)
+	s.reason = reason
+	close(s.done)
+	h.Logger.Info("stream_subscriber_dropped", zap.String("reason", reason))
+}
+
+func (h *Hub) parseID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+// Filter matches events of the given types (all when empty) for entity and
+// symbol (any when empty).
+func Filter(entity, symbol string, types ...string) func(Event) bool {
+	return func(e Event) bool {
+		if entity != "" && e.Entity != entity {
+			return false
+		}
+		if symbol != "" && e.Symbol != symbol {
+			return false
+		}
+		if len(types) == 0 {
+			return true
+		}
+		for _, t := range types {
+			if e.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+}
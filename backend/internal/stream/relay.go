@@ -0,0 +1,128 @@
+package stream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/redis"
+	"go.uber.org/zap"
+)
+
+const (
+	relayTimeout = 2 * time.Second
+	// relayQueue is how many batches may wait to be sent to the other
+	// replicas; more are dropped rather than hold up publishing.
+	relayQueue = 1024
+)
+
+// Relay shares stream events between replicas over Redis pub/sub: the
+// consumer group spreads partitions across replicas, so without it a client
+// would only see the trades applied by the replica it is connected to.
+// Event IDs are assigned by each hub, so resuming only works against the
+// replica that issued them; any other one answers with a reset.
+type Relay struct {
+	Client  *redis.Client
+	Channel string
+	Logger  *zap.Logger
+	origin  string
+	queue   chan []Event
+}
+
+type relayed struct {
+	Origin string      `json:"origin"`
+	Events []wireEvent `json:"events"`
+}
+
+type wireEvent struct {
+	Type   string          `json:"type"`
+	Entity string          `json:"entity"`
+	Symbol string          `json:"symbol"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func NewRelay(client *redis.Client, channel string, logger *zap.Logger) *Relay {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return &Relay{
+		Client:  client,
+		Channel: channel,
+		Logger:  logger,
+		origin:  hex.EncodeToString(id[:]),
+		queue:   make(chan []Event, relayQueue),
+	}
+}
+
+// Publish queues events applied on this replica for the others. Run sends
+// them one batch at a time in the order they were queued; Publish itself
+// never waits, and drops the batch if the queue is full.
+func (r *Relay) Publish(events []Event) {
+	select {
+	case r.queue <- events:
+	default:
+		r.Logger.Warn("stream_relay_queue_full", zap.Int("events", len(events)))
+	}
+}
+
+// send publishes the queued batches until ctx is cancelled.
+func (r *Relay) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case events := <-r.queue:
+			r.publish(ctx, events)
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, events []Event) {
+	msg := relayed{Origin: r.origin, Events: make([]wireEvent, len(events))}
+	for i, e := range events {
+		msg.Events[i] = wireEvent{Type: e.Type, Entity: e.Entity, Symbol: e.Symbol, Data: e.Data}
+	}
+	b, _ := json.Marshal(msg)
+	ctx, cancel := context.WithTimeout(ctx, relayTimeout)
+	defer cancel()
+	if err := r.Client.Publish(ctx, r.Channel, b); err != nil {
+		r.Logger.Warn("stream_relay_publish_failed", zap.Error(err))
+	}
+}
+
+// Run sends the events queued by Publish and publishes the events relayed by
+// other replicas on hub until ctx is cancelled, resubscribing after
+// connection failures.
+func (r *Relay) Run(ctx context.Context, hub *Hub) {
+	go r.send(ctx)
+	backoff := time.Second
+	for {
+		err := r.Client.Subscribe(ctx, r.Channel, func(b []byte) {
+			backoff = time.Second
+			var msg relayed
+			if err := json.Unmarshal(b, &msg); err != nil {
+				r.Logger.Warn("stream_relay_bad_message", zap.Error(err))
+				return
+			}
+			if msg.Origin == r.origin {
+				return
+			}
+			events := make([]Event, len(msg.Events))
+			for i, e := range msg.Events {
+				events[i] = Event{Type: e.Type, Entity: e.Entity, Symbol: e.Symbol, Data: e.Data}
+			}
+			hub.Publish(events)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		r.Logger.Warn("stream_relay_subscribe_failed", zap.Duration("retry_in", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/redis"
+	"github.com/example/trades-aggregator/internal/redis/redistest"
+	"go.uber.org/zap"
+)
+
+func TestRelayKeepsOrder(t *testing.T) {
+	srv := redistest.NewServer(t)
+	client, err := redis.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const n = 500
+	a, b := NewHub(10, n, zap.NewNop()), NewHub(10, n, zap.NewNop())
+	a.Relay = NewRelay(client, "stream", zap.NewNop())
+	b.Relay = NewRelay(client, "stream", zap.NewNop())
+	go a.Relay.Run(ctx, a)
+	go b.Relay.Run(ctx, b)
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.Subscribers("stream") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("relays not subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	local, _, _ := a.Subscribe(func(Event) bool { return true }, "")
+	remote, _, _ := b.Subscribe(func(Event) bool { return true }, "")
+
+	// Two writers, as with the consumer and an upload committing at once:
+	// whatever order a's hub sees, b must see the same.
+	var wg sync.WaitGroup
+	for w := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range n / 2 {
+				a.Alert(Alert{Kind: "test", Message: strconv.Itoa(w) + "-" + strconv.Itoa(i)})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range n {
+		want := message(t, <-local.C)
+		select {
+		case e := <-remote.C:
+			if got := message(t, e); got != want {
+				t.Fatalf("event %d relayed as %s, published locally as %s", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d (%s) not relayed", i, want)
+		}
+	}
+	select {
+	case e := <-local.C:
+		t.Errorf("relayed back to its origin: %s", message(t, e))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func message(t *testing.T, e Event) string {
+	t.Helper()
+	var a Alert
+	if err := json.Unmarshal(e.Data, &a); err != nil {
+		t.Fatal(err)
+	}
+	return a.Message
+}
@@ -0,0 +1,237 @@
+// Package validation checks incoming trades before they reach the database.
+// Every ingestion path runs trades through Trade so rejections are reported
+// the same way regardless of where a trade came from.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+// Limits of the NUMERIC(20,8) columns quantities and prices are stored in.
+const (
+	maxScale     = 8
+	maxIntDigits = 12
+
+	maxSymbolLen = 32
+	// maxClockSkew tolerates producers whose clocks run slightly ahead.
+	maxClockSkew = 5 * time.Minute
+)
+
+// Rejection codes.
+const (
+	CodeRequired     = "required"
+	CodeInvalid      = "invalid"
+	CodeInactive     = "inactive"
+	CodeUnknown      = "unknown"
+	CodeInvalidUUID  = "invalid_uuid"
+	CodeZero         = "zero"
+	CodeNotPositive  = "not_positive"
+	CodeOutOfRange   = "out_of_range"
+	CodeTooPrecise   = "too_precise"
+	CodeInTheFuture  = "in_the_future"
+	CodeInvalidChars = "invalid_characters"
+	CodeTooLong      = "too_long"
+)
+
+// FieldError is one reason a trade was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error lists every problem found in a trade, not just the first.
+type Error struct {
+	Fields []FieldError `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+	}
+	return "invalid trade: " + strings.Join(parts, "; ")
+}
+
+func (e *Error) add(field, code, msg string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: msg})
+}
+
+// InstrumentOnly reports whether every reason e gives is that the instrument
+// is unknown to the master or not tradable, i.e. the trade would be valid
+// once the master is updated.
+func (e *Error) InstrumentOnly() bool {
+	for _, f := range e.Fields {
+		if f.Field != "symbol" || (f.Code != CodeUnknown && f.Code != CodeInactive) {
+			return false
+		}
+	}
+	return len(e.Fields) > 0
+}
+
+// AsError returns the *Error wrapped in err, if any.
+func AsError(err error) (*Error, bool) {
+	var ve *Error
+	ok := errors.As(err, &ve)
+	return ve, ok
+}
+
+// Normalize trims free-text fields and lower-cases the enum fields so that
+// "Zurich " and "zurich" are the same entity. A message without an event
+// type books a new trade, which is version 1.
+func Normalize(t models.Trade) models.Trade {
+	t.Event = strings.ToLower(strings.TrimSpace(t.Event))
+	if t.Event == "" {
+		t.Event = models.EventNew
+	}
+	if t.Event == models.EventNew && t.Version == 0 {
+		t.Version = 1
+	}
+	t.TradeID = strings.ToLower(strings.TrimSpace(t.TradeID))
+	t.Entity = strings.ToLower(strings.TrimSpace(t.Entity))
+	t.InstrumentType = strings.ToLower(strings.TrimSpace(t.InstrumentType))
+	t.Symbol = strings.TrimSpace(t.Symbol)
+	return t
+}
+
+// Trade validates a normalized trade. It returns nil or an *Error. A cancel
+// only needs trade_id and version; its other fields are ignored. New trades
+// without a ts are stamped with their receipt time before they get here
+// (see holdings.PrepareTrade); an amend without one keeps the booked ts, so
+// its instrument is only checked against the master, not its trading days.
+func Trade(t models.Trade) error {
+	var e Error
+
+	switch {
+	case t.TradeID == "":
+		e.add("trade_id", CodeRequired, "trade_id is required")
+	case !IsUUID(t.TradeID):
+		e.add("trade_id", CodeInvalidUUID, "trade_id must be a UUID")
+	}
+
+	switch t.Event {
+	case models.EventNew:
+		if t.Version != 1 {
+			e.add("version", CodeInvalid, "a new trade is version 1")
+		}
+	case models.EventAmend, models.EventCancel:
+		switch {
+		case t.Version == 0:
+			e.add("version", CodeRequired, fmt.Sprintf("version is required to %s a trade", t.Event))
+		case t.Version < 2:
+			e.add("version", CodeInvalid, "an amend or cancel creates version 2 or later")
+		}
+	default:
+		e.add("event_type", CodeInvalid, fmt.Sprintf("unknown event_type %q (use 'new', 'amend' or 'cancel')", t.Event))
+	}
+	if t.Event == models.EventCancel {
+		if len(e.Fields) > 0 {
+			return &e
+		}
+		return nil
+	}
+
+	if t.Entity == "" {
+		e.add("entity", CodeRequired, "entity is required")
+	} else if ent, ok := domain.ParseEntity(t.Entity); !ok || ent == domain.EntityAll {
+		e.add("entity", CodeInvalid, fmt.Sprintf("unknown entity %q", t.Entity))
+	} else if !ent.Active() {
+		e.add("entity", CodeInactive, fmt.Sprintf("entity %q is inactive", t.Entity))
+	}
+
+	if t.InstrumentType == "" {
+		e.add("instrument_type", CodeRequired, "instrument_type is required")
+	} else if !domain.InstrumentType(t.InstrumentType).Valid() {
+		e.add("instrument_type", CodeInvalid, fmt.Sprintf("unknown instrument_type %q", t.InstrumentType))
+	}
+
+	switch {
+	case t.Symbol == "":
+		e.add("symbol", CodeRequired, "symbol is required")
+	case len(t.Symbol) > maxSymbolLen:
+		e.add("symbol", CodeTooLong, fmt.Sprintf("symbol must be at most %d characters", maxSymbolLen))
+	case strings.IndexFunc(t.Symbol, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0:
+		e.add("symbol", CodeInvalidChars, "symbol must not contain whitespace or control characters")
+	case domain.InstrumentType(t.InstrumentType).Valid():
+		switch known, tradable := domain.InstrumentTradable(t.InstrumentType, t.Symbol, t.TS); {
+		case !known:
+			e.add("symbol", CodeUnknown, fmt.Sprintf("%s %q is not in the instrument master", t.InstrumentType, t.Symbol))
+		case !tradable && !t.TS.IsZero():
+			e.add("symbol", CodeInactive, fmt.Sprintf("%s %q does not trade on %s", t.InstrumentType, t.Symbol, t.TS.UTC().Format("2006-01-02")))
+		}
+	}
+
+	if t.Quantity.IsZero() {
+		e.add("quantity", CodeZero, "quantity must be non-zero (positive buys, negative sells)")
+	} else {
+		checkNumeric(&e, "quantity", t.Quantity)
+	}
+
+	if t.Price != nil {
+		if t.Price.Sign() <= 0 {
+			e.add("price", CodeNotPositive, "price must be greater than zero")
+		} else {
+			checkNumeric(&e, "price", *t.Price)
+		}
+	}
+
+	switch {
+	case t.TS.IsZero():
+		if t.Event != models.EventAmend {
+			e.add("ts", CodeRequired, "ts is required")
+		}
+	case t.TS.After(time.Now().Add(maxClockSkew)):
+		e.add("ts", CodeInTheFuture, "ts is in the future")
+	}
+
+	if len(e.Fields) > 0 {
+		return &e
+	}
+	return nil
+}
+
+func checkNumeric(e *Error, field string, d decimal.Decimal) {
+	e.Fields = append(e.Fields, Numeric(field, d)...)
+}
+
+// Numeric reports why d does not fit the NUMERIC(20,8) columns quantities
+// and prices are stored in, if it does not: Postgres would round away extra
+// decimal places and reject extra integer digits.
+func Numeric(field string, d decimal.Decimal) []FieldError {
+	var out []FieldError
+	if d.Scale() > maxScale {
+		out = append(out, FieldError{Field: field, Code: CodeTooPrecise, Message: fmt.Sprintf("%s must have at most %d decimal places", field, maxScale)})
+	}
+	if d.IntDigits() > maxIntDigits {
+		out = append(out, FieldError{Field: field, Code: CodeOutOfRange, Message: fmt.Sprintf("%s must have at most %d integer digits", field, maxIntDigits)})
+	}
+	return out
+}
+
+// IsUUID accepts the canonical 8-4-4-4-12 hex form.
+func IsUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/trades-aggregator/internal/decimal"
+	"github.com/example/trades-aggregator/internal/domain"
+	"github.com/example/trades-aggregator/internal/models"
+)
+
+type entities map[string]bool // code -> active
+
+func (r entities) Lookup(code string) (active, ok bool) {
+	active, ok = r[code]
+	return active, ok
+}
+
+type instruments map[string][2]string // "type/symbol" -> active from, to
+
+func (r instruments) ContractSize(string, string) (decimal.Decimal, bool) {
+	return decimal.NewFromInt(1), true
+}
+
+func (r instruments) TradableOn(instrumentType, symbol string, t time.Time) (known, tradable bool) {
+	dates, ok := r[instrumentType+"/"+symbol]
+	day := t.UTC().Format("2006-01-02")
+	return ok, ok && (dates[0] == "" || day >= dates[0]) && (dates[1] == "" || day <= dates[1])
+}
+
+func withRegistries(t *testing.T) {
+	t.Helper()
+	domain.SetEntityRegistry(entities{"zurich": true, "new_york": true, "tokyo": false})
+	domain.SetInstrumentRegistry(instruments{
+		"stock/AAPL":   {},
+		"crypto/BTC":   {},
+		"stock/DELIST": {"", "2020-12-31"},
+	})
+	t.Cleanup(func() {
+		domain.SetEntityRegistry(nil)
+		domain.SetInstrumentRegistry(nil)
+	})
+}
+
+func validTrade() models.Trade {
+	px := decimal.MustParse("189.25")
+	return models.Trade{
+		TradeID:        "0b8e6a52-6f1e-4c1a-9a57-2f4f6f7f4a10",
+		Entity:         "zurich",
+		InstrumentType: "stock",
+		Symbol:         "AAPL",
+		Quantity:       decimal.MustParse("10"),
+		Price:          &px,
+		TS:             time.Now().Add(-time.Hour),
+	}
+}
+
+func TestTrade(t *testing.T) {
+	withRegistries(t)
+	dec := func(s string) *decimal.Decimal { d := decimal.MustParse(s); return &d }
+
+	tests := []struct {
+		name  string
+		edit  func(*models.Trade)
+		field string // "" means valid
+		code  string
+	}{
+		{"valid", func(*models.Trade) {}, "", ""},
+		{"valid sell without price", func(t *models.Trade) { t.Quantity = decimal.MustParse("-0.5"); t.Price = nil }, "", ""},
+		{"valid with 8 places and 12 digits", func(t *models.Trade) { t.Quantity = decimal.MustParse("999999999999.12345678") }, "", ""},
+		{"ts within clock skew", func(t *models.Trade) { t.TS = time.Now().Add(time.Minute) }, "", ""},
+
+		{"missing trade_id", func(t *models.Trade) { t.TradeID = "" }, "trade_id", CodeRequired},
+		{"trade_id not a UUID", func(t *models.Trade) { t.TradeID = "trade-42" }, "trade_id", CodeInvalidUUID},
+		{"trade_id with a bad hex digit", func(t *models.Trade) { t.TradeID = "0b8e6a52-6f1e-4c1a-9a57-2f4f6f7f4a1g" }, "trade_id", CodeInvalidUUID},
+		{"trade_id without dashes", func(t *models.Trade) { t.TradeID = "0b8e6a526f1e4c1a9a572f4f6f7f4a10" }, "trade_id", CodeInvalidUUID},
+
+		{"missing entity", func(t *models.Trade) { t.Entity = "" }, "entity", CodeRequired},
+		{"unknown entity", func(t *models.Trade) { t.Entity = "paris" }, "entity", CodeInvalid},
+		{"entity all", func(t *models.Trade) { t.Entity = "all" }, "entity", CodeInvalid},
+		{"inactive entity", func(t *models.Trade) { t.Entity = "tokyo" }, "entity", CodeInactive},
+
+		{"missing instrument_type", func(t *models.Trade) { t.InstrumentType = "" }, "instrument_type", CodeRequired},
+		{"unknown instrument_type", func(t *models.Trade) { t.InstrumentType = "swap" }, "instrument_type", CodeInvalid},
+
+		{"missing symbol", func(t *models.Trade) { t.Symbol = "" }, "symbol", CodeRequired},
+		{"symbol too long", func(t *models.Trade) { t.Symbol = strings.Repeat("A", maxSymbolLen+1) }, "symbol", CodeTooLong},
+		{"symbol with a space", func(t *models.Trade) { t.Symbol = "AA PL" }, "symbol", CodeInvalidChars},
+		{"symbol with a control character", func(t *models.Trade) { t.Symbol = "AAPL\x00" }, "symbol", CodeInvalidChars},
+		{"symbol not in the master", func(t *models.Trade) { t.Symbol = "MSFT" }, "symbol", CodeUnknown},
+		{"symbol no longer traded", func(t *models.Trade) { t.Symbol = "DELIST" }, "symbol", CodeInactive},
+
+		{"zero quantity", func(t *models.Trade) { t.Quantity = decimal.Zero }, "quantity", CodeZero},
+		{"quantity with 9 places", func(t *models.Trade) { t.Quantity = decimal.MustParse("0.000000001") }, "quantity", CodeTooPrecise},
+		{"quantity with 13 digits", func(t *models.Trade) { t.Quantity = decimal.MustParse("1234567890123") }, "quantity", CodeOutOfRange},
+		{"zero price", func(t *models.Trade) { t.Price = dec("0") }, "price", CodeNotPositive},
+		{"negative price", func(t *models.Trade) { t.Price = dec("-1") }, "price", CodeNotPositive},
+		{"price with 9 places", func(t *models.Trade) { t.Price = dec("1.123456789") }, "price", CodeTooPrecise},
+		{"price with 13 digits", func(t *models.Trade) { t.Price = dec("1000000000000") }, "price", CodeOutOfRange},
+
+		{"missing ts", func(t *models.Trade) { t.TS = time.Time{} }, "ts", CodeRequired},
+		{"amend without ts", func(t *models.Trade) { t.Event = models.EventAmend; t.Version = 2; t.TS = time.Time{} }, "", ""},
+		{"amend without ts of a delisted symbol", func(t *models.Trade) {
+			t.Event, t.Version, t.TS, t.Symbol = models.EventAmend, 2, time.Time{}, "DELIST"
+		}, "", ""},
+		{"amend without ts of an unknown symbol", func(t *models.Trade) {
+			t.Event, t.Version, t.TS, t.Symbol = models.EventAmend, 2, time.Time{}, "MSFT"
+		}, "symbol", CodeUnknown},
+		{"ts in the future", func(t *models.Trade) { t.TS = time.Now().Add(time.Hour) }, "ts", CodeInTheFuture},
+
+		{"new trade not version 1", func(t *models.Trade) { t.Version = 2 }, "version", CodeInvalid},
+		{"amend without version", func(t *models.Trade) { t.Event = models.EventAmend; t.Version = 0 }, "version", CodeRequired},
+		{"amend of version 1", func(t *models.Trade) { t.Event = models.EventAmend; t.Version = 1 }, "version", CodeInvalid},
+		{"unknown event", func(t *models.Trade) { t.Event = "book" }, "event_type", CodeInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := validTrade()
+			tt.edit(&tr)
+			tr = Normalize(tr)
+			err := Trade(tr)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				return
+			}
+			ve, ok := AsError(err)
+			if !ok {
+				t.Fatalf("got %v, want a validation error", err)
+			}
+			if len(ve.Fields) != 1 {
+				t.Fatalf("got %d field errors (%v), want only %s", len(ve.Fields), ve, tt.field)
+			}
+			if f := ve.Fields[0]; f.Field != tt.field || f.Code != tt.code {
+				t.Errorf("got %s/%s, want %s/%s", f.Field, f.Code, tt.field, tt.code)
+			}
+		})
+	}
+}
+
+func TestTradeReportsEveryField(t *testing.T) {
+	withRegistries(t)
+	err := Trade(Normalize(models.Trade{TradeID: "x", Entity: "paris", InstrumentType: "swap", Symbol: "A B"}))
+	ve, ok := AsError(err)
+	if !ok {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	want := []string{"trade_id", "entity", "instrument_type", "symbol", "quantity", "ts"}
+	if len(ve.Fields) != len(want) {
+		t.Fatalf("got %v, want errors for %v", ve, want)
+	}
+	for i, f := range ve.Fields {
+		if f.Field != want[i] {
+			t.Errorf("error %d is for %s, want %s", i, f.Field, want[i])
+		}
+	}
+}
+
+func TestCancelNeedsOnlyIDAndVersion(t *testing.T) {
+	withRegistries(t)
+	tr := Normalize(models.Trade{TradeID: "0B8E6A52-6F1E-4C1A-9A57-2F4F6F7F4A10 ", Event: "Cancel", Version: 3})
+	if err := Trade(tr); err != nil {
+		t.Fatalf("cancel rejected: %v", err)
+	}
+	if tr.TradeID != "0b8e6a52-6f1e-4c1a-9a57-2f4f6f7f4a10" || tr.Event != models.EventCancel {
+		t.Errorf("not normalized: %+v", tr)
+	}
+}
+
+func TestInstrumentOnly(t *testing.T) {
+	withRegistries(t)
+	tr := Normalize(validTrade())
+	tr.Symbol = "MSFT"
+	ve, _ := AsError(Trade(tr))
+	if ve == nil || !ve.InstrumentOnly() {
+		t.Errorf("unknown instrument: InstrumentOnly = false (%v)", ve)
+	}
+	tr.Quantity = decimal.Zero
+	ve, _ = AsError(Trade(tr))
+	if ve == nil || ve.InstrumentOnly() {
+		t.Errorf("unknown instrument and zero quantity: InstrumentOnly = true (%v)", ve)
+	}
+}
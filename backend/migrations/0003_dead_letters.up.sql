@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+  id BIGSERIAL PRIMARY KEY,
+  source_topic TEXT NOT NULL,
+  source_partition INT NOT NULL,
+  source_offset BIGINT NOT NULL,
+  msg_key BYTEA,
+  payload BYTEA NOT NULL,
+  headers JSONB NOT NULL DEFAULT '[]',
+  reason TEXT NOT NULL,
+  error TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  redriven_at TIMESTAMPTZ,
+  UNIQUE (source_topic, source_partition, source_offset)
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_pending ON dead_letters(created_at) WHERE redriven_at IS NULL;
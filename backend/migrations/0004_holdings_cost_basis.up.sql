@@ -0,0 +1,9 @@
+-- Cost basis is tracked at a finer scale than quantities and prices so that
+-- averages and P&L do not lose precision as trades accumulate.
+ALTER TABLE holdings
+  ADD COLUMN IF NOT EXISTS avg_cost NUMERIC(38,16) NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS cost_basis NUMERIC(38,16) NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS realized_pnl NUMERIC(38,16) NOT NULL DEFAULT 0;
+
+-- Existing rows start from zero cost; run `tradesctl rebuild-holdings` to
+-- recompute them from the trades ledger.
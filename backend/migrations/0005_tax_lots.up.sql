@@ -0,0 +1,46 @@
+CREATE TYPE lot_method AS ENUM ('fifo', 'lifo', 'hifo');
+
+-- Lot relief method per entity; entities without a row use FIFO.
+CREATE TABLE IF NOT EXISTS lot_methods (
+  entity entity PRIMARY KEY,
+  method lot_method NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- One lot per opening trade. Quantities are signed: short lots are negative.
+CREATE TABLE IF NOT EXISTS tax_lots (
+  id BIGSERIAL PRIMARY KEY,
+  entity entity NOT NULL,
+  instrument_type instrument_type NOT NULL,
+  symbol TEXT NOT NULL,
+  open_trade_id UUID NOT NULL,
+  opened_at TIMESTAMPTZ NOT NULL,
+  quantity NUMERIC(20,8) NOT NULL,
+  remaining NUMERIC(20,8) NOT NULL,
+  price NUMERIC(20,8),
+  closed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_tax_lots_open ON tax_lots(entity, instrument_type, symbol) WHERE remaining <> 0;
+CREATE INDEX IF NOT EXISTS idx_tax_lots_symbol ON tax_lots(entity, symbol);
+
+-- One row per (lot, closing trade) pair.
+CREATE TABLE IF NOT EXISTS lot_realizations (
+  id BIGSERIAL PRIMARY KEY,
+  lot_id BIGINT NOT NULL REFERENCES tax_lots(id) ON DELETE CASCADE,
+  entity entity NOT NULL,
+  instrument_type instrument_type NOT NULL,
+  symbol TEXT NOT NULL,
+  close_trade_id UUID NOT NULL,
+  quantity NUMERIC(20,8) NOT NULL,
+  open_price NUMERIC(20,8),
+  close_price NUMERIC(20,8),
+  opened_at TIMESTAMPTZ NOT NULL,
+  closed_at TIMESTAMPTZ NOT NULL,
+  gain NUMERIC(38,16)
+);
+
+CREATE INDEX IF NOT EXISTS idx_lot_realizations_symbol ON lot_realizations(entity, symbol);
+
+-- Trades recorded before this migration have no lots yet; run
+-- `tradesctl rebuild-lots` once to book them.
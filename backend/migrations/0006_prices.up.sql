@@ -0,0 +1,8 @@
+-- Last known market price per symbol. Older quotes never overwrite newer ones.
+CREATE TABLE IF NOT EXISTS prices (
+  symbol TEXT PRIMARY KEY,
+  price NUMERIC(20,8) NOT NULL,
+  ts TIMESTAMPTZ NOT NULL,
+  source TEXT NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,23 @@
+-- Checkpoints of holdings folded from the trades ledger in trade-time order,
+-- so point-in-time queries only replay trades after the nearest checkpoint.
+-- A checkpoint covers every trade with ts <= as_of and id <= last_trade_id;
+-- a later-arriving trade with ts <= as_of makes it obsolete.
+CREATE TABLE IF NOT EXISTS holdings_checkpoints (
+  id BIGSERIAL PRIMARY KEY,
+  as_of TIMESTAMPTZ NOT NULL,
+  last_trade_id BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_holdings_checkpoints_as_of ON holdings_checkpoints(as_of);
+
+CREATE TABLE IF NOT EXISTS holdings_checkpoint_positions (
+  checkpoint_id BIGINT NOT NULL REFERENCES holdings_checkpoints(id) ON DELETE CASCADE,
+  entity entity NOT NULL,
+  instrument_type instrument_type NOT NULL,
+  symbol TEXT NOT NULL,
+  quantity NUMERIC(20,8) NOT NULL,
+  avg_cost NUMERIC(38,16) NOT NULL,
+  cost_basis NUMERIC(38,16) NOT NULL,
+  realized_pnl NUMERIC(38,16) NOT NULL,
+  PRIMARY KEY (checkpoint_id, entity, instrument_type, symbol)
+);
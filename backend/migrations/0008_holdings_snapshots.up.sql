@@ -0,0 +1,24 @@
+-- End-of-day position book: the holdings of one entity frozen at its close
+-- for a business date.
+CREATE TABLE IF NOT EXISTS holdings_snapshots (
+  id BIGSERIAL PRIMARY KEY,
+  entity entity NOT NULL,
+  business_date DATE NOT NULL,
+  cutoff_at TIMESTAMPTZ NOT NULL,
+  -- 'close': copied from holdings at the cut-off; 'ledger': taken late and
+  -- folded from trades up to the cut-off instead.
+  source TEXT NOT NULL,
+  taken_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (entity, business_date)
+);
+
+CREATE TABLE IF NOT EXISTS holdings_snapshot_positions (
+  snapshot_id BIGINT NOT NULL REFERENCES holdings_snapshots(id) ON DELETE CASCADE,
+  instrument_type instrument_type NOT NULL,
+  symbol TEXT NOT NULL,
+  quantity NUMERIC(20,8) NOT NULL,
+  avg_cost NUMERIC(38,16) NOT NULL,
+  cost_basis NUMERIC(38,16) NOT NULL,
+  realized_pnl NUMERIC(38,16) NOT NULL,
+  PRIMARY KEY (snapshot_id, instrument_type, symbol)
+);
@@ -0,0 +1,3 @@
+-- Keyset pagination of /api/trades walks (ts, id) newest first.
+CREATE INDEX IF NOT EXISTS idx_trades_ts_id ON trades(ts DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_trades_entity_ts_id ON trades(entity, ts DESC, id DESC);
@@ -0,0 +1,12 @@
+-- How each trade reached us, for support lookups. Trades recorded before
+-- this migration have no processing metadata.
+ALTER TABLE trades
+  ADD COLUMN IF NOT EXISTS source TEXT,
+  ADD COLUMN IF NOT EXISTS source_topic TEXT,
+  ADD COLUMN IF NOT EXISTS source_partition INT,
+  ADD COLUMN IF NOT EXISTS source_offset BIGINT,
+  ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS applied_at TIMESTAMPTZ,
+  -- Deliveries of the same trade_id after the first, which were skipped.
+  ADD COLUMN IF NOT EXISTS duplicate_count INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_duplicate_at TIMESTAMPTZ;
@@ -0,0 +1,30 @@
+-- Trades are versioned: an amend replaces the booked fields of a trade_id
+-- and a cancel voids it. trades keeps the current version of each trade (in
+-- its original arrival position); the versions it replaced move to
+-- trade_versions.
+ALTER TABLE trades
+  ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1,
+  -- The event that produced the current version. Cancelled trades stay in
+  -- the ledger but no longer count towards holdings or lots.
+  ADD COLUMN IF NOT EXISTS event_type TEXT NOT NULL DEFAULT 'new'
+    CHECK (event_type IN ('new', 'amend', 'cancel'));
+
+CREATE TABLE IF NOT EXISTS trade_versions (
+  trade_id UUID NOT NULL REFERENCES trades(trade_id),
+  version INT NOT NULL,
+  event_type TEXT NOT NULL,
+  entity entity NOT NULL,
+  instrument_type instrument_type NOT NULL,
+  symbol TEXT NOT NULL,
+  quantity NUMERIC(20,8) NOT NULL,
+  price NUMERIC(20,8),
+  ts TIMESTAMPTZ NOT NULL,
+  source TEXT,
+  source_topic TEXT,
+  source_partition INT,
+  source_offset BIGINT,
+  received_at TIMESTAMPTZ,
+  applied_at TIMESTAMPTZ,
+  superseded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (trade_id, version)
+);
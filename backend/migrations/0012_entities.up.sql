@@ -0,0 +1,35 @@
+-- Entities are data rather than an enum: opening a desk is a row in this
+-- table (PUT /api/admin/entities/:code), not a migration. Inactive entities
+-- keep their history but accept no new trades.
+CREATE TABLE IF NOT EXISTS entities (
+  code TEXT PRIMARY KEY CHECK (code ~ '^[a-z][a-z0-9_]{1,31}$' AND code <> 'all'),
+  name TEXT NOT NULL,
+  timezone TEXT NOT NULL,
+  base_currency CHAR(3) NOT NULL CHECK (base_currency ~ '^[A-Z]{3}$'),
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO entities (code, name, timezone, base_currency) VALUES
+  ('zurich', 'Zurich', 'Europe/Zurich', 'CHF'),
+  ('new_york', 'New York', 'America/New_York', 'USD')
+ON CONFLICT (code) DO NOTHING;
+
+ALTER TABLE trades ALTER COLUMN entity TYPE TEXT USING entity::text;
+ALTER TABLE trade_versions ALTER COLUMN entity TYPE TEXT USING entity::text;
+ALTER TABLE holdings ALTER COLUMN entity TYPE TEXT USING entity::text;
+ALTER TABLE lot_methods ALTER COLUMN entity TYPE TEXT USING entity::text;
+ALTER TABLE tax_lots ALTER COLUMN entity TYPE TEXT USING entity::text;
+ALTER TABLE lot_realizations ALTER COLUMN entity TYPE TEXT USING entity::text;
+ALTER TABLE holdings_checkpoint_positions ALTER COLUMN entity TYPE TEXT USING entity::text;
+ALTER TABLE holdings_snapshots ALTER COLUMN entity TYPE TEXT USING entity::text;
+
+-- Lots, checkpoints and trade versions derive from trades and holdings, so
+-- those are the rows that must name a registered entity.
+ALTER TABLE trades ADD CONSTRAINT trades_entity_fkey FOREIGN KEY (entity) REFERENCES entities(code);
+ALTER TABLE holdings ADD CONSTRAINT holdings_entity_fkey FOREIGN KEY (entity) REFERENCES entities(code);
+ALTER TABLE lot_methods ADD CONSTRAINT lot_methods_entity_fkey FOREIGN KEY (entity) REFERENCES entities(code);
+ALTER TABLE holdings_snapshots ADD CONSTRAINT holdings_snapshots_entity_fkey FOREIGN KEY (entity) REFERENCES entities(code);
+
+DROP TYPE entity;
@@ -0,0 +1,27 @@
+ALTER TYPE instrument_type ADD VALUE IF NOT EXISTS 'fx';
+ALTER TYPE instrument_type ADD VALUE IF NOT EXISTS 'future';
+ALTER TYPE instrument_type ADD VALUE IF NOT EXISTS 'option';
+ALTER TYPE instrument_type ADD VALUE IF NOT EXISTS 'bond';
+
+-- Instrument master: the contract terms of FX pairs, futures, options and
+-- bonds, which must be registered before they trade
+-- (PUT /api/admin/instruments/:instrument_type/:symbol). The multiplier of a
+-- future or option and the face value of a bond scale their costs and P&L.
+-- Which attributes a type takes is checked by the application: the new
+-- enum values cannot be used until this migration commits.
+CREATE TABLE IF NOT EXISTS instruments (
+  instrument_type instrument_type NOT NULL,
+  symbol TEXT NOT NULL,
+  underlying TEXT,
+  multiplier NUMERIC(20,8) NOT NULL DEFAULT 1 CHECK (multiplier > 0),
+  expiry DATE,
+  strike NUMERIC(20,8) CHECK (strike > 0),
+  option_type TEXT CHECK (option_type IN ('put', 'call')),
+  face_value NUMERIC(20,8) CHECK (face_value > 0),
+  coupon_rate NUMERIC(9,6) CHECK (coupon_rate >= 0),
+  base_currency CHAR(3),
+  quote_currency CHAR(3),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (instrument_type, symbol)
+);
@@ -0,0 +1,34 @@
+-- The instrument master becomes the reference for every instrument, not only
+-- those with contract terms: trades for instruments it does not list, or
+-- outside their active dates, are rejected or quarantined on ingest.
+ALTER TABLE instruments
+  ADD COLUMN IF NOT EXISTS currency CHAR(3) CHECK (currency ~ '^[A-Z]{3}$'),
+  ADD COLUMN IF NOT EXISTS tick_size NUMERIC(20,8) CHECK (tick_size > 0),
+  ADD COLUMN IF NOT EXISTS lot_size NUMERIC(20,8) CHECK (lot_size > 0),
+  ADD COLUMN IF NOT EXISTS isin CHAR(12) UNIQUE CHECK (isin ~ '^[A-Z]{2}[A-Z0-9]{9}[0-9]$'),
+  ADD COLUMN IF NOT EXISTS identifiers JSONB NOT NULL DEFAULT '{}',
+  ADD COLUMN IF NOT EXISTS active_from DATE,
+  ADD COLUMN IF NOT EXISTS active_to DATE,
+  ADD CONSTRAINT instruments_active_dates CHECK (active_to >= active_from);
+
+-- The instruments the demo producer trades.
+INSERT INTO instruments (instrument_type, symbol, currency, tick_size, lot_size) VALUES
+  ('stock', 'AAPL', 'USD', 0.01, 1),
+  ('stock', 'MSFT', 'USD', 0.01, 1),
+  ('stock', 'GOOGL', 'USD', 0.01, 1),
+  ('stock', 'AMZN', 'USD', 0.01, 1),
+  ('stock', 'TSLA', 'USD', 0.01, 1),
+  ('stock', 'NVDA', 'USD', 0.01, 1),
+  ('stock', 'NFLX', 'USD', 0.01, 1),
+  ('crypto', 'BTC', 'USD', 0.01, 0.0001),
+  ('crypto', 'ETH', 'USD', 0.01, 0.0001),
+  ('crypto', 'SOL', 'USD', 0.01, 0.0001),
+  ('crypto', 'ADA', 'USD', 0.01, 0.0001),
+  ('crypto', 'XRP', 'USD', 0.01, 0.0001)
+ON CONFLICT (instrument_type, symbol) DO NOTHING;
+
+-- Everything already traded stays tradable; review these entries (they have
+-- no currency) and end the ones that were typos by setting active_to.
+INSERT INTO instruments (instrument_type, symbol)
+SELECT DISTINCT instrument_type, symbol FROM trades
+ON CONFLICT (instrument_type, symbol) DO NOTHING;
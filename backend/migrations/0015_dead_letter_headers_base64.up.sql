@@ -0,0 +1,11 @@
+-- Header values are bytes and are now stored base64-encoded, like the JSON
+-- the admin API returns; convert those recorded as text.
+UPDATE dead_letters
+SET headers = COALESCE((
+  SELECT jsonb_agg(jsonb_build_object(
+           'key', h.value->>'key',
+           'value', translate(encode(convert_to(h.value->>'value', 'UTF8'), 'base64'), E'\n', ''))
+         ORDER BY h.n)
+  FROM jsonb_array_elements(headers) WITH ORDINALITY AS h(value, n)
+), '[]'::jsonb)
+WHERE headers <> '[]'::jsonb;
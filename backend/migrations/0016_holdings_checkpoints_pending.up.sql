@@ -0,0 +1,4 @@
+-- A checkpoint is recorded before its positions are computed, outside the
+-- lock on trades, so that corrections made meanwhile delete it like any
+-- other checkpoint they invalidate. It only counts once complete.
+ALTER TABLE holdings_checkpoints ADD COLUMN IF NOT EXISTS complete BOOLEAN NOT NULL DEFAULT true;
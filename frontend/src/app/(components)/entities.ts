@@ -0,0 +1,38 @@
+"use client";
+
+import { useEffect, useState } from "react";
+
+const API = process.env.NEXT_PUBLIC_API_URL ?? "http://localhost:8080";
+
+export type EntityOption = { value: string; label: string };
+
+type RegisteredEntity = { code: string; name: string; active: boolean };
+
+/**
+ * Entity filter options from the backend's registry (GET /api/entities),
+ * after the given leading options, e.g. an "All" choice. Inactive entities
+ * are listed too, since their holdings and trades remain.
+ */
+export function useEntityOptions(leading: EntityOption[] = []): EntityOption[] {
+  const [registered, setRegistered] = useState<EntityOption[]>([]);
+
+  useEffect(() => {
+    let active = true;
+    fetch(`${API}/api/entities`, { cache: "no-store" })
+      .then((r) => (r.ok ? r.json() : { entities: [] }))
+      .then((body: { entities: RegisteredEntity[] }) => {
+        if (!active) return;
+        setRegistered(
+          body.entities.map((e) => ({ value: e.code, label: e.name }))
+        );
+      })
+      .catch(() => {
+        /* the leading options still work without the registry */
+      });
+    return () => {
+      active = false;
+    };
+  }, []);
+
+  return [...leading, ...registered];
+}
@@ -2,27 +2,26 @@
 
 import { useEffect, useMemo, useRef, useState } from "react";
 import { GlassCard, Segmented, Stat, cn } from "./(components)/ui";
+import { useEntityOptions } from "./(components)/entities";
 
 const API = process.env.NEXT_PUBLIC_API_URL ?? "http://localhost:8080";
 
 type Holding = {
-  entity: "zurich" | "new_york";
-  instrument_type: "stock" | "crypto";
+  entity: string;
+  instrument_type: InstrumentType;
   symbol: string;
   quantity: number;
 };
-type Entity = "zurich" | "new_york" | "all";
+type InstrumentType = "stock" | "crypto" | "fx" | "future" | "option" | "bond";
 
-type EntityOption = {
-  value: Entity;
-  label: string;
-};
+// Dashboard blocks, in order; stocks and crypto are always shown, the other
+// types once there are holdings.
+const INSTRUMENT_TYPES: InstrumentType[] = ["stock", "crypto", "fx", "future", "option", "bond"];
+const ALWAYS_SHOWN: InstrumentType[] = ["stock", "crypto"];
+
+type Entity = string; // a registered entity code, or "all"
 
-const ENTITY_OPTIONS: EntityOption[] = [
-  { value: "zurich", label: "Zurich" },
-  { value: "new_york", label: "New York" },
-  { value: "all", label: "All" },
-] as const;
+const ALL_OPTION = [{ value: "all", label: "All" }];
 
 const fmtQty = new Intl.NumberFormat(undefined, { maximumFractionDigits: 8 });
 
@@ -70,6 +69,7 @@ function usePolling<T>(fn: () => Promise<T>, deps: unknown[] = [], ms = 5000) {
 
 export default function Page() {
   const [entity, setEntity] = useState<Entity>("all");
+  const entityOptions = useEntityOptions(ALL_OPTION);
 
   const { data, loading, error, reload } = usePolling<Holding[]>(
     async () => {
@@ -88,8 +88,10 @@ export default function Page() {
 
   const rows = data ?? [];
   const grouped = useMemo(() => {
-    const g: Record<"stock" | "crypto", Holding[]> = { stock: [], crypto: [] };
-    for (const h of rows) g[h.instrument_type].push(h);
+    const g = Object.fromEntries(
+      INSTRUMENT_TYPES.map((t) => [t, [] as Holding[]]),
+    ) as Record<InstrumentType, Holding[]>;
+    for (const h of rows) g[h.instrument_type]?.push(h);
     return g;
   }, [rows]);
 
@@ -107,22 +109,29 @@ export default function Page() {
           </button>
         </div>
         <Segmented
-          options={ENTITY_OPTIONS}
+          options={entityOptions}
           value={entity}
           onChange={(v) => setEntity(v as Entity)}
         />
       </GlassCard>
 
       {/* quick stats */}
-      <div className="grid gap-4 sm:grid-cols-3">
+      <div className="grid gap-4 sm:grid-cols-4">
         <Stat label="Positions" value={String(rows.length)} />
         <Stat label="Stocks" value={String(grouped.stock.length)} />
         <Stat label="Crypto" value={String(grouped.crypto.length)} />
+        <Stat
+          label="FX, futures, options, bonds"
+          value={String(
+            rows.length - grouped.stock.length - grouped.crypto.length,
+          )}
+        />
       </div>
 
       {/* data blocks */}
-      {(["stock", "crypto"] as const).map((itype) => {
+      {INSTRUMENT_TYPES.map((itype) => {
         const items = grouped[itype];
+        if (!ALWAYS_SHOWN.includes(itype) && items.length === 0) return null;
         return (
           <GlassCard key={itype}>
             <div className="mb-3 flex items-center justify-between">
@@ -3,15 +3,13 @@
 import { useEffect, useMemo, useRef, useState } from "react";
 import { GlassCard, Segmented, cn } from "../(components)/ui";
 import Trade from "../model/Trade";
+import { useEntityOptions } from "../(components)/entities";
 
 const API = process.env.NEXT_PUBLIC_API_URL ?? "http://localhost:8080";
 
-type Entity = "" | "zurich" | "new_york";
-const ENTITY_OPTIONS: Array<{ value: Entity; label: string }> = [
-  { value: "", label: "All" },
-  { value: "zurich", label: "Zurich" },
-  { value: "new_york", label: "New York" },
-];
+type Entity = string; // a registered entity code, or "" for all
+
+const ALL_OPTION = [{ value: "", label: "All" }];
 
 const fmtQty = new Intl.NumberFormat(undefined, { maximumFractionDigits: 8 });
 const fmtPrice = new Intl.NumberFormat(undefined, {
@@ -75,6 +73,7 @@ function fromNow(iso: string) {
 
 export default function Trades() {
   const [entity, setEntity] = useState<Entity>("");
+  const entityOptions = useEntityOptions(ALL_OPTION);
   const [symbolFilter, setSymbolFilter] = useState("");
 
   const { data, loading, error, reload } = usePolling<Trade[]>(
@@ -113,7 +112,7 @@ export default function Trades() {
 
         <div className="flex flex-wrap items-center gap-3">
           <Segmented
-            options={ENTITY_OPTIONS}
+            options={entityOptions}
             value={entity}
             onChange={(v) => setEntity(v as Entity)}
           />
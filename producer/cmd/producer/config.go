@@ -11,7 +11,11 @@ import (
 type Config struct {
 	Brokers             []string
 	Topic               string
+	PricesTopic         string // empty disables price quotes
 	Rate                int
+	CorrectionPct       int    // share of messages (0-100) amending or cancelling a recent trade
+	EntitiesURL         string // backend entity registry; empty uses the built-in entities
+	EntitiesRefresh     time.Duration
 	ProducerStayAlive   bool
 	ProducerTTL         time.Duration
 	ProducerEnsureTopic bool
@@ -20,6 +24,10 @@ type Config struct {
 func LoadConfig() Config {
 	brokers := parseBrokers(envOr("KAFKA_BROKERS", "kafka:9092"))
 	topic := envOr("KAFKA_TOPIC", "trades")
+	pricesTopic := "prices"
+	if v, ok := os.LookupEnv("PRICES_TOPIC"); ok {
+		pricesTopic = strings.TrimSpace(v)
+	}
 
 	rate := 1
 	if v := strings.TrimSpace(os.Getenv("TRADES_PER_SEC")); v != "" {
@@ -28,6 +36,24 @@ func LoadConfig() Config {
 		}
 	}
 
+	corrections := 0
+	if v := strings.TrimSpace(os.Getenv("CORRECTIONS_PCT")); v != "" {
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 && i <= 100 {
+			corrections = i
+		} else {
+			log.Printf("WARN: invalid CORRECTIONS_PCT=%q, sending no corrections", v)
+		}
+	}
+
+	entitiesRefresh := time.Minute
+	if raw := strings.TrimSpace(os.Getenv("ENTITIES_REFRESH")); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			entitiesRefresh = d
+		} else {
+			log.Printf("WARN: invalid ENTITIES_REFRESH=%q, using default %s", raw, entitiesRefresh)
+		}
+	}
+
 	stayAlive := parseBoolEnv("PRODUCER_STAY_ALIVE", false)
 	ensure := parseBoolEnv("PRODUCER_ENSURE_TOPIC", true)
 
@@ -43,7 +69,11 @@ func LoadConfig() Config {
 	return Config{
 		Brokers:             brokers,
 		Topic:               topic,
+		PricesTopic:         pricesTopic,
 		Rate:                rate,
+		CorrectionPct:       corrections,
+		EntitiesURL:         strings.TrimSpace(os.Getenv("ENTITIES_URL")),
+		EntitiesRefresh:     entitiesRefresh,
 		ProducerStayAlive:   stayAlive,
 		ProducerTTL:         ttl,
 		ProducerEnsureTopic: ensure,
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Decimal is a fixed-point number (units × 10^-places) that marshals to an
+// exact JSON number, so quantities like 0.0013 reach the backend unchanged.
+type Decimal struct {
+	units  int64
+	places int
+}
+
+// decimalFromFloat rounds x to places decimal places.
+func decimalFromFloat(x float64, places int) Decimal {
+	return Decimal{units: int64(math.Round(x * math.Pow10(places))), places: places}
+}
+
+func (d Decimal) String() string {
+	neg := d.units < 0
+	u := d.units
+	if neg {
+		u = -u
+	}
+	s := strconv.FormatInt(u, 10)
+	if d.places > 0 {
+		if pad := d.places + 1 - len(s); pad > 0 {
+			s = strings.Repeat("0", pad) + s
+		}
+		s = s[:len(s)-d.places] + "." + s[len(s)-d.places:]
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if neg {
+		return "-" + s
+	}
+	return s
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) { return []byte(d.String()), nil }
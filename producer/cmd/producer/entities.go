@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entities trades are booked for. They come from the backend's registry
+// when ENTITIES_URL is set, so a newly opened desk starts receiving trades
+// without a producer release; otherwise, or until the first fetch
+// succeeds, these defaults are used.
+var (
+	entitiesMu sync.RWMutex
+	entities   = []string{"zurich", "new_york"}
+)
+
+func currentEntities() []string {
+	entitiesMu.RLock()
+	defer entitiesMu.RUnlock()
+	return entities
+}
+
+// fetchEntities loads the active entities from GET /api/entities.
+func fetchEntities(ctx context.Context, url string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	var body struct {
+		Entities []struct {
+			Code   string `json:"code"`
+			Active bool   `json:"active"`
+		} `json:"entities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	var out []string
+	for _, e := range body.Entities {
+		if e.Active {
+			out = append(out, e.Code)
+		}
+	}
+	return out, nil
+}
+
+// watchEntities refreshes the entity list from url every interval until
+// ctx is done. An empty or failed fetch keeps the previous list.
+func watchEntities(ctx context.Context, url string, every time.Duration) {
+	for {
+		c, cancel := context.WithTimeout(ctx, 5*time.Second)
+		codes, err := fetchEntities(c, url)
+		cancel()
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				log.Printf("producer: entities refresh failed: %v", err)
+			}
+		case len(codes) == 0:
+			log.Printf("producer: no active entities at %s; keeping %v", url, currentEntities())
+		default:
+			entitiesMu.Lock()
+			entities = codes
+			entitiesMu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+		}
+	}
+}
@@ -17,46 +17,40 @@ var (
 	cryptos    = []string{"BTC", "ETH", "SOL", "ADA", "XRP"}
 	cryptoBase = map[string]float64{"BTC": 60000, "ETH": 3200, "SOL": 150, "ADA": 0.45, "XRP": 0.6}
 
-	entities   = []string{"zurich", "new_york"}
 	instrTypes = []string{"stock", "crypto"}
 )
 
-func round(x float64, places int) float64 {
-	scale := math.Pow(10, float64(places))
-	return math.Round(x*scale) / scale
-}
-
 func pick[T any](xs []T) T { return xs[rng.Intn(len(xs))] }
 
 func genTrade() Trade {
-	ent := pick(entities)
+	ent := pick(currentEntities())
 	itype := pick(instrTypes)
 
 	var (
 		sym string
-		qty float64
-		px  float64
+		qty Decimal
+		px  Decimal
 	)
 
 	if itype == "stock" {
 		sym = pick(stocks)
 		base := stockBase[sym]
-		px = round(base*(1+(rng.Float64()-0.5)*0.03), 2) // ±1.5%
-		q := float64(rng.Intn(50) + 1)
+		px = decimalFromFloat(base*(1+(rng.Float64()-0.5)*0.03), 2) // ±1.5%
+		q := int64(rng.Intn(50) + 1)
 		if rng.Intn(2) == 0 {
 			q = -q
 		}
-		qty = q
+		qty = Decimal{units: q}
 	} else {
 		sym = pick(cryptos)
 		base := cryptoBase[sym]
-		px = round(base*(1+(rng.Float64()-0.5)*0.05), 2) // ±2.5%
-		q := 0.001 + rng.Float64()*(0.5-0.001)
-		q = round(q, 4)
+		px = decimalFromFloat(base*(1+(rng.Float64()-0.5)*0.05), 2) // ±2.5%
+		// 0.0010 .. 0.4999 in steps of 0.0001
+		q := int64(10 + rng.Intn(5000-10))
 		if rng.Intn(2) == 0 {
 			q = -q
 		}
-		qty = q
+		qty = Decimal{units: q, places: 4}
 	}
 
 	price := px
@@ -68,5 +62,36 @@ func genTrade() Trade {
 		Quantity:       qty,
 		Price:          &price,
 		TS:             time.Now().UTC(),
+		EventType:      "new",
+		Version:        1,
+	}
+}
+
+// genCorrection returns the next version of t: mostly an amend of its
+// quantity or price, sometimes a cancel. Entity and symbol are kept, so the
+// message shares the original's partition key and is consumed after it.
+func genCorrection(t Trade) Trade {
+	t.Version++
+	t.TS = time.Now().UTC()
+	switch rng.Intn(4) {
+	case 0:
+		t.EventType = "cancel"
+	case 1:
+		t.EventType = "amend"
+		px := decimalFromFloat(float64(t.Price.units)/math.Pow10(t.Price.places)*(1+(rng.Float64()-0.5)*0.01), 2)
+		t.Price = &px
+	default:
+		t.EventType = "amend"
+		q := t.Quantity
+		q.units += int64(rng.Intn(5)+1) * sign(q.units)
+		t.Quantity = q
+	}
+	return t
+}
+
+func sign(n int64) int64 {
+	if n < 0 {
+		return -1
 	}
+	return 1
 }
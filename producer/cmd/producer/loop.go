@@ -10,7 +10,10 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-func runProducerLoop(ctx context.Context, cfg Config, w *kafka.Writer) {
+// runProducerLoop emits trades to w at the configured rate and, when pw is
+// not nil, a price quote at each new trade's price to pw. With corrections
+// enabled some messages amend or cancel an earlier trade instead.
+func runProducerLoop(ctx context.Context, cfg Config, w, pw *kafka.Writer) {
 	rate := cfg.Rate
 	if rate <= 0 {
 		rate = 1
@@ -19,6 +22,8 @@ func runProducerLoop(ctx context.Context, cfg Config, w *kafka.Writer) {
 	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
+	var recent []Trade
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -32,7 +37,7 @@ func runProducerLoop(ctx context.Context, cfg Config, w *kafka.Writer) {
 			// jitter
 			time.Sleep(time.Duration(rng.Intn(150)) * time.Millisecond)
 
-			t := genTrade()
+			t, correction := next(cfg, &recent)
 			b, err := json.Marshal(t)
 			if err != nil {
 				log.Printf("marshal error: %v", err)
@@ -46,8 +51,58 @@ func runProducerLoop(ctx context.Context, cfg Config, w *kafka.Writer) {
 				log.Printf("write error: %v", err)
 				continue
 			}
+			if correction {
+				log.Printf("sent: %s %s v%d qty=%v price=%v", t.EventType, t.TradeID, t.Version, t.Quantity, *t.Price)
+				continue
+			}
 			log.Printf("sent: %s %s %s %s qty=%v price=%v",
 				t.TradeID, t.Entity, t.InstrumentType, t.Symbol, t.Quantity, *t.Price)
+
+			if pw != nil {
+				sendQuote(ctx, pw, Quote{Symbol: t.Symbol, Price: *t.Price, TS: t.TS})
+			}
+		}
+	}
+}
+
+// maxRecent bounds how many sent trades are kept as candidates for amends
+// and cancels.
+const maxRecent = 100
+
+// next returns the next message: a new trade, or, for cfg.CorrectionPct of
+// them, the next version of a recent one. recent tracks the latest version
+// of the trades that can still be corrected.
+func next(cfg Config, recent *[]Trade) (t Trade, correction bool) {
+	rs := *recent
+	if len(rs) > 0 && rng.Intn(100) < cfg.CorrectionPct {
+		i := rng.Intn(len(rs))
+		t = genCorrection(rs[i])
+		if t.EventType == "cancel" {
+			*recent = append(rs[:i], rs[i+1:]...)
+		} else {
+			rs[i] = t
+		}
+		return t, true
+	}
+	t = genTrade()
+	if cfg.CorrectionPct > 0 {
+		if len(rs) == maxRecent {
+			rs = rs[1:]
 		}
+		*recent = append(rs, t)
+	}
+	return t, false
+}
+
+// sendQuote publishes q keyed by symbol so quotes for a symbol stay ordered.
+func sendQuote(ctx context.Context, w *kafka.Writer, q Quote) {
+	b, err := json.Marshal(q)
+	if err != nil {
+		log.Printf("marshal quote error: %v", err)
+		return
+	}
+	msg := kafka.Message{Key: []byte(q.Symbol), Value: b, Time: q.TS}
+	if err := w.WriteMessages(ctx, msg); err != nil {
+		log.Printf("write quote error: %v", err)
 	}
 }
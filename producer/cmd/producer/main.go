@@ -6,6 +6,8 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/segmentio/kafka-go"
 )
 
 func main() {
@@ -27,6 +29,9 @@ func main() {
 	if cfg.ProducerEnsureTopic {
 		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		EnsureTopic(c, cfg.Brokers[0], cfg.Topic)
+		if cfg.PricesTopic != "" {
+			EnsureTopic(c, cfg.Brokers[0], cfg.PricesTopic)
+		}
 		cancel()
 	}
 
@@ -41,8 +46,27 @@ func main() {
 		}
 	}()
 
-	log.Printf("producer: brokers=%v topic=%s rate=%d/s stayAlive=%v ttl=%s", cfg.Brokers, cfg.Topic, cfg.Rate, cfg.ProducerStayAlive, cfg.ProducerTTL)
+	// Optional writer for market price quotes
+	var priceWriter *kafka.Writer
+	if cfg.PricesTopic != "" {
+		priceWriter, err = NewKafkaWriter(cfg.Brokers, cfg.PricesTopic)
+		if err != nil {
+			log.Fatalf("producer: failed to create prices writer: %v", err)
+		}
+		defer func() {
+			if err := priceWriter.Close(); err != nil {
+				log.Printf("producer: prices writer close error: %v", err)
+			}
+		}()
+	}
+
+	// Entities from the backend's registry, when configured
+	if cfg.EntitiesURL != "" {
+		go watchEntities(ctx, cfg.EntitiesURL, cfg.EntitiesRefresh)
+	}
+
+	log.Printf("producer: brokers=%v topic=%s prices=%q rate=%d/s corrections=%d%% entities=%q stayAlive=%v ttl=%s", cfg.Brokers, cfg.Topic, cfg.PricesTopic, cfg.Rate, cfg.CorrectionPct, cfg.EntitiesURL, cfg.ProducerStayAlive, cfg.ProducerTTL)
 
 	// production loop
-	runProducerLoop(ctx, cfg, writer)
+	runProducerLoop(ctx, cfg, writer, priceWriter)
 }
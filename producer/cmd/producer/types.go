@@ -5,10 +5,19 @@ import "time"
 // Trade is the JSON schema the backend expects.
 type Trade struct {
 	TradeID        string    `json:"trade_id"`
-	Entity         string    `json:"entity"`          // "zurich" | "new_york"
+	Entity         string    `json:"entity"`          // a registered entity code, e.g. "zurich"
 	InstrumentType string    `json:"instrument_type"` // "stock" | "crypto"
 	Symbol         string    `json:"symbol"`
-	Quantity       float64   `json:"quantity"`        // +buy / -sell
-	Price          *float64  `json:"price,omitempty"` // quoted currency (USD here)
+	Quantity       Decimal   `json:"quantity"`        // +buy / -sell
+	Price          *Decimal  `json:"price,omitempty"` // quoted currency (USD here)
 	TS             time.Time `json:"ts"`              // RFC3339
+	EventType      string    `json:"event_type"`      // "new" | "amend" | "cancel" (of trade_id)
+	Version        int       `json:"version"`         // 1 when new; amends and cancels carry the version they create
+}
+
+// Quote is a market price observation, published on the prices topic.
+type Quote struct {
+	Symbol string    `json:"symbol"`
+	Price  Decimal   `json:"price"`
+	TS     time.Time `json:"ts"`
 }